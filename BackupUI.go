@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/exp/slog"
+)
+
+// BackupWindow 备份恢复窗口，同时只打开一个
+var BackupWindow fyne.Window
+
+// restoreLineBackup 用备份覆盖队列文件并替换队列内容
+func restoreLineBackup(q *Queue, backup, path string) error {
+	if err := RestoreBackup(backup, path); err != nil {
+		return err
+	}
+	row, err := GetLine(path)
+	if err != nil {
+		return err
+	}
+	q.Engine.Reset(row)
+	return nil
+}
+
+// ShowBackupWindow 打开备份恢复窗口，列出配置文件和各队列文件的自动备份
+func ShowBackupWindow() {
+	if BackupWindow != nil {
+		BackupWindow.RequestFocus()
+		return
+	}
+	w := App.NewWindow("备份恢复")
+	w.Resize(fyne.NewSize(600, 500))
+	BackupWindow = w
+	w.SetOnClosed(func() {
+		BackupWindow = nil
+	})
+
+	// backupCard 一个文件的备份列表，restore 执行恢复，done 为恢复成功后的提示
+	backupCard := func(title, path string, restore func(backup string) error, done string) fyne.CanvasObject {
+		list := container.NewVBox()
+		backups := ListBackups(path)
+		if len(backups) == 0 {
+			list.Add(widget.NewLabel("没有备份"))
+		}
+		for _, b := range backups {
+			b := b
+			text := b.ModTime.Format("2006-01-02 15:04:05")
+			if !b.Valid {
+				text += " (已损坏)"
+			}
+			restoreBtn := widget.NewButton("恢复", func() {
+				dialog.ShowConfirm("恢复备份", "用 "+b.ModTime.Format("01-02 15:04:05")+" 的备份覆盖 "+path+"，当前文件会先被备份", func(ok bool) {
+					if !ok {
+						return
+					}
+					if err := restore(b.Path); err != nil {
+						slog.Error("恢复备份失败", err, slog.String("path", b.Path))
+						dialog.ShowError(DisplayError{Message: "恢复备份失败：" + err.Error()}, w)
+						return
+					}
+					dialog.ShowInformation("恢复备份", done, w)
+				}, w)
+			})
+			if !b.Valid {
+				restoreBtn.Disable()
+			}
+			list.Add(container.NewBorder(nil, nil, nil, restoreBtn, widget.NewLabel(text)))
+		}
+		return widget.NewCard(title, path, list)
+	}
+
+	configFile := DataPath(ConfigFile)
+	cards := container.NewVBox(backupCard("配置", configFile, func(backup string) error {
+		return RestoreBackup(backup, configFile)
+	}, "配置已恢复，重新启动后生效"))
+	for _, q := range queues.All() {
+		q := q
+		_, _, lineFile := queueFiles(q.Name)
+		cards.Add(backupCard(q.Title(), lineFile, func(backup string) error {
+			return restoreLineBackup(q, backup, lineFile)
+		}, "队列已恢复"))
+	}
+
+	w.SetContent(container.NewBorder(
+		widget.NewLabel("配置和队列文件保存时自动备份，每10分钟最多一份，最多保留5份"),
+		nil, nil, nil,
+		container.NewVScroll(cards),
+	))
+	w.Show()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// BlacklistFile 黑名单文件
+const BlacklistFile = "blacklist.json"
+
+var ErrNotBlacklisted = errors.New("user not in blacklist")
+
+// BlacklistEntry 一条黑名单，时间为秒级时间戳，EndTime 为0时永久有效
+type BlacklistEntry struct {
+	OpenID    string
+	UserName  string
+	Reason    string
+	CreatedAt int64
+	EndTime   int64
+}
+
+// Expired 是否已过期
+func (b BlacklistEntry) Expired(Now time.Time) bool {
+	return b.EndTime > 0 && b.EndTime <= Now.Unix()
+}
+
+// Blacklist 黑名单，按 OpenID 保存，每次修改后写入文件，过期的记录在读取时清理
+type Blacklist struct {
+	mu      sync.Mutex
+	path    string
+	loaded  bool
+	entries map[string]BlacklistEntry
+}
+
+// blacklist 启动时由 InitDataDir 改为配置档案目录中的文件
+var blacklist = NewBlacklist(BlacklistFile)
+
+func NewBlacklist(path string) *Blacklist {
+	return &Blacklist{path: path, entries: make(map[string]BlacklistEntry)}
+}
+
+// load 首次使用时读取黑名单文件，文件不存在时为空名单
+func (b *Blacklist) load() {
+	if b.loaded {
+		return
+	}
+	b.loaded = true
+	file, err := os.ReadFile(b.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("黑名单读取失败", err)
+		}
+		return
+	}
+	var list []BlacklistEntry
+	if err = json.Unmarshal(file, &list); err != nil {
+		slog.Error("黑名单解析失败", err)
+		return
+	}
+	for _, entry := range list {
+		b.entries[entry.OpenID] = entry
+	}
+}
+
+// save 写入黑名单文件，调用时需持有锁
+func (b *Blacklist) save() error {
+	data, err := json.MarshalIndent(b.list(), "", " ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(b.path, data)
+}
+
+// list 清理过期记录并按加入时间排序，调用时需持有锁
+func (b *Blacklist) list() []BlacklistEntry {
+	now := time.Now()
+	res := make([]BlacklistEntry, 0, len(b.entries))
+	for id, entry := range b.entries {
+		if entry.Expired(now) {
+			delete(b.entries, id)
+			continue
+		}
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt < res[j].CreatedAt
+	})
+	return res
+}
+
+// Add 添加或更新黑名单，Duration 为0时永久有效，返回原有的记录用于撤销
+func (b *Blacklist) Add(OpenID, UserName, Reason string, Duration time.Duration) (BlacklistEntry, bool, error) {
+	if OpenID == "" {
+		return BlacklistEntry{}, false, ErrEmptyOpenID
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	old, existed := b.entries[OpenID]
+	now := time.Now()
+	entry := BlacklistEntry{OpenID: OpenID, UserName: UserName, Reason: Reason, CreatedAt: now.Unix()}
+	if Duration > 0 {
+		entry.EndTime = now.Add(Duration).Unix()
+	}
+	b.entries[OpenID] = entry
+	return old, existed, b.save()
+}
+
+// Restore 恢复一条黑名单记录，用于撤销解除拉黑
+func (b *Blacklist) Restore(entry BlacklistEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	b.entries[entry.OpenID] = entry
+	return b.save()
+}
+
+// Remove 解除拉黑
+func (b *Blacklist) Remove(OpenID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	if _, ok := b.entries[OpenID]; !ok {
+		return ErrNotBlacklisted
+	}
+	delete(b.entries, OpenID)
+	return b.save()
+}
+
+// Check 用户是否在有效的黑名单中
+func (b *Blacklist) Check(OpenID string) (BlacklistEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	entry, ok := b.entries[OpenID]
+	if !ok || entry.Expired(time.Now()) {
+		return BlacklistEntry{}, false
+	}
+	return entry, true
+}
+
+// List 有效的黑名单，按加入时间排序
+func (b *Blacklist) List() []BlacklistEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	return b.list()
+}
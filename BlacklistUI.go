@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/exp/slog"
+)
+
+// 拉黑时长选项，与 banDurations 一一对应
+var banDurationNames = []string{"永久", "1小时", "1天", "7天", "30天"}
+var banDurations = []time.Duration{0, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// BlacklistWindow 黑名单管理窗口，同时只打开一个
+var BlacklistWindow fyne.Window
+
+// banForm 拉黑原因和时长的输入项
+func banForm() (*widget.Entry, *widget.Select, []*widget.FormItem) {
+	reasonEntry := widget.NewEntry()
+	reasonEntry.SetPlaceHolder("拉黑原因，可留空")
+	durationSelect := widget.NewSelect(banDurationNames, nil)
+	durationSelect.SetSelectedIndex(0)
+	return reasonEntry, durationSelect, []*widget.FormItem{
+		widget.NewFormItem("原因", reasonEntry),
+		widget.NewFormItem("时长", durationSelect),
+	}
+}
+
+// showBanDialog 拉黑队列中的用户，用户会被移出队列
+func showBanDialog(w fyne.Window, OpenID, UserName string) {
+	reasonEntry, durationSelect, items := banForm()
+	dialog.ShowForm("拉黑 "+UserName, "拉黑", "取消", items, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		Duration := banDurations[durationSelect.SelectedIndex()]
+		go func() {
+			if err := OperatorBan(OpenID, UserName, strings.TrimSpace(reasonEntry.Text), Duration); err != nil {
+				slog.Error("拉黑失败", err, slog.String("OpenID", OpenID))
+				fyne.Do(func() {
+					dialog.ShowError(DisplayError{Message: "拉黑失败：" + err.Error()}, w)
+				})
+			}
+		}()
+	}, w)
+}
+
+// ShowBlacklistWindow 打开黑名单管理窗口，可按 OpenID 添加和解除拉黑
+func ShowBlacklistWindow() {
+	if BlacklistWindow != nil {
+		BlacklistWindow.RequestFocus()
+		return
+	}
+	w := App.NewWindow("黑名单")
+	w.Resize(fyne.NewSize(600, 500))
+	BlacklistWindow = w
+	w.SetOnClosed(func() {
+		BlacklistWindow = nil
+	})
+
+	list := container.NewVBox()
+	var render func()
+	render = func() {
+		list.RemoveAll()
+		entries := blacklist.List()
+		if len(entries) == 0 {
+			list.Add(widget.NewLabel("黑名单为空"))
+		}
+		for _, entry := range entries {
+			entry := entry
+			until := "永久"
+			if entry.EndTime > 0 {
+				until = "至 " + time.Unix(entry.EndTime, 0).Format("2006-01-02 15:04")
+			}
+			info := widget.NewLabel(fmt.Sprintf("%s (%s)  %s  %s", entry.UserName, entry.OpenID, until, entry.Reason))
+			info.Wrapping = fyne.TextWrapWord
+			unbanBtn := widget.NewButton("解除", func() {
+				if err := OperatorUnban(entry.OpenID); err != nil {
+					dialog.ShowError(DisplayError{Message: "解除拉黑失败：" + err.Error()}, w)
+					return
+				}
+				render()
+			})
+			list.Add(container.NewBorder(nil, nil, nil, unbanBtn, info))
+		}
+		list.Refresh()
+	}
+	render()
+
+	openIDEntry := widget.NewEntry()
+	openIDEntry.SetPlaceHolder("OpenID")
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("用户名，可留空")
+	addBtn := widget.NewButton("添加", func() {
+		reasonEntry, durationSelect, items := banForm()
+		items = append([]*widget.FormItem{
+			widget.NewFormItem("OpenID", openIDEntry),
+			widget.NewFormItem("用户名", nameEntry),
+		}, items...)
+		dialog.ShowForm("添加黑名单", "拉黑", "取消", items, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			OpenID := strings.TrimSpace(openIDEntry.Text)
+			if OpenID == "" {
+				dialog.ShowError(DisplayError{Message: "OpenID不能为空"}, w)
+				return
+			}
+			UserName := strings.TrimSpace(nameEntry.Text)
+			if UserName == "" {
+				UserName = OpenID
+			}
+			err := OperatorBan(OpenID, UserName, strings.TrimSpace(reasonEntry.Text), banDurations[durationSelect.SelectedIndex()])
+			if err != nil {
+				dialog.ShowError(DisplayError{Message: "拉黑失败：" + err.Error()}, w)
+				return
+			}
+			openIDEntry.SetText("")
+			nameEntry.SetText("")
+			render()
+		}, w)
+	})
+	refreshBtn := widget.NewButton("刷新", render)
+
+	w.SetContent(container.NewBorder(
+		widget.NewLabel("黑名单用户不能通过弹幕、礼物、醒目留言或大航海加入任何队列"),
+		container.NewHBox(layout.NewSpacer(), refreshBtn, addBtn),
+		nil, nil,
+		container.NewVScroll(list),
+	))
+	w.Show()
+}
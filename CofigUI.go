@@ -3,13 +3,14 @@ package main
 import (
 	"image/color"
 	"strconv"
-	"time"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
+	"golang.org/x/exp/slog"
 )
 
 func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
@@ -33,6 +34,25 @@ func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 	}
 	LineKeyInput.SetPlaceHolder("请输入排队关键词")
 
+	QueuesInput := widget.NewMultiLineEntry()
+	QueuesInput.SetPlaceHolder("额外队列，每行一条：标识|名称|关键词|容量|粉丝牌等级|大航海等级，页面使用 /web?queue=标识")
+	QueuesInput.Text = FormatQueueConfigs(Config.Queues)
+
+	RequireMedalSwitch := widget.NewCheck("排队需要佩戴本直播间粉丝牌", func(b bool) {})
+	RequireMedalSwitch.Checked = Config.JoinRule.RequireMedal
+
+	MinMedalLevelInput := widget.NewEntry()
+	MinMedalLevelInput.SetPlaceHolder("排队需要的最低粉丝牌等级，留空不限")
+	if Config.JoinRule.MinMedalLevel > 0 {
+		MinMedalLevelInput.Text = strconv.Itoa(Config.JoinRule.MinMedalLevel)
+	}
+
+	MinGuardLevelSelect := widget.NewSelect([]string{"排队不要求大航海", "仅限总督排队", "仅限提督及以上排队", "仅限舰长及以上排队"}, nil)
+	MinGuardLevelSelect.SetSelectedIndex(0)
+	if Config.JoinRule.MinGuardLevel > 0 && Config.JoinRule.MinGuardLevel <= 3 {
+		MinGuardLevelSelect.SetSelectedIndex(Config.JoinRule.MinGuardLevel)
+	}
+
 	GiftJoinLine := widget.NewCheck("当有用户赠送大于设定值的礼物时自动加入队列", func(b bool) {})
 	GiftJoinLine.Checked = Config.AutoJoinGiftLine
 
@@ -52,6 +72,30 @@ func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 
 	IsOnlyGiftSwitch.Checked = Config.IsOnlyGift
 
+	GuardAutoJoinSwitch := widget.NewCheck("弹幕带有大航海标识的用户自动加入舰长队列", func(b bool) {})
+	GuardAutoJoinSwitch.Checked = Config.GuardAutoJoin
+
+	GuardIgnoreMaxLineSwitch := widget.NewCheck("大航海用户不受队列最大容量限制", func(b bool) {})
+	GuardIgnoreMaxLineSwitch.Checked = Config.GuardIgnoreMaxLine
+
+	GuardIgnoreOnlyGiftSwitch := widget.NewCheck("开启仅限付费用户排队时大航海用户仍可排队", func(b bool) {})
+	GuardIgnoreOnlyGiftSwitch.Checked = Config.GuardIgnoreOnlyGift
+
+	GuardBuyAutoJoinSwitch := widget.NewCheck("开通大航海的用户自动加入舰长队列", func(b bool) {})
+	GuardBuyAutoJoinSwitch.Checked = Config.GuardBuyAutoJoin
+
+	GuardBuySpecialUserSwitch := widget.NewCheck("开通大航海的用户在有效期内成为特殊用户", func(b bool) {})
+	GuardBuySpecialUserSwitch.Checked = Config.GuardBuySpecialUser
+
+	SuperChatJoinLineSwitch := widget.NewCheck("发送醒目留言的用户自动加入礼物队列", func(b bool) {})
+	SuperChatJoinLineSwitch.Checked = Config.SuperChatJoinLine
+
+	SuperChatPriceInput := widget.NewEntry()
+	SuperChatPriceInput.SetPlaceHolder("醒目留言加入队列的最低价值(电池，1元=10电池)，留空不限")
+	if Config.SuperChatLinePrice > 0 {
+		SuperChatPriceInput.Text = strconv.FormatFloat(Config.SuperChatLinePrice, 'f', -1, 64)
+	}
+
 	Guard := canvas.NewText("舰长", color.RGBA{R: 255, G: 255, B: 255, A: 255})
 	if !Config.GuardPrintColor.IsEmpty() {
 		Guard.Color = Config.GuardPrintColor.ToRGBA()
@@ -90,6 +134,101 @@ func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 		GiftPriceInput.Text = strconv.FormatFloat(Config.GiftLinePrice, 'f', -1, 64)
 	}
 
+	GiftCumulativePriceInput := widget.NewEntry()
+	GiftCumulativePriceInput.SetPlaceHolder("本场累计礼物价值门槛(电池)，留空不启用")
+	if Config.GiftRules.CumulativePrice > 0 {
+		GiftCumulativePriceInput.Text = strconv.FormatFloat(Config.GiftRules.CumulativePrice, 'f', -1, 64)
+	}
+
+	GiftAllowIDsInput := widget.NewEntry()
+	GiftAllowIDsInput.SetPlaceHolder("只统计的礼物ID，逗号分隔，留空统计全部")
+	GiftAllowIDsInput.Text = FormatGiftIDList(Config.GiftRules.AllowGiftIDs)
+
+	GiftDenyIDsInput := widget.NewEntry()
+	GiftDenyIDsInput.SetPlaceHolder("不统计的礼物ID，逗号分隔")
+	GiftDenyIDsInput.Text = FormatGiftIDList(Config.GiftRules.DenyGiftIDs)
+
+	GiftOverridesInput := widget.NewMultiLineEntry()
+	GiftOverridesInput.SetPlaceHolder("礼物价值覆盖，每行一条：礼物ID=价值(电池)")
+	GiftOverridesInput.Text = FormatGiftOverrides(Config.GiftRules.ValueOverrides)
+
+	CountFreeGiftSwitch := widget.NewCheck("免费(银瓜子)礼物也计入礼物价值", func(b bool) {})
+	CountFreeGiftSwitch.Checked = Config.GiftRules.CountFreeGift
+
+	// 为空时使用默认层级
+	EditedTiers := Config.LineTiers
+	EditTiersButton := widget.NewButton("编辑队列层级", func() {
+		tiers := EditedTiers
+		if len(tiers) == 0 {
+			tiers = DefaultLineTiers(Config)
+		}
+		ShowLineTierEditor(tiers, func(res []LineTier) {
+			EditedTiers = res
+		})
+	})
+
+	RejoinCooldownInput := widget.NewEntry()
+	RejoinCooldownInput.SetPlaceHolder("被叫号或取消排队后重新排队的冷却时间(秒)，留空不限")
+	if Config.ServeRules.RejoinCooldown > 0 {
+		RejoinCooldownInput.Text = strconv.Itoa(Config.ServeRules.RejoinCooldown)
+	}
+
+	MaxServeInput := widget.NewEntry()
+	MaxServeInput.SetPlaceHolder("每场直播每人最多被叫号次数，留空不限")
+	if Config.ServeRules.MaxServePerSession > 0 {
+		MaxServeInput.Text = strconv.Itoa(Config.ServeRules.MaxServePerSession)
+	}
+
+	AwayTimeoutInput := widget.NewEntry()
+	AwayTimeoutInput.SetPlaceHolder("暂离(不在)超过多少分钟后自动移出队列，留空不移出")
+	if Config.AwayTimeout > 0 {
+		AwayTimeoutInput.Text = strconv.Itoa(Config.AwayTimeout)
+	}
+
+	IdleTimeoutInput := widget.NewEntry()
+	IdleTimeoutInput.SetPlaceHolder("超过多少分钟没有弹幕、礼物、点赞或进入直播间时标记为不在，留空不标记")
+	if Config.IdleTimeout > 0 {
+		IdleTimeoutInput.Text = strconv.Itoa(Config.IdleTimeout)
+	}
+
+	ExemptPaidTiersSwitch := widget.NewCheck("舰长、礼物层级不受冷却和次数限制(自定义层级在层级编辑中设置)", func(b bool) {})
+	ExemptPaidTiersSwitch.Checked = Config.ServeRules.ExemptPaidTiers
+
+	RollCall := Config.RollCall
+	if RollCall.Timeout <= 0 {
+		RollCall = DefaultRollCallConfig()
+	}
+	RollCallSwitch := widget.NewCheck("叫号确认(队首用户需在限定时间内发送\"到\")", func(b bool) {})
+	RollCallSwitch.Checked = Config.RollCall.Enabled
+	RollCallTimeoutInput := widget.NewEntry()
+	RollCallTimeoutInput.SetPlaceHolder("叫号确认时间(秒)")
+	RollCallTimeoutInput.Text = strconv.Itoa(RollCall.Timeout)
+	RollCallPolicySelect := widget.NewSelect(rollCallPolicyNames, func(s string) {})
+	RollCallPolicySelect.SetSelectedIndex(0)
+	for i, v := range rollCallPolicyValues {
+		if v == RollCall.Policy {
+			RollCallPolicySelect.SetSelectedIndex(i)
+		}
+	}
+	RollCallSkipByInput := widget.NewEntry()
+	RollCallSkipByInput.SetPlaceHolder("超时后移的位数")
+	RollCallSkipByInput.Text = strconv.Itoa(RollCall.SkipBy)
+
+	NoteDisplaySwitch := widget.NewCheck("队列页面显示排队备注(\"排队 备注内容\"，排队后可发送\"备注 新内容\"修改)", func(b bool) {})
+	NoteDisplaySwitch.Checked = Config.NoteDisplay
+
+	ModeratorsInput := widget.NewMultiLineEntry()
+	ModeratorsInput.SetPlaceHolder("房管，每行一条：OpenID|名称，可发送下一位、暂停排队、恢复排队、清空、移除 用户名、置顶 用户名，主播总是可以")
+	ModeratorsInput.Text = FormatModerators(Config.Moderators)
+
+	// 为空时使用默认指令
+	EditedCommands := Config.Commands
+	EditCommandsButton := widget.NewButton("编辑弹幕指令", func() {
+		ShowCommandEditor(ActiveCommands(RunConfig{Commands: EditedCommands}), func(res []CommandConfig) {
+			EditedCommands = res
+		})
+	})
+
 	DisplayQueSize := widget.NewCheck("显示当前队列长度", func(b bool) {})
 	DisplayQueSize.Checked = Config.CurrentQueueSizeDisplay
 
@@ -115,28 +254,65 @@ func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 		LineMaxLengthInput.Text = strconv.Itoa(Config.MaxLineCount)
 	}
 
-	StartButton := widget.NewButton("保存配置并开始", func() {
-		GiftLinePriceFloat64, err := strconv.ParseFloat(GiftPriceInput.Text, 10)
-		LineMaxLengthInt, err := strconv.Atoi(LineMaxLengthInput.Text)
-		ScrollIntervalInt, err := strconv.Atoi(ScrollIntervalInput.Text)
-
-		switch {
-		case len(IdCodeInput.Text) == 0:
-			dialog.ShowError(DisplayError{Message: "房间号不能为空"}, Windows)
-			return
-		case GiftJoinLine.Checked && GiftLinePriceFloat64 <= 0:
-			dialog.ShowError(DisplayError{Message: "礼物价格应该大于0"}, Windows)
-			return
-
-		case LineMaxLengthInt <= 0:
-			dialog.ShowError(DisplayError{Message: "队列最大容量应该大于0"}, Windows)
-			return
+	var StartButton *widget.Button
+	StartButton = widget.NewButton("保存配置并开始", func() {
+		// 输入解析错误和配置校验错误一起显示
+		var errs ConfigErrors
+		parseInt := func(Field, Text string) int {
+			if Text = strings.TrimSpace(Text); Text == "" {
+				return 0
+			}
+			v, err := strconv.Atoi(Text)
+			if err != nil {
+				errs.Add(Field, "应该是整数：%s", Text)
+			}
+			return v
+		}
+		parseFloat := func(Field, Text string) float64 {
+			if Text = strings.TrimSpace(Text); Text == "" {
+				return 0
+			}
+			v, err := strconv.ParseFloat(Text, 64)
+			if err != nil {
+				errs.Add(Field, "应该是数字：%s", Text)
+			}
+			return v
 		}
 
+		GiftLinePriceFloat64 := parseFloat("GiftLinePrice", GiftPriceInput.Text)
+		LineMaxLengthInt := parseInt("MaxLineCount", LineMaxLengthInput.Text)
+		ScrollIntervalInt := parseInt("ScrollInterval", ScrollIntervalInput.Text)
+		MinMedalLevelInt := parseInt("JoinRule.MinMedalLevel", MinMedalLevelInput.Text)
+		RejoinCooldownInt := parseInt("ServeRules.RejoinCooldown", RejoinCooldownInput.Text)
+		MaxServeInt := parseInt("ServeRules.MaxServePerSession", MaxServeInput.Text)
+		AwayTimeoutInt := parseInt("AwayTimeout", AwayTimeoutInput.Text)
+		IdleTimeoutInt := parseInt("IdleTimeout", IdleTimeoutInput.Text)
+		RollCallTimeoutInt := parseInt("RollCall.Timeout", RollCallTimeoutInput.Text)
+		RollCallSkipByInt := parseInt("RollCall.SkipBy", RollCallSkipByInput.Text)
+		SuperChatPriceFloat64 := parseFloat("SuperChatLinePrice", SuperChatPriceInput.Text)
+		GiftCumulativeFloat64 := parseFloat("GiftRules.CumulativePrice", GiftCumulativePriceInput.Text)
+
+		GiftAllowIDs, AllowErr := ParseGiftIDList(GiftAllowIDsInput.Text)
+		errs.AddErr("GiftRules.AllowGiftIDs", AllowErr)
+		GiftDenyIDs, DenyErr := ParseGiftIDList(GiftDenyIDsInput.Text)
+		errs.AddErr("GiftRules.DenyGiftIDs", DenyErr)
+		GiftOverrides, OverridesErr := ParseGiftOverrides(GiftOverridesInput.Text)
+		errs.AddErr("GiftRules.ValueOverrides", OverridesErr)
+		Queues, QueuesErr := ParseQueueConfigs(QueuesInput.Text)
+		errs.AddErr("Queues", QueuesErr)
+		Moderators, ModeratorsErr := ParseModerators(ModeratorsInput.Text)
+		errs.AddErr("Moderators", ModeratorsErr)
+
 		if LineKeyInput.Text == "" {
 			LineKeyInput.Text = "排队"
 		}
 
+		// 运行中特殊用户名单可能已经变化，以当前名单为准
+		SpecialUsers := Config.SpecialUserList
+		if Current := Configuration().SpecialUserList; Current != nil {
+			SpecialUsers = Current
+		}
+
 		SaveConfig := RunConfig{
 			IdCode:                  IdCodeInput.Text,
 			GuardPrintColor:         ToLineColor(Guard.Color),
@@ -155,34 +331,117 @@ func MakeConfigUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 			DmDisplayNoSleep:        EnableDmDisplayNoSleep.Checked,
 			ScrollInterval:          ScrollIntervalInt * 2,
 			AutoScrollLine:          AutoScrollLine.Checked,
+			SpecialUserList:         SpecialUsers,
+			GuardAutoJoin:           GuardAutoJoinSwitch.Checked,
+			GuardIgnoreMaxLine:      GuardIgnoreMaxLineSwitch.Checked,
+			GuardIgnoreOnlyGift:     GuardIgnoreOnlyGiftSwitch.Checked,
+			GuardBuyAutoJoin:        GuardBuyAutoJoinSwitch.Checked,
+			GuardBuySpecialUser:     GuardBuySpecialUserSwitch.Checked,
+			SuperChatJoinLine:       SuperChatJoinLineSwitch.Checked,
+			SuperChatLinePrice:      SuperChatPriceFloat64,
+			GiftRules: GiftRuleConfig{
+				CumulativePrice: GiftCumulativeFloat64,
+				AllowGiftIDs:    GiftAllowIDs,
+				DenyGiftIDs:     GiftDenyIDs,
+				ValueOverrides:  GiftOverrides,
+				CountFreeGift:   CountFreeGiftSwitch.Checked,
+			},
+			LineTiers: EditedTiers,
+			Queues:    Queues,
+			ServeRules: ServeRuleConfig{
+				RejoinCooldown:     RejoinCooldownInt,
+				MaxServePerSession: MaxServeInt,
+				ExemptPaidTiers:    ExemptPaidTiersSwitch.Checked,
+			},
+			NoteDisplay: NoteDisplaySwitch.Checked,
+			Commands:    EditedCommands,
+			Moderators:  Moderators,
+			AwayTimeout: AwayTimeoutInt,
+			IdleTimeout: IdleTimeoutInt,
+			RollCall: RollCallConfig{
+				Enabled: RollCallSwitch.Checked,
+				Timeout: RollCallTimeoutInt,
+				Policy:  rollCallPolicyValues[RollCallPolicySelect.SelectedIndex()],
+				SkipBy:  RollCallSkipByInt,
+			},
+			JoinRule: JoinRule{
+				RequireMedal:  RequireMedalSwitch.Checked,
+				MinMedalLevel: MinMedalLevelInt,
+				MinGuardLevel: MinGuardLevelSelect.SelectedIndex(),
+			},
 		}
 
-		KeyWordMatchMap = make(map[string]bool)
-		KeyWordMatchInit(SaveConfig.LineKey)
-
-		if err != nil {
-			dialog.ShowError(err, Windows)
-		} else {
-			globalConfiguration = SaveConfig
-			SetConfig(SaveConfig)
-			dialog.ShowInformation("保存成功", "配置已保存,如果涉及身份码修改,请重启", Windows)
-			Restart()
-			time.Sleep(1 * time.Second)
-			Windows.SetContent(MakeMainUI(Windows, SaveConfig))
-
+		// 输入无法解析的字段不再重复报告校验错误
+		errs.Merge(ValidateConfig(SaveConfig))
+		errs.AddErr("Queues", queues.CheckRemoval(SaveConfig))
+		if len(errs) > 0 {
+			dialog.ShowError(errs, Windows)
+			return
 		}
+		SetConfig(SaveConfig)
+		// 配置立即生效，只有身份码变化时重新连接弹幕服务器，连接期间不阻塞界面
+		StartButton.Disable()
+		connecting := dialog.NewCustomWithoutButtons("正在连接", widget.NewProgressBarInfinite(), Windows)
+		connecting.Show()
+		go func() {
+			err := ApplyConfig(SaveConfig)
+			var content fyne.CanvasObject
+			if err == nil {
+				content = MakeMainUI(Windows, SaveConfig)
+			}
+			fyne.Do(func() {
+				connecting.Hide()
+				StartButton.Enable()
+				if err != nil {
+					slog.Error("连接弹幕服务器失败", err)
+					dialog.ShowError(DisplayError{Message: "配置已保存，但连接弹幕服务器失败，请检查身份码"}, Windows)
+					return
+				}
+				Windows.SetContent(content)
+				dialog.ShowInformation("保存成功", "配置已保存并生效", Windows)
+			})
+		}()
 	})
 	return container.NewVBox(
 		IdCodeInput,
 		OpenFanfan,
 		LineKeyInput,
+		RequireMedalSwitch,
+		MinMedalLevelInput,
+		MinGuardLevelSelect,
+		QueuesInput,
 		IsOnlyGiftSwitch,
+		GuardAutoJoinSwitch,
+		GuardIgnoreMaxLineSwitch,
+		GuardIgnoreOnlyGiftSwitch,
+		GuardBuyAutoJoinSwitch,
+		GuardBuySpecialUserSwitch,
+		SuperChatJoinLineSwitch,
+		SuperChatPriceInput,
 		GiftPriceDisplaySwitch,
 		TransparentBackgroundCheck,
 		SelectLineColor,
 		GiftJoinLine,
 		GiftPriceInput,
+		GiftCumulativePriceInput,
+		GiftAllowIDsInput,
+		GiftDenyIDsInput,
+		GiftOverridesInput,
+		CountFreeGiftSwitch,
+		EditTiersButton,
+		EditCommandsButton,
+		ModeratorsInput,
+		RejoinCooldownInput,
+		MaxServeInput,
+		IdleTimeoutInput,
+		AwayTimeoutInput,
+		ExemptPaidTiersSwitch,
 		DisplayQueSize,
+		NoteDisplaySwitch,
+		RollCallSwitch,
+		RollCallTimeoutInput,
+		RollCallPolicySelect,
+		RollCallSkipByInput,
 		EnableMusicServer,
 		EnableDmDisplayNoSleep,
 		LineMaxLengthInput,
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 匹配方式的显示名称，与 Match* 一一对应
+var commandMatchNames = []string{"完全相同", "前缀(触发词 参数)", "正则表达式"}
+var commandMatchValues = []string{MatchExact, MatchPrefix, MatchRegex}
+
+// commandEditorRow 指令编辑器中的一条指令
+type commandEditorRow struct {
+	Name     string
+	Enabled  *widget.Check
+	Triggers *widget.Entry
+	Match    *widget.Select
+	Cooldown *widget.Entry
+}
+
+func newCommandEditorRow(c CommandConfig) *commandEditorRow {
+	r := &commandEditorRow{
+		Name:     c.Name,
+		Enabled:  widget.NewCheck("启用", nil),
+		Triggers: widget.NewMultiLineEntry(),
+		Match:    widget.NewSelect(commandMatchNames, nil),
+		Cooldown: widget.NewEntry(),
+	}
+	r.Enabled.SetChecked(c.Enabled)
+	r.Triggers.SetPlaceHolder("触发词，每行一个，第一个之后的为别名")
+	r.Triggers.SetText(strings.Join(c.Triggers, "\n"))
+	r.Match.SetSelectedIndex(1)
+	for i, v := range commandMatchValues {
+		if v == c.Match {
+			r.Match.SetSelectedIndex(i)
+		}
+	}
+	r.Cooldown.SetPlaceHolder("同一用户冷却时间(秒)，留空不限")
+	if c.Cooldown > 0 {
+		r.Cooldown.SetText(strconv.Itoa(c.Cooldown))
+	}
+	return r
+}
+
+// read 读取编辑后的指令配置
+func (r *commandEditorRow) read() (CommandConfig, error) {
+	c := CommandConfig{
+		Name:    r.Name,
+		Enabled: r.Enabled.Checked,
+		Match:   commandMatchValues[r.Match.SelectedIndex()],
+	}
+	if r.Name != CommandJoin {
+		c.Triggers = ParseTriggers(r.Triggers.Text)
+	}
+	if text := strings.TrimSpace(r.Cooldown.Text); text != "" {
+		var err error
+		if c.Cooldown, err = strconv.Atoi(text); err != nil {
+			return c, DisplayError{Message: CommandTitle(r.Name) + "：冷却时间应该是不小于0的整数"}
+		}
+	}
+	if err := ValidateCommand(c); err != nil {
+		return c, DisplayError{Message: err.Error()}
+	}
+	return c, nil
+}
+
+// ShowCommandEditor 打开弹幕指令编辑窗口，保存时回调编辑后的指令，恢复默认时回调 nil
+func ShowCommandEditor(cmds []CommandConfig, onSave func([]CommandConfig)) {
+	w := App.NewWindow("编辑弹幕指令")
+	w.Resize(fyne.NewSize(600, 600))
+
+	var rows []*commandEditorRow
+	list := container.NewVBox()
+	for _, c := range cmds {
+		r := newCommandEditorRow(c)
+		rows = append(rows, r)
+
+		triggers := fyne.CanvasObject(r.Triggers)
+		if c.Name == CommandJoin {
+			triggers = widget.NewLabel("触发词为各队列的排队关键词，前缀匹配时关键词后的内容作为备注")
+		}
+		list.Add(widget.NewCard(CommandTitle(c.Name), "", container.NewVBox(
+			container.NewGridWithColumns(3, r.Enabled, r.Match, r.Cooldown),
+			triggers,
+		)))
+	}
+
+	resetBtn := widget.NewButton("恢复默认", func() {
+		dialog.ShowConfirm("恢复默认", "将使用默认的弹幕指令", func(ok bool) {
+			if !ok {
+				return
+			}
+			onSave(nil)
+			w.Close()
+		}, w)
+	})
+	saveBtn := widget.NewButton("保存", func() {
+		res := make([]CommandConfig, 0, len(rows))
+		for _, r := range rows {
+			c, err := r.read()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			res = append(res, c)
+		}
+		onSave(res)
+		w.Close()
+	})
+	saveBtn.Importance = widget.HighImportance
+
+	w.SetContent(container.NewBorder(
+		widget.NewLabel("按从上到下的顺序匹配，排队指令最后匹配"),
+		container.NewHBox(resetBtn, saveBtn),
+		nil, nil,
+		container.NewVScroll(list),
+	))
+	w.Show()
+}
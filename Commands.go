@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 弹幕指令标识
+const (
+	// CommandJoin 排队，触发词为各队列的排队关键词
+	CommandJoin  = "join"
+	CommandLeave = "leave"
+	CommandWhere = "where"
+	CommandNote  = "note"
+	CommandSong  = "song"
+	// CommandAway 暂离，标记自己不在
+	CommandAway = "away"
+	// CommandBack 回来，标记自己在场
+	CommandBack = "back"
+	// CommandConfirm 叫号确认，队首用户在确认时间内发送
+	CommandConfirm = "confirm"
+)
+
+// 指令匹配方式
+const (
+	// MatchExact 弹幕与触发词完全相同
+	MatchExact = "exact"
+	// MatchPrefix 弹幕为触发词，或触发词加空白后跟参数
+	MatchPrefix = "prefix"
+	// MatchRegex 触发词为正则表达式，需匹配整条弹幕，第一个分组作为参数
+	MatchRegex = "regex"
+)
+
+// MaxNoteLength 指令参数最多保留的字数
+const MaxNoteLength = 40
+
+// CommandConfig 弹幕指令配置
+type CommandConfig struct {
+	// 指令标识，见 Command* 常量
+	Name string
+	// 触发词，第一个为主触发词，其余为别名，排队指令使用队列的排队关键词
+	Triggers []string
+	// 匹配方式，见 Match* 常量
+	Match string
+	// 同一用户两次触发的最小间隔(秒)，0为不限
+	Cooldown int
+	Enabled  bool
+}
+
+// 指令的显示名称
+var commandTitles = map[string]string{
+	CommandJoin:    "排队",
+	CommandLeave:   "取消排队",
+	CommandWhere:   "我在哪",
+	CommandNote:    "修改备注",
+	CommandSong:    "点歌",
+	CommandAway:    "暂离",
+	CommandBack:    "回来",
+	CommandConfirm: "叫号确认",
+
+	CommandNext:   "下一位(房管)",
+	CommandPause:  "暂停排队(房管)",
+	CommandResume: "恢复排队(房管)",
+	CommandClear:  "清空(房管)",
+	CommandRemove: "移除用户(房管)",
+	CommandTop:    "置顶用户(房管)",
+}
+
+// CommandTitle 指令的显示名称
+func CommandTitle(Name string) string {
+	if title, ok := commandTitles[Name]; ok {
+		return title
+	}
+	return Name
+}
+
+// DefaultCommands 默认指令，前几条与旧版固定的弹幕指令一致，之后为只有主播和房管可用的指令
+func DefaultCommands() []CommandConfig {
+	return []CommandConfig{
+		{Name: CommandJoin, Match: MatchPrefix, Enabled: true},
+		{Name: CommandLeave, Triggers: []string{"取消排队"}, Match: MatchExact, Enabled: true},
+		{Name: CommandWhere, Triggers: []string{"我在哪"}, Match: MatchExact, Enabled: true},
+		{Name: CommandNote, Triggers: []string{"备注"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandSong, Triggers: []string{"点歌"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandAway, Triggers: []string{"暂离"}, Match: MatchExact, Enabled: true},
+		{Name: CommandBack, Triggers: []string{"回来"}, Match: MatchExact, Enabled: true},
+		{Name: CommandConfirm, Triggers: []string{"到"}, Match: MatchExact, Enabled: true},
+		{Name: CommandNext, Triggers: []string{"下一位"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandPause, Triggers: []string{"暂停排队"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandResume, Triggers: []string{"恢复排队"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandClear, Triggers: []string{"清空"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandRemove, Triggers: []string{"移除"}, Match: MatchPrefix, Enabled: true},
+		{Name: CommandTop, Triggers: []string{"置顶"}, Match: MatchPrefix, Enabled: true},
+	}
+}
+
+// ActiveCommands 当前生效的指令，配置中没有的指令使用默认配置，顺序与默认指令一致
+func ActiveCommands(Config RunConfig) []CommandConfig {
+	configured := make(map[string]CommandConfig, len(Config.Commands))
+	for _, c := range Config.Commands {
+		configured[c.Name] = c
+	}
+	res := DefaultCommands()
+	for i, c := range res {
+		if override, ok := configured[c.Name]; ok {
+			res[i] = override
+		}
+	}
+	return res
+}
+
+// ValidateCommand 检查指令配置
+func ValidateCommand(c CommandConfig) error {
+	switch c.Match {
+	case MatchExact, MatchPrefix, MatchRegex:
+	default:
+		return fmt.Errorf("%s：未知的匹配方式 %s", CommandTitle(c.Name), c.Match)
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("%s：冷却时间不能小于0", CommandTitle(c.Name))
+	}
+	if c.Name != CommandJoin && len(c.Triggers) == 0 {
+		return fmt.Errorf("%s：至少需要一个触发词", CommandTitle(c.Name))
+	}
+	for _, trigger := range c.Triggers {
+		if c.Match == MatchRegex {
+			if _, err := compileTrigger(trigger); err != nil {
+				return fmt.Errorf("%s：正则表达式无效 %s", CommandTitle(c.Name), trigger)
+			}
+		}
+	}
+	return nil
+}
+
+// 已编译的正则触发词
+var triggerRegexps sync.Map
+
+func compileTrigger(Pattern string) (*regexp.Regexp, error) {
+	if re, ok := triggerRegexps.Load(Pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(`^(?:` + Pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	triggerRegexps.Store(Pattern, re)
+	return re, nil
+}
+
+// MatchTrigger 按匹配方式判断弹幕是否触发指令，返回指令参数
+func MatchTrigger(Msg, Trigger, Mode string) (string, bool) {
+	switch Mode {
+	case MatchExact:
+		return "", Trigger != "" && Msg == Trigger
+	case MatchRegex:
+		re, err := compileTrigger(Trigger)
+		if err != nil {
+			return "", false
+		}
+		m := re.FindStringSubmatch(Msg)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return truncateArg(strings.TrimSpace(m[1])), true
+		}
+		return "", true
+	}
+	return CutCommand(Msg, Trigger)
+}
+
+// CutCommand 弹幕等于指令或以指令加空白开头时返回指令后的内容，内容超过 MaxNoteLength 个字时截断
+func CutCommand(Msg, Command string) (string, bool) {
+	if Command == "" || !strings.HasPrefix(Msg, Command) {
+		return "", false
+	}
+	rest := Msg[len(Command):]
+	if rest == "" {
+		return "", true
+	}
+	if r, _ := utf8.DecodeRuneInString(rest); !unicode.IsSpace(r) {
+		return "", false
+	}
+	return truncateArg(strings.TrimSpace(rest)), true
+}
+
+func truncateArg(Arg string) string {
+	if runes := []rune(Arg); len(runes) > MaxNoteLength {
+		return string(runes[:MaxNoteLength])
+	}
+	return Arg
+}
+
+// CommandRegistry 按当前配置匹配弹幕指令，并记录每个用户的触发时间用于冷却
+type CommandRegistry struct {
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+var commands = NewCommandRegistry()
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{lastUsed: make(map[string]time.Time)}
+}
+
+// Get 当前生效的指令配置
+func (r *CommandRegistry) Get(Name string) CommandConfig {
+	for _, c := range ActiveCommands(Configuration()) {
+		if c.Name == Name {
+			return c
+		}
+	}
+	return CommandConfig{Name: Name}
+}
+
+// Match 按配置顺序匹配已启用的指令，排队指令由队列的排队关键词匹配，不在这里处理
+func (r *CommandRegistry) Match(Msg string) (CommandConfig, string, bool) {
+	for _, c := range ActiveCommands(Configuration()) {
+		if !c.Enabled || c.Name == CommandJoin {
+			continue
+		}
+		for _, trigger := range c.Triggers {
+			if Arg, ok := MatchTrigger(Msg, trigger, c.Match); ok {
+				return c, Arg, true
+			}
+		}
+	}
+	return CommandConfig{}, "", false
+}
+
+// Allow 用户是否已过指令冷却，允许时记录本次触发时间
+func (r *CommandRegistry) Allow(c CommandConfig, OpenID string) bool {
+	if c.Cooldown <= 0 {
+		return true
+	}
+	key := c.Name + "|" + OpenID
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if last, ok := r.lastUsed[key]; ok && now.Sub(last) < time.Duration(c.Cooldown)*time.Second {
+		return false
+	}
+	r.lastUsed[key] = now
+	return true
+}
+
+// ParseTriggers 解析每行一个的触发词
+func ParseTriggers(s string) []string {
+	var res []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			res = append(res, line)
+		}
+	}
+	return res
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchTrigger(t *testing.T) {
+	long := "一二三四五六七八九十一二三四五六七八九十一二三四五六七八九十一二三四五六七八九十超出"
+	tests := []struct {
+		msg, trigger, mode string
+		wantArg            string
+		wantOK             bool
+	}{
+		{"取消排队", "取消排队", MatchExact, "", true},
+		{"取消排队 ", "取消排队", MatchExact, "", false},
+		{"", "", MatchExact, "", false},
+		{"备注", "备注", MatchPrefix, "", true},
+		{"备注 晚点到", "备注", MatchPrefix, "晚点到", true},
+		{"备注\t 晚点到 ", "备注", MatchPrefix, "晚点到", true},
+		{"备注晚点到", "备注", MatchPrefix, "", false},
+		{"我要备注", "备注", MatchPrefix, "", false},
+		{"备注 " + long, "备注", MatchPrefix, long[:len(long)-len("超出")], true},
+		{"点歌 晴天", `点歌\s*(.*)`, MatchRegex, "晴天", true},
+		{"点歌晴天", `点歌\s*(.*)`, MatchRegex, "晴天", true},
+		{"来点歌晴天", `点歌\s*(.*)`, MatchRegex, "", false},
+		{"到了", `到了?`, MatchRegex, "", true},
+		{"到", `(`, MatchRegex, "", false},
+	}
+	for _, tt := range tests {
+		Arg, ok := MatchTrigger(tt.msg, tt.trigger, tt.mode)
+		if Arg != tt.wantArg || ok != tt.wantOK {
+			t.Errorf("MatchTrigger(%q, %q, %s) = %q, %t，应为 %q, %t", tt.msg, tt.trigger, tt.mode, Arg, ok, tt.wantArg, tt.wantOK)
+		}
+	}
+}
+
+func TestCommandRegistryMatch(t *testing.T) {
+	old := Configuration()
+	t.Cleanup(func() { setConfiguration(old) })
+	setConfiguration(RunConfig{Commands: []CommandConfig{
+		// 覆盖默认配置：取消排队增加别名，我在哪停用，暂离改为正则
+		{Name: CommandLeave, Triggers: []string{"取消排队", "不排了"}, Match: MatchExact, Enabled: true},
+		{Name: CommandWhere, Triggers: []string{"我在哪"}, Match: MatchExact},
+		{Name: CommandAway, Triggers: []string{`暂离(\d+)分钟`}, Match: MatchRegex, Enabled: true},
+	}})
+
+	r := NewCommandRegistry()
+	tests := []struct {
+		msg      string
+		wantName string
+		wantArg  string
+	}{
+		{"不排了", CommandLeave, ""},
+		{"取消排队", CommandLeave, ""},
+		{"我在哪", "", ""},
+		{"暂离5分钟", CommandAway, "5"},
+		{"暂离", "", ""},
+		{"回来", CommandBack, ""},
+		{"下一位 2", CommandNext, "2"},
+		{"排队", "", ""},
+	}
+	for _, tt := range tests {
+		c, Arg, ok := r.Match(tt.msg)
+		if ok != (tt.wantName != "") || c.Name != tt.wantName || Arg != tt.wantArg {
+			t.Errorf("%q 匹配到 %q(%q)，应为 %q(%q)", tt.msg, c.Name, Arg, tt.wantName, tt.wantArg)
+		}
+	}
+}
+
+func TestCommandRegistryAllow(t *testing.T) {
+	r := NewCommandRegistry()
+	leave := CommandConfig{Name: CommandLeave, Cooldown: 30}
+	where := CommandConfig{Name: CommandWhere, Cooldown: 30}
+
+	if !r.Allow(leave, "a") {
+		t.Fatal("首次触发应允许")
+	}
+	if r.Allow(leave, "a") {
+		t.Error("冷却中不应允许")
+	}
+	if !r.Allow(leave, "b") {
+		t.Error("冷却按用户计算")
+	}
+	if !r.Allow(where, "a") {
+		t.Error("冷却按指令计算")
+	}
+	for i := 0; i < 3; i++ {
+		if !r.Allow(CommandConfig{Name: CommandNote}, "a") {
+			t.Error("未设置冷却时总是允许")
+		}
+	}
+
+	// 冷却结束后再次允许，并重新开始计时
+	r.lastUsed[CommandLeave+"|a"] = time.Now().Add(-30 * time.Second)
+	if !r.Allow(leave, "a") {
+		t.Error("冷却结束后应允许")
+	}
+	if r.Allow(leave, "a") {
+		t.Error("再次触发后应重新冷却")
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       CommandConfig
+		wantErr bool
+	}{
+		{"默认排队指令没有触发词", CommandConfig{Name: CommandJoin, Match: MatchPrefix}, false},
+		{"没有触发词", CommandConfig{Name: CommandLeave, Match: MatchExact}, true},
+		{"未知匹配方式", CommandConfig{Name: CommandLeave, Triggers: []string{"x"}, Match: "fuzzy"}, true},
+		{"冷却时间小于0", CommandConfig{Name: CommandLeave, Triggers: []string{"x"}, Match: MatchExact, Cooldown: -1}, true},
+		{"正则无效", CommandConfig{Name: CommandSong, Triggers: []string{"点歌(", "点歌"}, Match: MatchRegex}, true},
+		{"正则有效", CommandConfig{Name: CommandSong, Triggers: []string{`点歌\s*(.+)`}, Match: MatchRegex}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateCommand(tt.c); (err != nil) != tt.wantErr {
+			t.Errorf("%s：错误 %v", tt.name, err)
+		}
+	}
+}
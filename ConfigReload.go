@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/exp/slog"
+)
+
+// ConfigReloadDelay 配置文件变化后等待的时间，编辑器保存时可能连续触发多次写入
+const ConfigReloadDelay = 500 * time.Millisecond
+
+var ErrRoomConnect = errors.New("room connect failed")
+
+// configMu 串行应用配置，配置界面保存和配置文件监听可能同时触发
+var configMu sync.Mutex
+
+// roomMu 串行连接和断开直播间
+var roomMu sync.Mutex
+
+// configEqual 两份配置是否相同，按写入文件的内容比较
+func configEqual(a, b RunConfig) bool {
+	a.SchemaVersion, b.SchemaVersion = ConfigSchemaVersion, ConfigSchemaVersion
+	aJson, aErr := json.Marshal(a)
+	bJson, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aJson) == string(bJson)
+}
+
+// ApplyConfig 不重启程序应用新配置：重建各队列的关键词、容量和层级，礼物、叫号等规则在处理下一个事件时生效，并通知页面重新加载样式
+// 只有身份码变化或尚未连接时重新连接弹幕服务器
+func ApplyConfig(Config RunConfig) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	old := Configuration()
+	if configEqual(old, Config) {
+		return nil
+	}
+	if err := queues.CheckRemoval(Config); err != nil {
+		return err
+	}
+	setConfiguration(Config)
+	queues.Apply(Config)
+	RefreshCtrlUI()
+	// 容量调大后等候中的用户可以加入队列
+	for _, q := range queues.All() {
+		PromoteWaitlist(q)
+	}
+	SendConfigToWs()
+	slog.Info("配置已应用")
+
+	if Config.IdCode == old.IdCode && AppClient != nil {
+		return nil
+	}
+	return ReconnectRoom(Config.IdCode)
+}
+
+// DisconnectRoom 停止心跳并关闭当前的弹幕连接
+func DisconnectRoom() {
+	roomMu.Lock()
+	defer roomMu.Unlock()
+
+	disconnectRoom()
+}
+
+// disconnectRoom 断开连接，调用方需持有 roomMu
+func disconnectRoom() {
+	if CloseHeartbeatChan != nil {
+		CloseHeartbeatChan <- true
+		CloseHeartbeatChan = nil
+	}
+	if WsClient != nil {
+		_ = WsClient.Close()
+		WsClient = nil
+	}
+	if AppClient != nil && GameId != "" {
+		if err := AppClient.AppEnd(GameId); err != nil {
+			slog.Error("应用流程关闭失败", err)
+		}
+	}
+	AppClient = nil
+	GameId = ""
+}
+
+// ReconnectRoom 使用身份码重新连接弹幕服务器，不影响队列和页面连接
+func ReconnectRoom(IdCode string) error {
+	roomMu.Lock()
+	defer roomMu.Unlock()
+
+	disconnectRoom()
+	if IdCode == "" {
+		return ErrRoomConnect
+	}
+	RoomId = 0
+	client, gameId, wsClient, closeChan, err := RoomConnect(IdCode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRoomConnect, err)
+	}
+	AppClient = client
+	GameId = gameId
+	WsClient = wsClient
+	CloseHeartbeatChan = closeChan
+	return nil
+}
+
+// WatchConfig 监听配置文件，手动修改后自动应用，文件无法读取时保留当前配置
+func WatchConfig() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("配置文件监听启动失败", err)
+		return
+	}
+	defer watcher.Close()
+	// 配置文件通过重命名原子替换，需要监听所在目录
+	if err = watcher.Add(ProfileDir); err != nil {
+		slog.Error("配置文件监听启动失败", err)
+		return
+	}
+
+	path := DataPath(ConfigFile)
+	var timer *time.Timer
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != path || !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+				continue
+			}
+			if timer == nil {
+				timer = time.AfterFunc(ConfigReloadDelay, reloadConfigFile)
+			} else {
+				timer.Reset(ConfigReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("配置文件监听错误", err)
+		}
+	}
+}
+
+// reloadConfigFile 重新读取并应用配置文件，身份码变化后刷新主页面的直播间信息
+func reloadConfigFile() {
+	Config, err := GetConfig()
+	if err != nil {
+		slog.Error("配置文件重新加载失败，保留当前配置", err)
+		return
+	}
+	oldIdCode := Configuration().IdCode
+	if err = ApplyConfig(Config); err != nil {
+		if errors.Is(err, ErrQueueNotEmpty) {
+			slog.Error("配置文件移除了仍有用户的队列，保留当前配置", err)
+		} else {
+			slog.Error("身份码变化后重新连接失败", err)
+		}
+		return
+	}
+	if Config.IdCode != oldIdCode && MainWindows != nil {
+		content := MakeMainUI(MainWindows, Config)
+		fyne.Do(func() {
+			MainWindows.SetContent(content)
+		})
+	}
+}
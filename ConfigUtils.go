@@ -2,74 +2,140 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"image/color"
 	"os"
+	"sync"
+
+	"golang.org/x/exp/slog"
 )
 
+// configStateMu 保护 globalConfiguration 和 SpecialUserList，弹幕、礼物处理和配置热加载在不同的 goroutine 中读写
+var configStateMu sync.RWMutex
+
+// Configuration 当前生效的配置，返回副本，不要修改其中的切片和映射
+func Configuration() RunConfig {
+	configStateMu.RLock()
+	defer configStateMu.RUnlock()
+
+	return globalConfiguration
+}
+
+// setConfiguration 替换当前配置，特殊用户名单复制一份单独维护
+func setConfiguration(Config RunConfig) {
+	configStateMu.Lock()
+	defer configStateMu.Unlock()
+
+	globalConfiguration = Config
+	SpecialUserList = copySpecialUsers(Config.SpecialUserList)
+}
+
+func copySpecialUsers(m map[string]SpecialUserStruct) map[string]SpecialUserStruct {
+	res := make(map[string]SpecialUserStruct, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// SpecialUser 查找特殊用户
+func SpecialUser(OpenID string) (SpecialUserStruct, bool) {
+	configStateMu.RLock()
+	defer configStateMu.RUnlock()
+
+	UserStruct, ok := SpecialUserList[OpenID]
+	return UserStruct, ok
+}
+
+// UpdateSpecialUsers 在锁内修改特殊用户名单，修改后写入配置文件
+func UpdateSpecialUsers(fn func(map[string]SpecialUserStruct)) {
+	configStateMu.Lock()
+	if SpecialUserList == nil {
+		SpecialUserList = make(map[string]SpecialUserStruct)
+	}
+	fn(SpecialUserList)
+	// 配置中保存一份副本，Configuration 返回的配置不会随名单修改而变化
+	globalConfiguration.SpecialUserList = copySpecialUsers(SpecialUserList)
+	Config := globalConfiguration
+	configStateMu.Unlock()
+
+	SetConfig(Config)
+}
+
+// ConfigFile 配置文件
+const ConfigFile = "lineConfig.json"
+
+// GetConfig 读取配置，旧版本的配置迁移到当前结构后写回，写回前会保留迁移前的备份
+// 缺少的字段使用默认值，校验不通过时同时返回读取到的配置和 ConfigErrors
 func GetConfig() (rConfig RunConfig, err error) {
-	file, err := os.ReadFile("./lineConfig.json")
+	file, err := os.ReadFile(DataPath(ConfigFile))
 	if err != nil {
 		return RunConfig{}, err
 	}
-	var Config RunConfig
-	err = json.Unmarshal(file, &Config)
+	file, version, err := migrate(file, configMigrations)
 	if err != nil {
 		return RunConfig{}, err
 	}
-	return Config, err
+	var Config RunConfig
+	if err = json.Unmarshal(file, &Config); err != nil {
+		return RunConfig{}, err
+	}
+	Config = ConfigWithDefaults(Config)
+	if version < ConfigSchemaVersion {
+		slog.Info("配置文件已迁移", slog.Int("from", version), slog.Int("to", ConfigSchemaVersion))
+		if err = rotateBackups(DataPath(ConfigFile), true); err != nil {
+			slog.Error("迁移前备份配置文件失败", err)
+		}
+		SetConfig(Config)
+	}
+	return Config, ValidateConfig(Config)
 }
 
+// SetConfig 写入配置，写入前按间隔自动备份
 func SetConfig(sConfig RunConfig) bool {
-	ConfigJson, _ := json.MarshalIndent(sConfig, "", " ")
-	lineupConfig := "./lineConfig.json"
-	_, ReadConfigErr := os.Open(lineupConfig)
-	if ReadConfigErr != nil {
-		fmt.Println("配置文件不存在，尝试创建")
-		_, ConfigErr := os.Create(lineupConfig)
-		_, LineCreate := os.Create("./line.json")
-		if ConfigErr != nil || LineCreate != nil {
-			fmt.Println("配置文件创建失败", ConfigErr.Error(), LineCreate.Error())
-			return false
-		} else {
-			err := os.WriteFile(lineupConfig, ConfigJson, 0o666)
-			if err != nil {
-				fmt.Println("配置文件更新失败", err.Error())
-				return false
-			} else {
-				return true
-			}
-		}
-	} else {
-		err := os.WriteFile(lineupConfig, ConfigJson, 0o666)
-		if err != nil {
-			fmt.Println("配置文件更新失败", err.Error())
-			return false
-		} else {
-			return true
-		}
+	sConfig.SchemaVersion = ConfigSchemaVersion
+	ConfigJson, err := json.MarshalIndent(sConfig, "", " ")
+	if err != nil {
+		slog.Error("配置序列化失败", err)
+		return false
 	}
+	if err = writeWithBackup(DataPath(ConfigFile), ConfigJson); err != nil {
+		slog.Error("配置文件更新失败", err)
+		return false
+	}
+	return true
 }
 
-func SetLine(lp LineRow) {
-	lineJson, _ := json.MarshalIndent(lp, "", " ")
-	lineConfigFile := "./line.json"
-	WriteErr := os.WriteFile(lineConfigFile, lineJson, 0666)
-	if WriteErr != nil {
-		fmt.Println("队列文件更新失败")
+// LineFile 默认队列的队列文件
+const LineFile = "line.json"
+
+// SetLine 写入队列文件，写入前按间隔自动备份
+func SetLine(lineConfigFile string, lp LineRow) {
+	lp.SchemaVersion = LineSchemaVersion
+	lineJson, err := json.MarshalIndent(lp, "", " ")
+	if err != nil {
+		slog.Error("队列序列化失败", err)
+		return
+	}
+	if err = writeWithBackup(lineConfigFile, lineJson); err != nil {
+		slog.Error("队列文件更新失败", err, slog.String("path", lineConfigFile))
 	}
 }
 
-func GetLine() (line LineRow, err error) {
-	lineConfigFile := "./line.json"
-	var LineGet LineRow
+// GetLine 读取队列文件，旧版本的队列文件按迁移表转换，写回由下次保存完成
+func GetLine(lineConfigFile string) (line LineRow, err error) {
 	file, err := os.ReadFile(lineConfigFile)
 	if err != nil {
 		return LineRow{}, err
-	} else {
-		_ = json.Unmarshal(file, &LineGet)
-		return LineGet, nil
 	}
+	file, _, err = migrate(file, lineMigrations)
+	if err != nil {
+		return LineRow{}, err
+	}
+	var LineGet LineRow
+	if err = json.Unmarshal(file, &LineGet); err != nil {
+		return LineRow{}, err
+	}
+	return LineGet, nil
 }
 
 func ToLineColor(c color.Color) LineColor {
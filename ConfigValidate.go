@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxLineCount 配置中没有队列最大容量时使用的容量
+const DefaultMaxLineCount = 100
+
+// maxColorValue 颜色分量的最大值，颜色按 color.Color.RGBA 的16位分量保存
+const maxColorValue = 0xffff
+
+// FieldError 一项配置错误，Field 为配置中的字段路径，如 Queues[0].Name
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + "：" + e.Message
+}
+
+// ConfigErrors 配置中的全部错误
+type ConfigErrors []FieldError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, fe := range e {
+		lines = append(lines, fe.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Add 记录一项错误
+func (e *ConfigErrors) Add(Field, Format string, Args ...interface{}) {
+	*e = append(*e, FieldError{Field: Field, Message: fmt.Sprintf(Format, Args...)})
+}
+
+// AddErr 记录解析输入时的错误，err 为 nil 时忽略
+func (e *ConfigErrors) AddErr(Field string, err error) {
+	if err != nil {
+		e.Add(Field, "%s", err.Error())
+	}
+}
+
+// Merge 合并 ValidateConfig 返回的错误，已有错误的字段不重复记录
+func (e *ConfigErrors) Merge(err error) {
+	var other ConfigErrors
+	if !errors.As(err, &other) {
+		e.AddErr("Config", err)
+		return
+	}
+	seen := make(map[string]bool, len(*e))
+	for _, fe := range *e {
+		seen[fe.Field] = true
+	}
+	for _, fe := range other {
+		if !seen[fe.Field] {
+			*e = append(*e, fe)
+		}
+	}
+}
+
+// Err 没有错误时返回 nil
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ConfigWithDefaults 为缺少的字段填入默认值，用于读取旧版或手动编辑的配置文件
+func ConfigWithDefaults(Config RunConfig) RunConfig {
+	if len(ParseKeyWords(Config.LineKey)) == 0 {
+		Config.LineKey = "排队"
+	}
+	if Config.MaxLineCount == 0 {
+		Config.MaxLineCount = DefaultMaxLineCount
+	}
+	if Config.RollCall == (RollCallConfig{}) {
+		Config.RollCall = DefaultRollCallConfig()
+	}
+	if Config.RollCall.Timeout == 0 {
+		Config.RollCall.Timeout = DefaultRollCallConfig().Timeout
+	}
+	if Config.RollCall.Policy == "" {
+		Config.RollCall.Policy = RollCallSkip
+	}
+	for i := range Config.Commands {
+		if Config.Commands[i].Match == "" {
+			Config.Commands[i].Match = MatchPrefix
+		}
+	}
+	return Config
+}
+
+// ValidateConfig 检查配置的每个字段，返回包含全部错误的 ConfigErrors，没有错误时返回 nil
+func ValidateConfig(Config RunConfig) error {
+	var errs ConfigErrors
+
+	if strings.TrimSpace(Config.IdCode) == "" {
+		errs.Add("IdCode", "身份码不能为空")
+	}
+	validateColor(&errs, "GuardPrintColor", Config.GuardPrintColor)
+	validateColor(&errs, "GiftPrintColor", Config.GiftPrintColor)
+	validateColor(&errs, "CommonPrintColor", Config.CommonPrintColor)
+	validateColor(&errs, "DmDisplayColor", Config.DmDisplayColor)
+	if len(ParseKeyWords(Config.LineKey)) == 0 {
+		errs.Add("LineKey", "排队关键词不能为空")
+	}
+	if Config.MaxLineCount <= 0 {
+		errs.Add("MaxLineCount", "队列最大容量应该大于0")
+	}
+	if Config.ScrollInterval < 0 {
+		errs.Add("ScrollInterval", "滚动间隔不能小于0")
+	}
+	if Config.GiftLinePrice < 0 {
+		errs.Add("GiftLinePrice", "礼物价格不能小于0")
+	}
+	if Config.AutoJoinGiftLine && Config.GiftLinePrice <= 0 && Config.GiftRules.CumulativePrice <= 0 {
+		errs.Add("GiftLinePrice", "礼物价格或累计礼物价格应该大于0")
+	}
+	if Config.SuperChatLinePrice < 0 {
+		errs.Add("SuperChatLinePrice", "醒目留言价值不能小于0")
+	}
+	validateGiftRules(&errs, Config.GiftRules)
+	for i, tier := range Config.LineTiers {
+		validateTier(&errs, fmt.Sprintf("LineTiers[%d]", i), tier)
+	}
+	validateJoinRule(&errs, "JoinRule", Config.JoinRule)
+	names := map[string]bool{DefaultQueueName: true}
+	for i, qc := range Config.Queues {
+		field := fmt.Sprintf("Queues[%d]", i)
+		switch {
+		case !queueNameRegexp.MatchString(qc.Name):
+			errs.Add(field+".Name", "队列标识只能包含字母、数字、下划线和减号：%s", qc.Name)
+		case names[qc.Name]:
+			errs.Add(field+".Name", "队列标识重复：%s", qc.Name)
+		}
+		names[qc.Name] = true
+		if len(ParseKeyWords(qc.LineKey)) == 0 {
+			errs.Add(field+".LineKey", "队列 %s 没有排队关键词", qc.Name)
+		}
+		if qc.MaxLineCount < 0 {
+			errs.Add(field+".MaxLineCount", "队列容量不能小于0")
+		}
+		validateJoinRule(&errs, field+".JoinRule", qc.JoinRule)
+	}
+	if Config.ServeRules.RejoinCooldown < 0 {
+		errs.Add("ServeRules.RejoinCooldown", "重新排队冷却时间不能小于0")
+	}
+	if Config.ServeRules.MaxServePerSession < 0 {
+		errs.Add("ServeRules.MaxServePerSession", "每场叫号次数不能小于0")
+	}
+	for i, c := range Config.Commands {
+		validateCommand(&errs, fmt.Sprintf("Commands[%d]", i), c)
+	}
+	for i, m := range Config.Moderators {
+		if strings.TrimSpace(m.OpenID) == "" {
+			errs.Add(fmt.Sprintf("Moderators[%d].OpenID", i), "房管的 OpenID 不能为空")
+		}
+	}
+	if Config.AwayTimeout < 0 {
+		errs.Add("AwayTimeout", "暂离超时不能小于0")
+	}
+	if Config.IdleTimeout < 0 {
+		errs.Add("IdleTimeout", "无互动超时不能小于0")
+	}
+	if Config.RollCall.Timeout <= 0 {
+		errs.Add("RollCall.Timeout", "叫号确认时间应该大于0")
+	}
+	if Config.RollCall.Policy != RollCallSkip && Config.RollCall.Policy != RollCallRemove {
+		errs.Add("RollCall.Policy", "未知的超时处理方式：%s", Config.RollCall.Policy)
+	}
+	if Config.RollCall.SkipBy < 0 {
+		errs.Add("RollCall.SkipBy", "超时后移位数不能小于0")
+	}
+	return errs.Err()
+}
+
+// validateColor 颜色分量不能超过16位
+func validateColor(errs *ConfigErrors, Field string, c LineColor) {
+	if c.R > maxColorValue || c.G > maxColorValue || c.B > maxColorValue {
+		errs.Add(Field, "颜色分量应在0到%d之间", maxColorValue)
+	}
+}
+
+func validateGiftRules(errs *ConfigErrors, r GiftRuleConfig) {
+	if r.CumulativePrice < 0 {
+		errs.Add("GiftRules.CumulativePrice", "累计礼物价格不能小于0")
+	}
+	for _, id := range r.AllowGiftIDs {
+		if id <= 0 {
+			errs.Add("GiftRules.AllowGiftIDs", "礼物ID应该大于0：%d", id)
+		}
+	}
+	for _, id := range r.DenyGiftIDs {
+		if id <= 0 {
+			errs.Add("GiftRules.DenyGiftIDs", "礼物ID应该大于0：%d", id)
+		}
+	}
+	for id, value := range r.ValueOverrides {
+		if id <= 0 || value < 0 {
+			errs.Add("GiftRules.ValueOverrides", "礼物ID应该大于0且价值不能小于0：%d", id)
+		}
+	}
+}
+
+func validateTier(errs *ConfigErrors, Field string, t LineTier) {
+	if strings.TrimSpace(t.Name) == "" {
+		errs.Add(Field+".Name", "层级名称不能为空")
+	}
+	validateColor(errs, Field+".Color", t.Color)
+	if t.MaxCount < 0 {
+		errs.Add(Field+".MaxCount", "层级容量不能小于0")
+	}
+	switch t.Sort {
+	case "", TierSortJoin, TierSortGift, TierSortGuard:
+	default:
+		errs.Add(Field+".Sort", "未知的排序方式：%s", t.Sort)
+	}
+	if t.Rule.GuardLevel < 0 || t.Rule.GuardLevel > 3 {
+		errs.Add(Field+".Rule.GuardLevel", "大航海等级应在0到3之间")
+	}
+	if t.Rule.MinGiftPrice < 0 {
+		errs.Add(Field+".Rule.MinGiftPrice", "礼物价值不能小于0")
+	}
+	if t.Rule.MinMedalLevel < 0 {
+		errs.Add(Field+".Rule.MinMedalLevel", "粉丝牌等级不能小于0")
+	}
+}
+
+func validateJoinRule(errs *ConfigErrors, Field string, r JoinRule) {
+	if r.MinMedalLevel < 0 {
+		errs.Add(Field+".MinMedalLevel", "最低粉丝牌等级不能小于0")
+	}
+	if r.MinGuardLevel < 0 || r.MinGuardLevel > 3 {
+		errs.Add(Field+".MinGuardLevel", "大航海等级应在0到3之间")
+	}
+}
+
+func validateCommand(errs *ConfigErrors, Field string, c CommandConfig) {
+	if _, ok := commandTitles[c.Name]; !ok {
+		errs.Add(Field+".Name", "未知的指令：%s", c.Name)
+		return
+	}
+	errs.AddErr(Field, ValidateCommand(c))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// validTestConfig 通过校验的配置，各用例在此基础上修改一个字段
+func validTestConfig() RunConfig {
+	Config := ConfigWithDefaults(RunConfig{IdCode: "ABCDEF"})
+	Config.Queues = []QueueConfig{{Name: "song", LineKey: "点歌"}}
+	Config.LineTiers = []LineTier{{Name: "全部", Sort: TierSortJoin}}
+	Config.Commands = []CommandConfig{{Name: CommandLeave, Triggers: []string{"取消排队"}, Match: MatchExact}}
+	Config.Moderators = []ModeratorConfig{{OpenID: "moderator"}}
+	return Config
+}
+
+func TestValidateConfig(t *testing.T) {
+	badColor := LineColor{R: maxColorValue + 1}
+	tests := []struct {
+		name   string
+		modify func(*RunConfig)
+		field  string
+	}{
+		{"身份码为空", func(c *RunConfig) { c.IdCode = " " }, "IdCode"},
+		{"舰长颜色", func(c *RunConfig) { c.GuardPrintColor = badColor }, "GuardPrintColor"},
+		{"礼物颜色", func(c *RunConfig) { c.GiftPrintColor = badColor }, "GiftPrintColor"},
+		{"普通颜色", func(c *RunConfig) { c.CommonPrintColor = badColor }, "CommonPrintColor"},
+		{"弹幕颜色", func(c *RunConfig) { c.DmDisplayColor = badColor }, "DmDisplayColor"},
+		{"排队关键词为空", func(c *RunConfig) { c.LineKey = "，," }, "LineKey"},
+		{"队列容量为0", func(c *RunConfig) { c.MaxLineCount = 0 }, "MaxLineCount"},
+		{"滚动间隔小于0", func(c *RunConfig) { c.ScrollInterval = -1 }, "ScrollInterval"},
+		{"礼物价格小于0", func(c *RunConfig) { c.GiftLinePrice = -1 }, "GiftLinePrice"},
+		{"礼物排队没有价格", func(c *RunConfig) { c.AutoJoinGiftLine = true }, "GiftLinePrice"},
+		{"醒目留言价值小于0", func(c *RunConfig) { c.SuperChatLinePrice = -1 }, "SuperChatLinePrice"},
+		{"累计礼物价格小于0", func(c *RunConfig) { c.GiftRules.CumulativePrice = -1 }, "GiftRules.CumulativePrice"},
+		{"统计礼物ID无效", func(c *RunConfig) { c.GiftRules.AllowGiftIDs = []int{0} }, "GiftRules.AllowGiftIDs"},
+		{"排除礼物ID无效", func(c *RunConfig) { c.GiftRules.DenyGiftIDs = []int{-1} }, "GiftRules.DenyGiftIDs"},
+		{"礼物价值覆盖无效", func(c *RunConfig) { c.GiftRules.ValueOverrides = map[int]float64{1: -1} }, "GiftRules.ValueOverrides"},
+		{"层级名称为空", func(c *RunConfig) { c.LineTiers[0].Name = "" }, "LineTiers[0].Name"},
+		{"层级颜色", func(c *RunConfig) { c.LineTiers[0].Color = badColor }, "LineTiers[0].Color"},
+		{"层级容量小于0", func(c *RunConfig) { c.LineTiers[0].MaxCount = -1 }, "LineTiers[0].MaxCount"},
+		{"层级排序未知", func(c *RunConfig) { c.LineTiers[0].Sort = "random" }, "LineTiers[0].Sort"},
+		{"层级大航海等级", func(c *RunConfig) { c.LineTiers[0].Rule.GuardLevel = 4 }, "LineTiers[0].Rule.GuardLevel"},
+		{"层级礼物价值小于0", func(c *RunConfig) { c.LineTiers[0].Rule.MinGiftPrice = -1 }, "LineTiers[0].Rule.MinGiftPrice"},
+		{"层级粉丝牌等级小于0", func(c *RunConfig) { c.LineTiers[0].Rule.MinMedalLevel = -1 }, "LineTiers[0].Rule.MinMedalLevel"},
+		{"排队粉丝牌等级小于0", func(c *RunConfig) { c.JoinRule.MinMedalLevel = -1 }, "JoinRule.MinMedalLevel"},
+		{"排队大航海等级", func(c *RunConfig) { c.JoinRule.MinGuardLevel = 4 }, "JoinRule.MinGuardLevel"},
+		{"队列标识无效", func(c *RunConfig) { c.Queues[0].Name = "点歌" }, "Queues[0].Name"},
+		{"队列标识与默认队列重复", func(c *RunConfig) { c.Queues[0].Name = DefaultQueueName }, "Queues[0].Name"},
+		{"队列标识重复", func(c *RunConfig) { c.Queues = append(c.Queues, c.Queues[0]) }, "Queues[1].Name"},
+		{"队列没有关键词", func(c *RunConfig) { c.Queues[0].LineKey = "" }, "Queues[0].LineKey"},
+		{"队列容量小于0", func(c *RunConfig) { c.Queues[0].MaxLineCount = -1 }, "Queues[0].MaxLineCount"},
+		{"队列粉丝牌等级小于0", func(c *RunConfig) { c.Queues[0].JoinRule.MinMedalLevel = -1 }, "Queues[0].JoinRule.MinMedalLevel"},
+		{"队列大航海等级", func(c *RunConfig) { c.Queues[0].JoinRule.MinGuardLevel = -1 }, "Queues[0].JoinRule.MinGuardLevel"},
+		{"冷却时间小于0", func(c *RunConfig) { c.ServeRules.RejoinCooldown = -1 }, "ServeRules.RejoinCooldown"},
+		{"叫号次数小于0", func(c *RunConfig) { c.ServeRules.MaxServePerSession = -1 }, "ServeRules.MaxServePerSession"},
+		{"未知指令", func(c *RunConfig) { c.Commands[0].Name = "dance" }, "Commands[0].Name"},
+		{"指令匹配方式未知", func(c *RunConfig) { c.Commands[0].Match = "fuzzy" }, "Commands[0]"},
+		{"指令没有触发词", func(c *RunConfig) { c.Commands[0].Triggers = nil }, "Commands[0]"},
+		{"房管 OpenID 为空", func(c *RunConfig) { c.Moderators[0].OpenID = "" }, "Moderators[0].OpenID"},
+		{"暂离超时小于0", func(c *RunConfig) { c.AwayTimeout = -1 }, "AwayTimeout"},
+		{"无互动超时小于0", func(c *RunConfig) { c.IdleTimeout = -1 }, "IdleTimeout"},
+		{"叫号确认时间为0", func(c *RunConfig) { c.RollCall.Timeout = 0 }, "RollCall.Timeout"},
+		{"叫号超时处理未知", func(c *RunConfig) { c.RollCall.Policy = "wait" }, "RollCall.Policy"},
+		{"叫号后移位数小于0", func(c *RunConfig) { c.RollCall.SkipBy = -1 }, "RollCall.SkipBy"},
+	}
+
+	if err := ValidateConfig(validTestConfig()); err != nil {
+		t.Fatalf("基础配置应通过校验：%v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config := validTestConfig()
+			tt.modify(&Config)
+			var errs ConfigErrors
+			if !errors.As(ValidateConfig(Config), &errs) {
+				t.Fatalf("应返回 ConfigErrors")
+			}
+			if len(errs) != 1 || errs[0].Field != tt.field {
+				t.Errorf("错误 %v，应只有字段 %s", errs, tt.field)
+			}
+		})
+	}
+}
+
+// TestValidateConfigAllErrors 多个字段错误时全部返回
+func TestValidateConfigAllErrors(t *testing.T) {
+	Config := validTestConfig()
+	Config.IdCode = ""
+	Config.MaxLineCount = -1
+	Config.RollCall.Policy = "wait"
+
+	var errs ConfigErrors
+	if !errors.As(ValidateConfig(Config), &errs) {
+		t.Fatal("应返回 ConfigErrors")
+	}
+	want := []string{"IdCode", "MaxLineCount", "RollCall.Policy"}
+	if len(errs) != len(want) {
+		t.Fatalf("错误 %v，应为字段 %v", errs, want)
+	}
+	for i, field := range want {
+		if errs[i].Field != field {
+			t.Errorf("第%d项错误字段 %s，应为 %s", i, errs[i].Field, field)
+		}
+	}
+}
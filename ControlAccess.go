@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 页面服务的监听地址和控制接口的令牌可通过启动参数 -listen、-token 或以下环境变量指定
+const (
+	ListenEnv = "BLINE_LISTEN"
+	TokenEnv  = "BLINE_TOKEN"
+)
+
+const (
+	// DefaultListenAddr 默认监听地址，与旧版一致
+	DefaultListenAddr = ":100"
+	// ControlTokenFile 未指定令牌时生成的本次运行令牌，保存在配置档案目录中，供本机脚本读取
+	ControlTokenFile = "control.token"
+	// ControlTokenHeader 携带令牌的请求头，也可使用 Authorization: Bearer
+	ControlTokenHeader = "X-BLine-Token"
+)
+
+var (
+	// ListenAddr 页面服务的监听地址
+	ListenAddr = DefaultListenAddr
+	// ControlToken 控制接口的令牌，携带令牌的请求可以从其他主机发起，如容器映射端口后的访问
+	ControlToken string
+	// controlTokenGiven 令牌由启动参数或环境变量指定，重新启动时沿用
+	controlTokenGiven bool
+)
+
+// InitControlAccess 确定监听地址和令牌，需在 InitDataDir 之后调用，生成的令牌写入配置档案目录
+func InitControlAccess(ListenFlag, TokenFlag string) error {
+	switch {
+	case ListenFlag != "":
+		ListenAddr = ListenFlag
+	case os.Getenv(ListenEnv) != "":
+		ListenAddr = os.Getenv(ListenEnv)
+	}
+	if _, _, err := net.SplitHostPort(ListenAddr); err != nil {
+		return err
+	}
+
+	switch {
+	case TokenFlag != "":
+		ControlToken = TokenFlag
+	case os.Getenv(TokenEnv) != "":
+		ControlToken = os.Getenv(TokenEnv)
+	}
+	if ControlToken != "" {
+		controlTokenGiven = true
+		return nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	ControlToken = hex.EncodeToString(b)
+	return WriteFileAtomic(DataPath(ControlTokenFile), []byte(ControlToken))
+}
+
+// controlArgs 重新启动时沿用的监听地址和令牌
+func controlArgs() []string {
+	args := []string{"-listen", ListenAddr}
+	if controlTokenGiven {
+		args = append(args, "-token", ControlToken)
+	}
+	return args
+}
+
+// LocalURL 本机访问页面服务的地址，监听所有地址时使用 127.0.0.1
+func LocalURL(Path string) string {
+	host, port, err := net.SplitHostPort(ListenAddr)
+	if err != nil {
+		host, port = "", "100"
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + Path
+}
+
+// checkToken 请求是否携带了正确的令牌
+func checkToken(request *http.Request) bool {
+	token := request.Header.Get(ControlTokenHeader)
+	if token == "" {
+		token, _ = strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	}
+	return ControlToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ControlToken)) == 1
+}
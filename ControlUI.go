@@ -1,10 +1,13 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"image/color"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,72 +15,113 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
 	"golang.org/x/exp/slog"
 )
 
 var (
-	LineBoxItem   sync.Map
 	mu            sync.RWMutex
-	vbox          *fyne.Container
-	scroll        *container.Scroll
-	lastLineHash  uint64
 	refreshMutex  sync.Mutex
-	initializedUI bool
 	currentWindow fyne.Window
 	closeChan     = make(chan struct{})
-	refreshFlag   uint32
-	paused        bool = false
-	pauseBtn      *widget.Button
 	testBtn       *widget.Button
+
+	// queueViews 每个队列的控制界面，按队列标识保存，重新打开控制界面时复用
+	queueViews = make(map[string]*queueView)
+
+	superChatBox         *fyne.Container
+	lastSuperChatVersion uint64
+
+	// ctrlRefreshStop 停止上一次创建的控制界面的刷新，重新创建控制界面时关闭
+	ctrlRefreshStop chan struct{}
 )
 
-func computeLineHash() uint64 {
-	lineMu.RLock()
-	defer lineMu.RUnlock()
+// queueView 一个队列在控制界面中的标签页
+type queueView struct {
+	queue        *Queue
+	LineBoxItem  sync.Map
+	vbox         *fyne.Container
+	scroll       *container.Scroll
+	lastLineHash uint64
+	refreshFlag  uint32
+	// 叫号确认倒计时，没有叫号确认时隐藏
+	callLabel *widget.Label
+}
 
-	var hash uint64
-	for _, item := range line.GuardLine {
-		onlineStatus := uint64(0)
-		if item.IsOnline {
-			onlineStatus = 1
+func newQueueView(q *Queue) *queueView {
+	v := &queueView{queue: q, vbox: container.NewVBox(), callLabel: widget.NewLabel("")}
+	v.scroll = container.NewScroll(v.vbox)
+	v.callLabel.TextStyle.Bold = true
+	v.callLabel.Hide()
+	return v
+}
+
+// refreshCall 更新叫号确认倒计时
+func (v *queueView) refreshCall() {
+	text := ""
+	if s, ok := rollCall.State(v.queue.Name); ok {
+		if s.Confirmed {
+			text = "叫号确认：" + s.UserName + " 已确认"
+		} else {
+			text = fmt.Sprintf("叫号确认：%s 剩余 %d 秒", s.UserName, int(s.Remaining(time.Now()).Seconds()+0.5))
 		}
-		hash += uint64(len(item.UserName)) + onlineStatus
 	}
-	for _, item := range line.GiftLine {
-		onlineStatus := uint64(0)
-		if item.IsOnline {
-			onlineStatus = 1
+	fyne.Do(func() {
+		v.callLabel.SetText(text)
+		if text == "" {
+			v.callLabel.Hide()
+		} else {
+			v.callLabel.Show()
 		}
-		hash += uint64(len(item.UserName)) + uint64(item.GiftPrice) + onlineStatus
+	})
+}
+
+func computeLineHash(q *Queue) uint64 {
+	currentLine := q.Engine.Snapshot()
+
+	// 顺序、在场状态、礼物价值、备注、暂停状态任一变化都会改变哈希，房管指令暂停队列时按钮随之更新
+	h := fnv.New64a()
+	fmt.Fprintf(h, "P%t;", q.Paused())
+	for _, w := range q.Waitlist.List() {
+		fmt.Fprintf(h, "W%s|%d;", w.Line.OpenID, w.Tier)
 	}
-	for _, item := range line.CommonLine {
-		onlineStatus := uint64(0)
-		if item.IsOnline {
-			onlineStatus = 1
+	for _, tier := range currentLine.Tiers {
+		fmt.Fprintf(h, "T%s;", tier.Name)
+		for _, item := range tier.Users {
+			fmt.Fprintf(h, "%s|%t|%.2f|%s|%s;", item.OpenID, item.IsOnline, item.GiftPrice, item.Note, lastSeenText(item.OpenID))
 		}
-		hash += uint64(len(item.UserName)) + onlineStatus
 	}
-	return hash
+	return h.Sum64()
+}
+
+// lastSeenText 用户最近一次互动时间，精确到分钟，本次运行中没有互动时为空
+func lastSeenText(OpenID string) string {
+	t, ok := presence.LastSeen(OpenID)
+	if !ok {
+		return ""
+	}
+	return t.Format("15:04")
 }
 
 // 修改safeDeleteUser函数增加更安全的UI操作
-func safeDeleteUser(openID string) {
+func (v *queueView) safeDeleteUser(openID string) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	// 增强防御性检查
-	if openID == "" || vbox == nil {
-		slog.Error("无效的删除请求", slog.String("OpenID", openID), slog.Any("vbox", vbox != nil))
+	if openID == "" || v.vbox == nil {
+		slog.Error("无效的删除请求", slog.String("OpenID", openID), slog.Any("vbox", v.vbox != nil))
 		return
 	}
 
-	if container, exists := LineBoxItem.Load(openID); exists {
+	if container, exists := v.LineBoxItem.Load(openID); exists {
 		// 使用DoAndWait确保同步完成UI操作
 		fyne.DoAndWait(func() {
 			// 增加容器有效性检查
-			if container == nil || vbox == nil || vbox.Objects == nil {
+			if container == nil || v.vbox == nil || v.vbox.Objects == nil {
 				slog.Warn("尝试删除无效的UI容器")
 				return
 			}
@@ -85,12 +129,12 @@ func safeDeleteUser(openID string) {
 			// 先隐藏再移除避免渲染问题
 			if cont, ok := container.(*fyne.Container); ok {
 				cont.Hide()
-				vbox.Remove(cont)
+				v.vbox.Remove(cont)
 			}
 		})
 
 		// 立即从映射中删除
-		LineBoxItem.Delete(openID)
+		v.LineBoxItem.Delete(openID)
 
 		// 添加节流控制
 		time.Sleep(100 * time.Millisecond)
@@ -106,57 +150,128 @@ func safeDeleteUser(openID string) {
 			}
 		}()
 
-		lineMu.Lock()
-		defer lineMu.Unlock()
-		if err := DeleteLine(openID); err != nil {
+		if err := OperatorRemove(openID); err != nil {
 			slog.Error("安全删除失败", err, slog.String("OpenID", openID))
 		}
 	}()
 }
 
+// clearItems 清空标签页中的用户行
+func (v *queueView) clearItems() {
+	if v.vbox != nil {
+		v.vbox.RemoveAll()
+		v.LineBoxItem.Range(func(key, value interface{}) bool {
+			v.LineBoxItem.Delete(key)
+			return true
+		})
+	}
+}
+
 func MakeCtrlUI(w fyne.Window) fyne.CanvasObject {
 	currentWindow = w
 
-	SpecialUserList = make(map[string]SpecialUserStruct)
-	if globalConfiguration.SpecialUserList != nil {
-		SpecialUserList = globalConfiguration.SpecialUserList
+	if superChatBox == nil {
+		superChatBox = container.NewVBox()
+		w.Resize(fyne.NewSize(600, 800))
 	}
 
-	if !initializedUI {
-		vbox = container.NewVBox()
-		scroll = container.NewScroll(vbox)
-		w.Resize(fyne.NewSize(600, 800))
-		initializedUI = true
+	// Ctrl+Z 撤销，Ctrl+Y / Ctrl+Shift+Z 重做
+	w.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		go undoOperator(w)
+	})
+	w.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyY, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		go redoOperator(w)
+	})
+	w.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierShortcutDefault | fyne.KeyModifierShift}, func(fyne.Shortcut) {
+		go redoOperator(w)
+	})
+
+	// 每个队列一个标签页
+	var views []*queueView
+	tabs := container.NewAppTabs()
+	for _, q := range queues.All() {
+		v, ok := queueViews[q.Name]
+		if !ok || v.queue != q {
+			v = newQueueView(q)
+			queueViews[q.Name] = v
+		}
+		v.lastLineHash = 0
+		views = append(views, v)
+		tabs.Append(container.NewTabItem(q.Title(), container.NewBorder(v.callLabel, nil, nil, nil, v.scroll)))
 	}
 
-	refreshUI := func() {
-		if !atomic.CompareAndSwapUint32(&refreshFlag, 0, 1) {
-			return
+	if ctrlRefreshStop != nil {
+		close(ctrlRefreshStop)
+	}
+	stop := make(chan struct{})
+	ctrlRefreshStop = stop
+
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				refreshSuperChatUI(w)
+				for _, v := range views {
+					v.refreshCall()
+					currentHash := computeLineHash(v.queue)
+					if currentHash != v.lastLineHash {
+						v.refreshUI(w)
+						v.lastLineHash = currentHash
+					}
+				}
+			case <-stop:
+				return
+			case <-closeChan:
+				return
+			}
 		}
-		defer atomic.StoreUint32(&refreshFlag, 0)
+	}()
 
-		refreshMutex.Lock()
-		defer refreshMutex.Unlock()
+	return container.NewBorder(superChatBox, nil, nil, nil, tabs)
+}
 
-		lineMu.RLock()
-		currentLine := line
-		lineMu.RUnlock()
+// RefreshCtrlUI 队列增减或改名后重新创建控制界面的标签页，无窗口运行时忽略
+func RefreshCtrlUI() {
+	if CtrlWindows == nil {
+		return
+	}
+	fyne.Do(func() {
+		CtrlWindows.SetContent(MakeCtrlUI(CtrlWindows))
+	})
+}
 
+func (v *queueView) refreshUI(w fyne.Window) {
+	if !atomic.CompareAndSwapUint32(&v.refreshFlag, 0, 1) {
+		return
+	}
+	defer atomic.StoreUint32(&v.refreshFlag, 0)
+
+	refreshMutex.Lock()
+	defer refreshMutex.Unlock()
+
+	q := v.queue
+	currentLine := q.Engine.Snapshot()
+
+	fyne.Do(v.clearItems)
+
+	totalCounter := 1
+
+	// 按层级优先级依次显示，每个层级带标题
+	for tierIdx := range currentLine.Tiers {
+		tier := currentLine.Tiers[tierIdx]
+		tierTitle := widget.NewLabel(fmt.Sprintf("%s (%d)", tier.Name, len(tier.Users)))
+		tierTitle.TextStyle.Bold = true
 		fyne.Do(func() {
-			if vbox != nil {
-				vbox.RemoveAll()
-				LineBoxItem.Range(func(key, value interface{}) bool {
-					LineBoxItem.Delete(key)
-					return true
-				})
+			if v.vbox != nil {
+				v.vbox.Add(tierTitle)
 			}
 		})
 
-		totalCounter := 1
-
-		// 1. GuardLine处理
-		for idx := range currentLine.GuardLine {
-			lineTemp := &currentLine.GuardLine[idx]
+		for idx := range tier.Users {
+			lineTemp := &tier.Users[idx]
 
 			numLabel := widget.NewLabel(fmt.Sprintf("%d.", totalCounter))
 			numLabel.TextStyle.Bold = true
@@ -189,27 +304,12 @@ func MakeCtrlUI(w fyne.Window) fyne.CanvasObject {
 			updateButton()
 
 			stateBtn.OnTapped = func() {
-				lineMu.Lock()
-				lineTemp.IsOnline = !lineTemp.IsOnline
-				for i := range line.GuardLine {
-					if line.GuardLine[i].OpenID == lineTemp.OpenID {
-						line.GuardLine[i].IsOnline = lineTemp.IsOnline
-						break
-					}
-				}
-				SetLine(line)
-				lineMu.Unlock()
-
-				msg := map[string]interface{}{
-					"OpMessage": 3,
-					"Data": map[string]interface{}{
-						"OpenID":   lineTemp.OpenID,
-						"IsOnline": lineTemp.IsOnline,
-					},
-				}
-				if msgBytes, err := json.Marshal(msg); err == nil {
-					QueueChatChan <- msgBytes
+				IsOnline, err := OperatorToggleOnline(lineTemp.OpenID)
+				if err != nil {
+					slog.Error("切换在场状态失败", err, slog.String("OpenID", lineTemp.OpenID))
+					return
 				}
+				lineTemp.IsOnline = IsOnline
 
 				// 修复：使用fyne.Do包装UI更新
 				fyne.Do(func() {
@@ -219,294 +319,367 @@ func MakeCtrlUI(w fyne.Window) fyne.CanvasObject {
 			}
 
 			deleteBtn := widget.NewButton("删除", func() {
-				safeDeleteUser(lineTemp.OpenID)
+				v.safeDeleteUser(lineTemp.OpenID)
+			})
+
+			banBtn := widget.NewButton("拉黑", func() {
+				showBanDialog(w, lineTemp.OpenID, lineTemp.UserName)
 			})
+			banBtn.Importance = widget.DangerImportance
+
+			nameBox := container.NewHBox(
+				canvas.NewText(lineTemp.UserName, lineTemp.PrintColor.ToRGBA()),
+			)
+			if lineTemp.GuardLevel > 0 {
+				nameBox.Add(widget.NewLabel(GuardLevelName(lineTemp.GuardLevel)))
+			}
+			nameBox.Add(statusLabel)
+			if seen := lastSeenText(lineTemp.OpenID); seen != "" {
+				nameBox.Add(widget.NewLabel("最近互动 " + seen))
+			}
+			userBox := container.NewVBox(nameBox)
+			if lineTemp.Note != "" {
+				noteLabel := widget.NewLabel("备注：" + lineTemp.Note)
+				noteLabel.Wrapping = fyne.TextWrapWord
+				userBox.Add(noteLabel)
+			}
+			if lineTemp.GiftPrice > 0 {
+				giftInfoLabel := widget.NewLabel(fmt.Sprintf("礼物名：\"%s\"，累计礼物电池：\"%.2f\"",
+					lineTemp.GiftName, lineTemp.GiftPrice))
+				giftInfoLabel.TextStyle.Italic = true
+				giftInfoLabel.TextStyle.Monospace = true
+				userBox.Add(giftInfoLabel)
+			}
 
 			container := container.NewHBox(
-				canvas.NewText("⚓ ", color.RGBA{255, 215, 0, 255}),
+				tierIcon(tier.Sort),
 				numLabel,
-				container.NewHBox(
-					canvas.NewText(lineTemp.UserName, lineTemp.PrintColor.ToRGBA()),
-					statusLabel,
-				),
+				userBox,
 				layout.NewSpacer(),
 				container.NewHBox(
+					moveButtons(w, lineTemp.OpenID),
 					stateBtn,
 					deleteBtn,
+					banBtn,
 				),
 			)
 
-			LineBoxItem.Store(lineTemp.OpenID, container)
+			v.LineBoxItem.Store(lineTemp.OpenID, container)
 
 			fyne.Do(func() {
-				if vbox != nil {
-					vbox.Add(container)
+				if v.vbox != nil {
+					v.vbox.Add(container)
 				}
 			})
 		}
+	}
 
-		// 2. GiftLine处理
-		for idx := range currentLine.GiftLine {
-			lineTemp := &currentLine.GiftLine[idx]
+	// 等候名单只显示顺序和名字，队列有空位时自动加入
+	if waiting := q.Waitlist.List(); len(waiting) > 0 {
+		names := make([]string, 0, len(waiting))
+		for i, entry := range waiting {
+			names = append(names, fmt.Sprintf("%d.%s", i+1, entry.Line.UserName))
+		}
+		waitTitle := widget.NewLabel(fmt.Sprintf("等候名单 (%d)", len(waiting)))
+		waitTitle.TextStyle.Bold = true
+		waitLabel := widget.NewLabel(strings.Join(names, "  "))
+		waitLabel.Wrapping = fyne.TextWrapWord
+		fyne.Do(func() {
+			if v.vbox != nil {
+				v.vbox.Add(waitTitle)
+				v.vbox.Add(waitLabel)
+			}
+		})
+	}
 
-			numLabel := widget.NewLabel(fmt.Sprintf("%d.", totalCounter))
-			numLabel.TextStyle.Bold = true
-			totalCounter++
+	clearAllBtn := widget.NewButton("清空列表", func() {
+		mu.Lock()
+		defer mu.Unlock()
 
-			statusLabel := widget.NewLabel("")
-			updateStatus := func() {
-				text := ""
-				if !lineTemp.IsOnline {
-					text = "(不在)"
-				}
-				statusLabel.SetText(text)
-				statusLabel.Refresh()
-			}
-			updateStatus()
+		fyne.Do(v.clearItems)
 
-			giftInfoLabel := widget.NewLabel(fmt.Sprintf("礼物名：\"%s\"，累计礼物电池：\"%.2f\"",
-				lineTemp.GiftName, lineTemp.GiftPrice))
-			giftInfoLabel.TextStyle.Italic = true
-			giftInfoLabel.TextStyle.Monospace = true
+		go OperatorClear(q)
+	})
+	clearAllBtn.Importance = widget.DangerImportance
 
-			stateBtn := widget.NewButton("", func() {})
-			updateButton := func() {
-				fyne.Do(func() {
-					if lineTemp.IsOnline {
-						stateBtn.SetText("离场")
-						stateBtn.Importance = widget.HighImportance
-					} else {
-						stateBtn.SetText("在场")
-						stateBtn.Importance = widget.MediumImportance
-					}
-					stateBtn.Refresh()
-				})
-			}
-			updateButton()
+	// 暂停状态保存在队列中，刷新后保持
+	pauseBtn := widget.NewButton("暂停排队", nil)
+	if q.Paused() {
+		pauseBtn.SetText("恢复排队")
+	}
+	pauseBtn.OnTapped = func() {
+		q.SetPaused(!q.Paused())
+		if q.Paused() {
+			pauseBtn.SetText("恢复排队")
+		} else {
+			pauseBtn.SetText("暂停排队")
+		}
+	}
+	pauseBtn.Importance = widget.WarningImportance
+
+	restoreBtn := widget.NewButton("恢复队列", func() {
+		showRestoreDialog(w, q)
+	})
+
+	historyBtn := widget.NewButton("叫号记录", func() {
+		showServeHistoryDialog(w)
+	})
+
+	undoBtn := widget.NewButton("撤销", func() {
+		go undoOperator(w)
+	})
+	redoBtn := widget.NewButton("重做", func() {
+		go redoOperator(w)
+	})
+
+	buttonRow := container.NewHBox()
+	buttonRow.Add(pauseBtn)
+	buttonRow.Add(undoBtn)
+	buttonRow.Add(redoBtn)
+	buttonRow.Add(layout.NewSpacer())
+	buttonRow.Add(historyBtn)
+	buttonRow.Add(widget.NewButton("黑名单", ShowBlacklistWindow))
+	buttonRow.Add(restoreBtn)
+	buttonRow.Add(clearAllBtn)
+
+	// 替换最后的返回部分
+	fyne.Do(func() {
+		if v.vbox != nil {
+			v.vbox.Add(container.NewCenter(buttonRow))
+			v.vbox.Refresh()
+		}
+		if v.scroll != nil {
+			v.scroll.Refresh()
+		}
+	})
+}
 
-			stateBtn.OnTapped = func() {
-				lineMu.Lock()
-				lineTemp.IsOnline = !lineTemp.IsOnline
-				for i := range line.GiftLine {
-					if line.GiftLine[i].OpenID == lineTemp.OpenID {
-						line.GiftLine[i].IsOnline = lineTemp.IsOnline
-						break
-					}
-				}
-				SetLine(line)
-				lineMu.Unlock()
-
-				msg := map[string]interface{}{
-					"OpMessage": 3,
-					"Data": map[string]interface{}{
-						"OpenID":   lineTemp.OpenID,
-						"IsOnline": lineTemp.IsOnline,
-					},
-				}
-				if msgBytes, err := json.Marshal(msg); err == nil {
-					QueueChatChan <- msgBytes
-				}
+// refreshSuperChatUI 醒目留言列表变化时重建控制界面顶部的未读醒目留言
+func refreshSuperChatUI(w fyne.Window) {
+	active := superChats.Active()
+	version := superChats.Version()
+	if atomic.SwapUint64(&lastSuperChatVersion, version) == version {
+		return
+	}
 
-				// 修复：使用fyne.Do包装UI更新
-				fyne.Do(func() {
-					updateStatus()
-					updateButton()
-				})
+	var rows []fyne.CanvasObject
+	for _, sc := range active {
+		if sc.IsRead {
+			continue
+		}
+		MessageID := sc.MessageID
+		readBtn := widget.NewButton("已读", func() {
+			if err := superChats.MarkRead(MessageID); err != nil {
+				slog.Warn("醒目留言标记已读失败", err, slog.Int("MessageID", MessageID))
 			}
+			go refreshSuperChatUI(w)
+		})
+		messageLabel := widget.NewLabel(fmt.Sprintf("%s：%s", sc.UserName, sc.Message))
+		messageLabel.Wrapping = fyne.TextWrapWord
+		rows = append(rows, container.NewBorder(nil, nil,
+			canvas.NewText(fmt.Sprintf("￥%d", sc.Rmb), color.RGBA{255, 170, 0, 255}),
+			container.NewHBox(
+				widget.NewLabel("至 "+time.Unix(sc.EndTime, 0).Format("15:04:05")),
+				readBtn,
+			),
+			messageLabel,
+		))
+	}
+	if len(rows) > 0 {
+		title := widget.NewLabel(fmt.Sprintf("醒目留言(%d)", len(rows)))
+		title.TextStyle.Bold = true
+		rows = append([]fyne.CanvasObject{title}, rows...)
+		rows = append(rows, widget.NewSeparator())
+	}
 
-			giftDeleteBtn := widget.NewButton("删除", func() {
-				safeDeleteUser(lineTemp.OpenID)
-			})
+	fyne.Do(func() {
+		if superChatBox == nil {
+			return
+		}
+		superChatBox.Objects = rows
+		superChatBox.Refresh()
+	})
+}
 
-			container := container.NewHBox(
-				canvas.NewText("🎁 ", color.RGBA{255, 0, 0, 255}),
-				numLabel,
-				container.NewVBox(
-					container.NewHBox(
-						canvas.NewText(lineTemp.UserName, lineTemp.PrintColor.ToRGBA()),
-						statusLabel,
-					),
-					giftInfoLabel,
-				),
-				layout.NewSpacer(),
-				container.NewHBox(
-					stateBtn,
-					giftDeleteBtn,
-				),
-			)
+// moveButtons 用户行的上移、下移和移动按钮
+func moveButtons(w fyne.Window, OpenID string) *fyne.Container {
+	upBtn := widget.NewButton("↑", func() {
+		go runMoveAction(w, OpenID, MoveUp)
+	})
+	downBtn := widget.NewButton("↓", func() {
+		go runMoveAction(w, OpenID, MoveDown)
+	})
+	moveBtn := widget.NewButton("移动", func() {
+		showMoveDialog(w, OpenID)
+	})
+	return container.NewHBox(upBtn, downBtn, moveBtn)
+}
 
-			LineBoxItem.Store(lineTemp.OpenID, container)
+func runMoveAction(w fyne.Window, OpenID, Action string) {
+	if err := OperatorMoveAction(OpenID, Action, 0); err != nil {
+		slog.Error("移动用户失败", err, slog.String("OpenID", OpenID), slog.String("action", Action))
+		fyne.Do(func() {
+			dialog.ShowError(DisplayError{Message: "移动失败：" + err.Error()}, w)
+		})
+	}
+}
 
-			fyne.Do(func() {
-				if vbox != nil {
-					vbox.Add(container)
-				}
-			})
-		}
+// tierIcon 层级图标，按层级的排序方式区分
+func tierIcon(Sort string) fyne.CanvasObject {
+	switch Sort {
+	case TierSortGuard:
+		return canvas.NewText("⚓ ", color.RGBA{255, 215, 0, 255})
+	case TierSortGift:
+		return canvas.NewText("🎁 ", color.RGBA{255, 0, 0, 255})
+	}
+	return canvas.NewText("💬 ", color.RGBA{0, 150, 255, 255})
+}
 
-		// 3. CommonLine处理
-		if len(currentLine.CommonLine) != 0 {
-			for idx := range currentLine.CommonLine {
-				lineTemp := &currentLine.CommonLine[idx]
+// showMoveDialog 将用户移动到指定层级的指定位置，位置从1开始，也可以移动到其他命名队列
+func showMoveDialog(w fyne.Window, OpenID string) {
+	q, ok := queues.Find(OpenID)
+	if !ok {
+		return
+	}
+	le, ok := q.Engine.Entry(OpenID)
+	if !ok {
+		return
+	}
 
-				numLabel := widget.NewLabel(fmt.Sprintf("%d.", totalCounter))
-				numLabel.TextStyle.Bold = true
-				totalCounter++
+	lineSelect := widget.NewSelect(q.Engine.TierNames(), nil)
+	lineSelect.SetSelectedIndex(le.LineType)
+	posEntry := widget.NewEntry()
+	posEntry.SetText(strconv.Itoa(le.Index + 1))
 
-				statusLabel := widget.NewLabel("")
-				updateStatus := func() {
-					text := ""
-					if !lineTemp.IsOnline {
-						text = "(不在)"
-					}
-					statusLabel.SetText(text)
-					statusLabel.Refresh()
-				}
-				updateStatus()
+	items := []*widget.FormItem{
+		widget.NewFormItem("目标层级", lineSelect),
+		widget.NewFormItem("位置", posEntry),
+	}
 
-				stateBtn := widget.NewButton("", func() {})
-				updateButton := func() {
-					fyne.Do(func() {
-						if lineTemp.IsOnline {
-							stateBtn.SetText("离场")
-							stateBtn.Importance = widget.HighImportance
-						} else {
-							stateBtn.SetText("在场")
-							stateBtn.Importance = widget.MediumImportance
-						}
-						stateBtn.Refresh()
-					})
-				}
-				updateButton()
-
-				stateBtn.OnTapped = func() {
-					lineMu.Lock()
-					lineTemp.IsOnline = !lineTemp.IsOnline
-					for i := range line.CommonLine {
-						if line.CommonLine[i].OpenID == lineTemp.OpenID {
-							line.CommonLine[i].IsOnline = lineTemp.IsOnline
-							break
-						}
-					}
-					SetLine(line)
-					lineMu.Unlock()
-
-					msg := map[string]interface{}{
-						"OpMessage": 3,
-						"Data": map[string]interface{}{
-							"OpenID":   lineTemp.OpenID,
-							"IsOnline": lineTemp.IsOnline,
-						},
-					}
-					if msgBytes, err := json.Marshal(msg); err == nil {
-						QueueChatChan <- msgBytes
-					}
+	// 其他队列，选择后忽略层级和位置，按层级条件排到目标队列
+	var otherQueues []*Queue
+	queueTitles := []string{"不移动"}
+	for _, other := range queues.All() {
+		if other != q {
+			otherQueues = append(otherQueues, other)
+			queueTitles = append(queueTitles, other.Title())
+		}
+	}
+	queueSelect := widget.NewSelect(queueTitles, nil)
+	queueSelect.SetSelectedIndex(0)
+	if len(otherQueues) > 0 {
+		items = append(items, widget.NewFormItem("移到队列", queueSelect))
+	}
 
-					// 修复：使用fyne.Do包装UI更新
+	dialog.ShowForm("移动 "+entryName(le), "移动", "取消", items, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		if i := queueSelect.SelectedIndex(); i > 0 {
+			ToQueue := otherQueues[i-1].Name
+			go func() {
+				if err := OperatorMoveQueue(OpenID, ToQueue); err != nil {
+					slog.Error("移动用户到其他队列失败", err, slog.String("OpenID", OpenID), slog.String("Queue", ToQueue))
 					fyne.Do(func() {
-						updateStatus()
-						updateButton()
+						dialog.ShowError(DisplayError{Message: "移动失败：" + err.Error()}, w)
 					})
 				}
-
-				commonDeleteBtn := widget.NewButton("删除", func() {
-					safeDeleteUser(lineTemp.OpenID)
-				})
-
-				container := container.NewHBox(
-					canvas.NewText("💬 ", color.RGBA{0, 150, 255, 255}),
-					numLabel,
-					container.NewHBox(
-						canvas.NewText(lineTemp.UserName, lineTemp.PrintColor.ToRGBA()),
-						statusLabel,
-					),
-					layout.NewSpacer(),
-					container.NewHBox(
-						stateBtn,
-						commonDeleteBtn,
-					),
-				)
-
-				LineBoxItem.Store(lineTemp.OpenID, container)
-
+			}()
+			return
+		}
+		pos, err := strconv.Atoi(strings.TrimSpace(posEntry.Text))
+		if err != nil || pos < 1 {
+			dialog.ShowError(DisplayError{Message: "位置必须是大于0的整数"}, w)
+			return
+		}
+		go func() {
+			if err := OperatorTransfer(OpenID, lineSelect.SelectedIndex(), pos-1); err != nil {
+				slog.Error("移动用户失败", err, slog.String("OpenID", OpenID))
 				fyne.Do(func() {
-					if vbox != nil {
-						vbox.Add(container)
-					}
+					dialog.ShowError(DisplayError{Message: "移动失败：" + err.Error()}, w)
 				})
 			}
-		}
-
-		clearAllBtn := widget.NewButton("清空列表", func() {
-			mu.Lock()
-			defer mu.Unlock()
-
-			fyne.Do(func() {
-				if vbox != nil {
-					vbox.RemoveAll()
-					LineBoxItem.Range(func(key, value interface{}) bool {
-						LineBoxItem.Delete(key)
-						return true
-					})
-				}
-			})
+		}()
+	}, w)
+}
 
-			go func() {
-				lineMu.Lock()
-				defer lineMu.Unlock()
-				line.GuardLine = []Line{}
-				line.GiftLine = []GiftLine{}
-				line.CommonLine = []Line{}
-				SetLine(line)
-			}()
-		})
-		clearAllBtn.Importance = widget.DangerImportance
-
-		// 初始化暂停按钮
-		pauseBtn = widget.NewButton("暂停排队", func() {
-			paused = !paused
-			if paused {
-				pauseBtn.SetText("恢复排队")
-			} else {
-				pauseBtn.SetText("暂停排队")
-			}
+// undoOperator 撤销最近一次操作员操作
+func undoOperator(w fyne.Window) {
+	name, err := operatorHistory.Undo()
+	switch {
+	case errors.Is(err, ErrNothingToUndo):
+		return
+	case err != nil:
+		slog.Error("撤销失败", err, slog.String("action", name))
+		fyne.Do(func() {
+			dialog.ShowError(DisplayError{Message: "撤销 " + name + " 失败：" + err.Error()}, w)
 		})
-		pauseBtn.Importance = widget.WarningImportance
-
-		buttonRow := container.NewHBox()
-		buttonRow.Add(pauseBtn)
-		buttonRow.Add(layout.NewSpacer())
-		buttonRow.Add(clearAllBtn)
+	default:
+		slog.Info("撤销操作", slog.String("action", name))
+	}
+}
 
-		// 替换最后的返回部分
+// redoOperator 重做最近一次被撤销的操作员操作
+func redoOperator(w fyne.Window) {
+	name, err := operatorHistory.Redo()
+	switch {
+	case errors.Is(err, ErrNothingToRedo):
+		return
+	case err != nil:
+		slog.Error("重做失败", err, slog.String("action", name))
 		fyne.Do(func() {
-			if vbox != nil {
-				vbox.Add(container.NewCenter(buttonRow))
-				vbox.Refresh()
-			}
-			if scroll != nil {
-				scroll.Refresh()
-			}
+			dialog.ShowError(DisplayError{Message: "重做 " + name + " 失败：" + err.Error()}, w)
 		})
+	default:
+		slog.Info("重做操作", slog.String("action", name))
 	}
+}
 
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				currentHash := computeLineHash()
-				if currentHash != lastLineHash {
-					refreshUI()
-					lastLineHash = currentHash
-				}
-			case <-closeChan:
-				return
-			}
+// showServeHistoryDialog 本场叫号记录，最新的在前
+func showServeHistoryDialog(w fyne.Window) {
+	list := container.NewVBox()
+	for _, rec := range serveHistory.History() {
+		kind := "叫号"
+		if rec.Kind == ServeRemoved {
+			kind = "取消排队"
 		}
-	}()
+		list.Add(widget.NewLabel(fmt.Sprintf("%s  %s  %s", time.Unix(rec.Time, 0).Format("15:04:05"), rec.UserName, kind)))
+	}
+	if len(list.Objects) == 0 {
+		list.Add(widget.NewLabel("本场暂无叫号记录"))
+	}
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(360, 400))
+	dialog.ShowCustom("叫号记录", "关闭", scroll, w)
+}
 
-	return scroll
+// showRestoreDialog 将队列恢复到指定时间点，默认填入最近一次清空前一秒
+func showRestoreDialog(w fyne.Window, q *Queue) {
+	timeEntry := widget.NewEntry()
+	timeEntry.SetPlaceHolder("2006-01-02 15:04:05")
+	if clearTime, ok := q.Engine.LastClearTime(); ok {
+		timeEntry.SetText(clearTime.Add(-time.Second).Format("2006-01-02 15:04:05"))
+	} else {
+		timeEntry.SetText(time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05"))
+	}
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("恢复到", timeEntry),
+	}
+	dialog.ShowForm("恢复"+q.Title(), "恢复", "取消", items, func(confirm bool) {
+		if !confirm {
+			return
+		}
+		At, err := ParseRestoreTime(timeEntry.Text)
+		if err != nil {
+			dialog.ShowError(DisplayError{Message: "时间格式错误，请使用 2006-01-02 15:04:05"}, w)
+			return
+		}
+		if err = q.Engine.RestoreAt(At); err != nil {
+			slog.Error("队列恢复失败", err)
+			dialog.ShowError(DisplayError{Message: "队列恢复失败：" + err.Error()}, w)
+			return
+		}
+		dialog.ShowInformation("恢复成功", "队列已恢复到 "+At.Format("2006-01-02 15:04:05"), w)
+	}, w)
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// 数据目录和配置档案可通过启动参数 -data、-profile 或以下环境变量指定
+const (
+	DataDirEnv = "BLINE_DATA_DIR"
+	ProfileEnv = "BLINE_PROFILE"
+)
+
+const (
+	// DefaultProfile 默认配置档案，文件直接放在数据目录下，与旧版的文件布局一致
+	DefaultProfile = "default"
+	// ProfilesDir 其他配置档案所在的子目录，每个档案一个目录
+	ProfilesDir = "profiles"
+	// LastProfileFile 记录上次使用的配置档案，未指定配置档案时使用
+	LastProfileFile = "profile"
+	// AppDirName 系统配置目录下的应用目录名
+	AppDirName = "BiliLine"
+)
+
+var ErrInvalidProfile = errors.New("配置档案名称只能包含文字、数字、下划线和减号")
+
+var profileNameRegexp = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+var (
+	// DataDir 数据目录
+	DataDir = "."
+	// Profile 当前配置档案
+	Profile = DefaultProfile
+	// ProfileDir 当前配置档案的目录，配置、队列、流水和日志都保存在这里
+	ProfileDir = "."
+)
+
+// ResolveDataDir 按启动参数、环境变量的顺序选择数据目录
+// 都未指定时，工作目录中已有旧版配置文件则沿用工作目录，否则使用系统配置目录（Linux 下遵循 XDG_CONFIG_HOME）
+func ResolveDataDir(Flag string) string {
+	if Flag != "" {
+		return Flag
+	}
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		return dir
+	}
+	if _, err := os.Stat(ConfigFile); err == nil {
+		return "."
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, AppDirName)
+	}
+	return "."
+}
+
+// ResolveProfile 按启动参数、环境变量、上次使用的顺序选择配置档案
+func ResolveProfile(Flag, DataDir string) string {
+	if Flag != "" {
+		return Flag
+	}
+	if name := os.Getenv(ProfileEnv); name != "" {
+		return name
+	}
+	if data, err := os.ReadFile(filepath.Join(DataDir, LastProfileFile)); err == nil {
+		if name := strings.TrimSpace(string(data)); ValidateProfileName(name) == nil {
+			return name
+		}
+	}
+	return DefaultProfile
+}
+
+// ValidateProfileName 检查配置档案名称，名称会作为目录名使用
+func ValidateProfileName(Name string) error {
+	if !profileNameRegexp.MatchString(Name) {
+		return ErrInvalidProfile
+	}
+	return nil
+}
+
+// profileDir 配置档案的目录
+func profileDir(Name string) string {
+	if Name == DefaultProfile {
+		return DataDir
+	}
+	return filepath.Join(DataDir, ProfilesDir, Name)
+}
+
+// InitDataDir 确定数据目录和配置档案并创建目录，需在读取任何数据文件之前调用
+func InitDataDir(DataFlag, ProfileFlag string) error {
+	dir, err := filepath.Abs(ResolveDataDir(DataFlag))
+	if err != nil {
+		return err
+	}
+	name := ResolveProfile(ProfileFlag, dir)
+	if err = ValidateProfileName(name); err != nil {
+		return err
+	}
+	DataDir = dir
+	Profile = name
+	ProfileDir = profileDir(name)
+	if err = os.MkdirAll(ProfileDir, 0o755); err != nil {
+		return err
+	}
+	openProfileData()
+	return saveLastProfile(name)
+}
+
+// openProfileData 按配置档案目录创建黑名单、礼物流水和审计记录
+func openProfileData() {
+	blacklist = NewBlacklist(DataPath(BlacklistFile))
+	giftLedger = NewGiftLedger(DataPath(GiftLedgerFile))
+	auditLog = NewAuditLog(DataPath(AuditLogFile))
+}
+
+// saveLastProfile 记录上次使用的配置档案
+func saveLastProfile(Name string) error {
+	return WriteFileAtomic(filepath.Join(DataDir, LastProfileFile), []byte(Name))
+}
+
+// DataPath 当前配置档案中的文件路径
+func DataPath(Name string) string {
+	return filepath.Join(ProfileDir, Name)
+}
+
+// ListProfiles 数据目录中的全部配置档案，默认档案在最前
+func ListProfiles() []string {
+	res := []string{DefaultProfile}
+	dir, err := os.ReadDir(filepath.Join(DataDir, ProfilesDir))
+	if err != nil {
+		return res
+	}
+	var names []string
+	for _, entry := range dir {
+		if entry.IsDir() && entry.Name() != DefaultProfile && ValidateProfileName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return append(res, names...)
+}
+
+// SwitchProfile 切换到配置档案并重新启动，档案不存在时创建
+func SwitchProfile(Name string) error {
+	if err := ValidateProfileName(Name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profileDir(Name), 0o755); err != nil {
+		return err
+	}
+	if err := saveLastProfile(Name); err != nil {
+		return err
+	}
+	slog.Info("切换配置档案", slog.String("from", Profile), slog.String("to", Name))
+	Profile = Name
+	Restart()
+	return nil
+}
+
+// restartArgs 重新启动时沿用的启动参数
+func restartArgs() []string {
+	return append([]string{"-data", DataDir, "-profile", Profile}, controlArgs()...)
+}
+
+// ResourceDir 页面资源目录，依次查找数据目录和程序所在目录，都没有时使用工作目录
+func ResourceDir() string {
+	candidates := []string{filepath.Join(DataDir, "Resource")}
+	if exePath, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exePath), "Resource"))
+	}
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return "Resource"
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// GiftLedgerFile 礼物流水，每行一条 GiftRecord
+const GiftLedgerFile = "gift.ledger"
+
+// 礼物流水类型
+const (
+	LedgerGift      = "gift"
+	LedgerGuard     = "guard"
+	LedgerSuperChat = "superchat"
+)
+
+// GiftRecord 一条礼物流水，Price 为总价值(电池)
+type GiftRecord struct {
+	Time       int64 // 毫秒时间戳
+	Kind       string
+	OpenID     string
+	UserName   string
+	GiftID     int    `json:",omitempty"`
+	GiftName   string `json:",omitempty"`
+	Num        int
+	Price      float64
+	Paid       bool
+	GuardLevel int    `json:",omitempty"`
+	GuardUnit  string `json:",omitempty"`
+}
+
+// GiftLedger 礼物流水记录，只追加不修改
+type GiftLedger struct {
+	w *JsonLinesWriter
+}
+
+// giftLedger 启动时由 InitDataDir 改为配置档案目录中的文件
+var giftLedger = NewGiftLedger(GiftLedgerFile)
+
+func NewGiftLedger(path string) *GiftLedger {
+	return &GiftLedger{w: NewJsonLinesWriter(path)}
+}
+
+// Record 追加一条流水
+func (l *GiftLedger) Record(rec GiftRecord) error {
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixMilli()
+	}
+	return l.w.Append(rec)
+}
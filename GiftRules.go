@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GiftRuleConfig 礼物入队规则，单次礼物门槛使用 RunConfig.GiftLinePrice
+type GiftRuleConfig struct {
+	// 本场累计礼物价值门槛(电池)，0为不启用
+	CumulativePrice float64
+	// 只统计这些礼物ID，为空时统计全部礼物
+	AllowGiftIDs []int
+	// 不统计这些礼物ID，优先于 AllowGiftIDs
+	DenyGiftIDs []int
+	// 按礼物ID覆盖单个礼物价值(电池)
+	ValueOverrides map[int]float64
+	// 免费(银瓜子)礼物也计入价值
+	CountFreeGift bool
+}
+
+// GiftInput 一次送礼，Price 为单个礼物价格(金瓜子/银瓜子)
+type GiftInput struct {
+	OpenID string
+	GiftID int
+	Price  int
+	Num    int
+	Paid   bool
+}
+
+// GiftDecision 规则判定结果，Value 为本次计入的价值(电池)，Total 为本场累计价值
+type GiftDecision struct {
+	Counted bool
+	Join    bool
+	Value   float64
+	Total   float64
+	Reason  string
+}
+
+// GiftRuleEngine 按规则计算礼物价值并统计本场累计
+type GiftRuleEngine struct {
+	mu      sync.Mutex
+	session map[string]float64
+}
+
+var giftRules = NewGiftRuleEngine()
+
+func NewGiftRuleEngine() *GiftRuleEngine {
+	return &GiftRuleEngine{session: make(map[string]float64)}
+}
+
+// ResetSession 开始新的一场直播，清空累计价值
+func (g *GiftRuleEngine) ResetSession() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.session = make(map[string]float64)
+}
+
+// Evaluate 按规则判定一次送礼，SinglePrice 为单次礼物门槛(电池)，小于等于0时任意计入的礼物都可入队
+func (g *GiftRuleEngine) Evaluate(Rules GiftRuleConfig, SinglePrice float64, Gift GiftInput) GiftDecision {
+	switch {
+	case containsID(Rules.DenyGiftIDs, Gift.GiftID):
+		return GiftDecision{Reason: "礼物在排除列表中"}
+	case len(Rules.AllowGiftIDs) > 0 && !containsID(Rules.AllowGiftIDs, Gift.GiftID):
+		return GiftDecision{Reason: "礼物不在允许列表中"}
+	case !Gift.Paid && !Rules.CountFreeGift:
+		return GiftDecision{Reason: "免费礼物不计入"}
+	}
+
+	unit := float64(Gift.Price) / 100.0
+	if override, ok := Rules.ValueOverrides[Gift.GiftID]; ok {
+		unit = override
+	}
+	d := GiftDecision{Counted: true, Value: unit * float64(Gift.Num)}
+
+	g.mu.Lock()
+	g.session[Gift.OpenID] += d.Value
+	d.Total = g.session[Gift.OpenID]
+	g.mu.Unlock()
+
+	switch {
+	case SinglePrice <= 0 && Rules.CumulativePrice <= 0:
+		d.Join = true
+	case SinglePrice > 0 && d.Value >= SinglePrice:
+		d.Join = true
+	case Rules.CumulativePrice > 0 && d.Total >= Rules.CumulativePrice:
+		d.Join = true
+	default:
+		d.Reason = "未达到礼物价值门槛"
+	}
+	return d
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGiftIDList 解析以逗号或空白分隔的礼物ID列表
+func ParseGiftIDList(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == ' ' || r == '\n'
+	}) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("无效的礼物ID：%s", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// FormatGiftIDList 将礼物ID列表格式化为逗号分隔
+func FormatGiftIDList(ids []int) string {
+	fields := make([]string, 0, len(ids))
+	for _, id := range ids {
+		fields = append(fields, strconv.Itoa(id))
+	}
+	return strings.Join(fields, ",")
+}
+
+// ParseGiftOverrides 解析每行一条 "礼物ID=价值" 的价值覆盖
+func ParseGiftOverrides(s string) (map[int]float64, error) {
+	overrides := make(map[int]float64)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		idText, valueText, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("价值覆盖格式错误：%s", line)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(idText))
+		if err != nil {
+			return nil, fmt.Errorf("无效的礼物ID：%s", idText)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(valueText), 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("无效的礼物价值：%s", valueText)
+		}
+		overrides[id] = value
+	}
+	return overrides, nil
+}
+
+// FormatGiftOverrides 将价值覆盖格式化为每行一条 "礼物ID=价值"
+func FormatGiftOverrides(overrides map[int]float64) string {
+	ids := make([]int, 0, len(overrides))
+	for id := range overrides {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, strconv.Itoa(id)+"="+strconv.FormatFloat(overrides[id], 'f', -1, 64))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGiftRuleEvaluate(t *testing.T) {
+	rules := GiftRuleConfig{
+		CumulativePrice: 50,
+		AllowGiftIDs:    []int{1, 2, 3},
+		DenyGiftIDs:     []int{3},
+		ValueOverrides:  map[int]float64{2: 0.5},
+	}
+	tests := []struct {
+		name   string
+		rules  GiftRuleConfig
+		single float64
+		gift   GiftInput
+		want   GiftDecision
+	}{
+		{
+			name:  "排除优先于允许",
+			rules: rules,
+			gift:  GiftInput{OpenID: "a", GiftID: 3, Price: 10000, Num: 1, Paid: true},
+			want:  GiftDecision{Reason: "礼物在排除列表中"},
+		},
+		{
+			name:  "不在允许列表中",
+			rules: rules,
+			gift:  GiftInput{OpenID: "a", GiftID: 4, Price: 10000, Num: 1, Paid: true},
+			want:  GiftDecision{Reason: "礼物不在允许列表中"},
+		},
+		{
+			name:  "免费礼物不计入",
+			rules: rules,
+			gift:  GiftInput{OpenID: "a", GiftID: 1, Price: 10000, Num: 1},
+			want:  GiftDecision{Reason: "免费礼物不计入"},
+		},
+		{
+			name:  "免费礼物按配置计入",
+			rules: GiftRuleConfig{CountFreeGift: true},
+			gift:  GiftInput{OpenID: "free", GiftID: 1, Price: 100, Num: 2},
+			want:  GiftDecision{Counted: true, Join: true, Value: 2, Total: 2},
+		},
+		{
+			name:   "达到单次门槛",
+			rules:  rules,
+			single: 10,
+			gift:   GiftInput{OpenID: "a", GiftID: 1, Price: 1000, Num: 1, Paid: true},
+			want:   GiftDecision{Counted: true, Join: true, Value: 10, Total: 10},
+		},
+		{
+			name:   "价值覆盖后未达到门槛",
+			rules:  rules,
+			single: 10,
+			gift:   GiftInput{OpenID: "a", GiftID: 2, Price: 100000, Num: 4, Paid: true},
+			want:   GiftDecision{Counted: true, Value: 2, Total: 12, Reason: "未达到礼物价值门槛"},
+		},
+		{
+			name:   "累计达到门槛",
+			rules:  rules,
+			single: 100,
+			gift:   GiftInput{OpenID: "a", GiftID: 1, Price: 3800, Num: 1, Paid: true},
+			want:   GiftDecision{Counted: true, Join: true, Value: 38, Total: 50},
+		},
+		{
+			name:   "其他用户单独累计",
+			rules:  rules,
+			single: 100,
+			gift:   GiftInput{OpenID: "b", GiftID: 1, Price: 3800, Num: 1, Paid: true},
+			want:   GiftDecision{Counted: true, Value: 38, Total: 38, Reason: "未达到礼物价值门槛"},
+		},
+		{
+			name: "未设置门槛时任意计入的礼物都可入队",
+			gift: GiftInput{OpenID: "c", GiftID: 9, Price: 100, Num: 1, Paid: true},
+			want: GiftDecision{Counted: true, Join: true, Value: 1, Total: 1},
+		},
+	}
+
+	// 用例按顺序共用同一场直播的累计
+	g := NewGiftRuleEngine()
+	for _, tt := range tests {
+		if got := g.Evaluate(tt.rules, tt.single, tt.gift); got != tt.want {
+			t.Errorf("%s：得到 %+v，应为 %+v", tt.name, got, tt.want)
+		}
+	}
+
+	g.ResetSession()
+	got := g.Evaluate(rules, 100, GiftInput{OpenID: "a", GiftID: 1, Price: 1000, Num: 1, Paid: true})
+	if got.Total != 10 || got.Join {
+		t.Errorf("新的一场直播应重新累计，得到 %+v", got)
+	}
+}
+
+func TestParseGiftRules(t *testing.T) {
+	ids, err := ParseGiftIDList("1, 2，3\n 31036")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 31036}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("礼物ID %v，应为 %v", ids, want)
+	}
+	if s := FormatGiftIDList(ids); s != "1,2,3,31036" {
+		t.Errorf("格式化为 %q", s)
+	}
+	if _, err = ParseGiftIDList("1,abc"); err == nil {
+		t.Error("无效的礼物ID应返回错误")
+	}
+
+	overrides, err := ParseGiftOverrides("31036 = 0.1\n\n1=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]float64{31036: 0.1, 1: 20}; !reflect.DeepEqual(overrides, want) {
+		t.Errorf("价值覆盖 %v，应为 %v", overrides, want)
+	}
+	if s := FormatGiftOverrides(overrides); s != "1=20\n31036=0.1" {
+		t.Errorf("格式化为 %q", s)
+	}
+	for _, bad := range []string{"1", "x=1", "1=-2", "1=abc"} {
+		if _, err = ParseGiftOverrides(bad); err == nil {
+			t.Errorf("%q 应返回错误", bad)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// RunHeadless 不创建窗口运行：读取配置、连接直播间、启动页面服务和后台任务，通过本机接口和队列页面控制
+// 收到 SIGINT/SIGTERM 时断开连接并写入队列快照后退出
+func RunHeadless() {
+	Config, err := GetConfig()
+	if err != nil {
+		// 配置无效时仍启动页面服务，可以通过 /setConfig 修改配置后自动连接
+		slog.Error("Get config Err", err)
+		fmt.Println("配置读取失败，可通过 /setConfig 修改配置:\n" + err.Error())
+		queues.Apply(RunConfig{})
+	} else {
+		setConfiguration(Config)
+		queues.Apply(Config)
+	}
+
+	// 页面服务是无窗口运行时唯一的控制方式，无法监听时直接退出
+	go func() {
+		err := StartWebServer()
+		fmt.Println("页面服务启动失败:", err)
+		Shutdown()
+		os.Exit(1)
+	}()
+	go RunPresenceSweeper()
+	go RunRollCall()
+	go WatchConfig()
+
+	fmt.Println("页面服务地址:", LocalURL("/web"))
+	if !controlTokenGiven {
+		fmt.Println("控制接口令牌已写入:", DataPath(ControlTokenFile))
+	}
+
+	if err == nil {
+		go connectWithRetry(Config.IdCode)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	slog.Info("收到退出信号", slog.String("signal", sig.String()))
+	fmt.Println("正在退出...")
+	Shutdown()
+	os.Exit(0)
+}
+
+// connectWithRetry 连接直播间，失败时等待后重试，与窗口模式的重试次数一致
+func connectWithRetry(IdCode string) {
+	for retryCount := 1; ; retryCount++ {
+		if err := ReconnectRoom(IdCode); err == nil {
+			slog.Info("已连接直播间", slog.Int("RoomId", RoomId))
+			fmt.Println("已连接直播间", RoomId)
+			return
+		}
+		if retryCount > 3 {
+			slog.Error("达到最大重试次数，停止连接，可通过 /reconnect 重新连接")
+			return
+		}
+		slog.Info(fmt.Sprintf("第%d次连接失败，5秒后重试...", retryCount))
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Shutdown 退出前断开弹幕连接，并为各队列写入快照、关闭队列日志
+func Shutdown() {
+	DisconnectRoom()
+	for _, q := range queues.All() {
+		q.Engine.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/vtb-link/bianka/proto"
+)
+
+// JoinRule 弹幕排队资格要求，全部满足才能加入队列
+type JoinRule struct {
+	// 必须佩戴本直播间粉丝牌
+	RequireMedal bool
+	// 本直播间粉丝牌等级不低于，0为不要求，设置后同样要求佩戴
+	MinMedalLevel int
+	// 大航海等级不低于，1总督 2提督 3舰长，0为不要求
+	MinGuardLevel int
+}
+
+// Check 按弹幕中的粉丝牌和大航海信息检查资格，返回不满足时的原因，满足时返回空字符串
+func (r JoinRule) Check(Dm *proto.CmdDanmuData) string {
+	switch {
+	case (r.RequireMedal || r.MinMedalLevel > 0) && !Dm.FansMedalWearingStatus:
+		return "需要佩戴本直播间粉丝牌"
+	case r.MinMedalLevel > 0 && Dm.FansMedalLevel < r.MinMedalLevel:
+		return fmt.Sprintf("需要粉丝牌等级达到%d级", r.MinMedalLevel)
+	case r.MinGuardLevel > 0 && (Dm.GuardLevel <= 0 || Dm.GuardLevel > r.MinGuardLevel):
+		return "需要开通" + GuardLevelName(r.MinGuardLevel) + "及以上"
+	}
+	return ""
+}
+
+// ServeLimitReason 重新排队限制的显示原因
+func ServeLimitReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRejoinCooldown):
+		return "重新排队冷却中"
+	case errors.Is(err, ErrServeQuota):
+		return "本场排队次数已用完"
+	}
+	return err.Error()
+}
+
+// parseJoinRule 解析命名队列配置中的粉丝牌等级和大航海等级，粉丝牌等级填0表示只要求佩戴
+func parseJoinRule(Name, Medal, Guard string) (JoinRule, error) {
+	var r JoinRule
+	if Medal != "" {
+		level, err := strconv.Atoi(Medal)
+		if err != nil || level < 0 {
+			return r, fmt.Errorf("队列 %s 的粉丝牌等级无效：%s", Name, Medal)
+		}
+		r.RequireMedal = true
+		r.MinMedalLevel = level
+	}
+	if Guard != "" {
+		level, err := strconv.Atoi(Guard)
+		if err != nil || level < 0 || level > 3 {
+			return r, fmt.Errorf("队列 %s 的大航海等级无效：%s，应为1总督 2提督 3舰长", Name, Guard)
+		}
+		r.MinGuardLevel = level
+	}
+	return r, nil
+}
+
+// formatJoinRule parseJoinRule 的逆过程
+func formatJoinRule(r JoinRule) (Medal, Guard string) {
+	if r.RequireMedal || r.MinMedalLevel > 0 {
+		Medal = strconv.Itoa(r.MinMedalLevel)
+	}
+	if r.MinGuardLevel > 0 {
+		Guard = strconv.Itoa(r.MinGuardLevel)
+	}
+	return Medal, Guard
+}
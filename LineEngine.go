@@ -0,0 +1,843 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+var (
+	ErrEmptyOpenID   = errors.New("empty OpenID provided")
+	ErrUserInLine    = errors.New("user already in line")
+	ErrUserNotInLine = errors.New("user not in line")
+	ErrLineFull      = errors.New("line is full")
+	ErrLineEmpty     = errors.New("no users in line")
+	ErrInvalidLine   = errors.New("invalid line type")
+)
+
+// LineEntry 队列中的一个用户及其所在层级和下标(从0开始)
+type LineEntry struct {
+	LineType int
+	Index    int
+	Line     Line
+}
+
+// OpenID 用户唯一标识
+func (le LineEntry) OpenID() string {
+	return le.Line.OpenID
+}
+
+// lineIndex 用户所在层级及下标(从0开始)
+type lineIndex struct {
+	Tier  int
+	Index int
+}
+
+// LineEngine 队列引擎，LineRow 的唯一持有者
+// 弹幕、礼物、控制界面、Web服务对队列的所有修改都必须通过这里的方法完成
+// 每次修改都会生成一条 LineEvent，先应用到内存再写入队列日志
+type LineEngine struct {
+	mu      sync.RWMutex
+	queue   string
+	row     LineRow
+	index   map[string]lineIndex
+	journal *LineJournal
+}
+
+// NewLineEngine 使用已有队列数据创建引擎，索引会根据队列内容重建，journal 为空时不记录日志
+// Queue 为队列标识，用于区分推送到前端的消息
+func NewLineEngine(Queue string, row LineRow, journal *LineJournal) *LineEngine {
+	e := &LineEngine{queue: Queue, row: row, journal: journal}
+	e.reindex()
+	return e
+}
+
+// reindex 根据队列内容重建索引
+func (e *LineEngine) reindex() {
+	e.index = make(map[string]lineIndex)
+	for t, tier := range e.row.Tiers {
+		for i, l := range tier.Users {
+			e.index[l.OpenID] = lineIndex{Tier: t, Index: i}
+		}
+	}
+}
+
+// validTier 层级下标是否有效，调用方需持有锁
+func (e *LineEngine) validTier(LineType int) bool {
+	return LineType >= 0 && LineType < len(e.row.Tiers)
+}
+
+// find 查找用户所在层级及下标(从0开始)，调用方需持有锁
+func (e *LineEngine) find(OpenID string) (LineType int, Index int, ok bool) {
+	idx, exists := e.index[OpenID]
+	if !exists || !e.validTier(idx.Tier) || idx.Index >= len(e.row.Tiers[idx.Tier].Users) {
+		return 0, 0, false
+	}
+	return idx.Tier, idx.Index, true
+}
+
+// lineLen 获取指定层级人数，调用方需持有锁
+func (e *LineEngine) lineLen(LineType int) int {
+	if !e.validTier(LineType) {
+		return 0
+	}
+	return len(e.row.Tiers[LineType].Users)
+}
+
+// Close 写入快照并关闭队列日志，之后的修改直接写入队列文件，退出程序时调用
+func (e *LineEngine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.journal == nil {
+		return
+	}
+	if err := e.journal.Close(e.row); err != nil {
+		slog.Error("队列日志关闭失败", err, slog.String("Queue", e.queue))
+	}
+	e.journal = nil
+}
+
+// commit 应用事件并写入队列日志，调用方需持有写锁
+func (e *LineEngine) commit(ev LineEvent) {
+	ev.Time = time.Now().UnixMilli()
+	e.apply(ev)
+	if e.journal == nil {
+		_, _, lineFile := queueFiles(e.queue)
+		SetLine(lineFile, e.row)
+		return
+	}
+	if err := e.journal.Append(ev, e.row); err != nil {
+		slog.Error("队列日志写入失败", err, slog.String("Op", ev.Op))
+	}
+}
+
+// apply 将事件应用到内存队列，回放日志时同样使用此方法，因此对无效事件只做忽略处理
+// 这里不读取配置，层级的选择在生成事件时完成
+func (e *LineEngine) apply(ev LineEvent) {
+	switch ev.Op {
+	case EventJoin:
+		if ev.Line == nil || !e.validTier(ev.LineType) {
+			return
+		}
+		if _, _, ok := e.find(ev.OpenID); ok {
+			return
+		}
+		e.place(ev.LineType, *ev.Line)
+
+	case EventGift:
+		// 旧版日志中的礼物事件，累计价值后放入礼物层级
+		User := ev.Line
+		if User == nil {
+			User = ev.GiftLine
+		}
+		if User == nil || !e.validTier(ev.LineType) {
+			return
+		}
+		merged := *User
+		if LineType, idx, ok := e.find(ev.OpenID); ok {
+			if LineType == ev.LineType {
+				merged = e.row.Tiers[LineType].Users[idx]
+				merged.GiftPrice += User.GiftPrice
+				merged.GiftName = User.GiftName
+			}
+			e.removeAt(LineType, idx)
+		}
+		e.place(ev.LineType, merged)
+
+	case EventPlace:
+		if ev.Line == nil || !e.validTier(ev.LineType) {
+			return
+		}
+		if LineType, idx, ok := e.find(ev.OpenID); ok {
+			e.removeAt(LineType, idx)
+		}
+		e.place(ev.LineType, *ev.Line)
+
+	case EventInsert:
+		if _, _, ok := e.find(ev.OpenID); ok {
+			return
+		}
+		e.insertAt(ev)
+
+	case EventTransfer:
+		LineType, idx, ok := e.find(ev.OpenID)
+		if !ok || !e.validTier(ev.LineType) {
+			return
+		}
+		e.removeAt(LineType, idx)
+		e.insertAt(ev)
+
+	case EventRemove:
+		if LineType, idx, ok := e.find(ev.OpenID); ok {
+			e.removeAt(LineType, idx)
+		}
+
+	case EventOnline:
+		if LineType, idx, ok := e.find(ev.OpenID); ok {
+			User := &e.row.Tiers[LineType].Users[idx]
+			User.IsOnline = ev.IsOnline
+			User.Idle = !ev.IsOnline && ev.Idle
+			// 离场时间取事件时间，回放日志时结果不变；无互动标记的不在不计入暂离
+			User.AwaySince = 0
+			if !ev.IsOnline && !ev.Idle {
+				User.AwaySince = ev.Time / 1000
+			}
+		}
+
+	case EventNote:
+		if LineType, idx, ok := e.find(ev.OpenID); ok {
+			e.row.Tiers[LineType].Users[idx].Note = ev.Note
+		}
+
+	case EventMove:
+		LineType, from, ok := e.find(ev.OpenID)
+		if !ok || ev.Index < 0 || ev.Index >= e.lineLen(LineType) {
+			return
+		}
+		e.row.Tiers[LineType].Users = moveElement(e.row.Tiers[LineType].Users, from, ev.Index)
+		e.reindex()
+
+	case EventClear:
+		for i := range e.row.Tiers {
+			e.row.Tiers[i].Users = []Line{}
+		}
+		e.reindex()
+
+	case EventReset:
+		if ev.Row == nil {
+			return
+		}
+		e.row = ev.Row.Clone()
+		e.reindex()
+	}
+}
+
+// place 按层级的排序方式插入用户，调用方需持有锁
+func (e *LineEngine) place(LineType int, User Line) {
+	users := e.row.Tiers[LineType].Users
+	idx := len(users)
+	switch e.row.Tiers[LineType].Sort {
+	case TierSortGift:
+		for idx > 0 && users[idx-1].GiftPrice < User.GiftPrice {
+			idx--
+		}
+	case TierSortGuard:
+		for idx > 0 && guardRank(users[idx-1].GuardLevel) > guardRank(User.GuardLevel) {
+			idx--
+		}
+	}
+	e.row.Tiers[LineType].Users = insertElement(users, idx, User)
+	e.reindex()
+}
+
+// insertAt 按事件中的层级和下标插入用户，下标越界时放到队首或队尾，调用方需持有锁
+func (e *LineEngine) insertAt(ev LineEvent) {
+	if ev.Line == nil || !e.validTier(ev.LineType) {
+		return
+	}
+	idx := ev.Index
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > e.lineLen(ev.LineType) {
+		idx = e.lineLen(ev.LineType)
+	}
+	e.row.Tiers[ev.LineType].Users = insertElement(e.row.Tiers[ev.LineType].Users, idx, *ev.Line)
+	e.reindex()
+}
+
+// removeAt 删除指定层级的指定下标，调用方需持有锁
+func (e *LineEngine) removeAt(LineType, Index int) {
+	users := e.row.Tiers[LineType].Users
+	e.row.Tiers[LineType].Users = append(users[:Index], users[Index+1:]...)
+	e.reindex()
+}
+
+// Snapshot 获取队列的完整副本，可在锁外安全读取
+func (e *LineEngine) Snapshot() LineRow {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.row.Clone()
+}
+
+// Len 队列总人数
+func (e *LineEngine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return len(e.index)
+}
+
+// TierNames 各层级名称，按优先级从高到低排列
+func (e *LineEngine) TierNames() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	names := make([]string, len(e.row.Tiers))
+	for i, t := range e.row.Tiers {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// TierName 层级显示名称
+func (e *LineEngine) TierName(LineType int) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.validTier(LineType) {
+		return "未知队列"
+	}
+	return e.row.Tiers[LineType].Name
+}
+
+// TierCount 层级数量
+func (e *LineEngine) TierCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return len(e.row.Tiers)
+}
+
+// Contains 用户是否在任意层级中
+func (e *LineEngine) Contains(OpenID string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	_, _, ok := e.find(OpenID)
+	return ok
+}
+
+// Find 查找用户所在层级及下标(从0开始)
+func (e *LineEngine) Find(OpenID string) (LineType int, Index int, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.find(OpenID)
+}
+
+// entry 获取用户当前的队列信息，调用方需持有锁
+func (e *LineEngine) entry(OpenID string) (LineEntry, bool) {
+	LineType, idx, ok := e.find(OpenID)
+	if !ok {
+		return LineEntry{}, false
+	}
+	return LineEntry{LineType: LineType, Index: idx, Line: e.row.Tiers[LineType].Users[idx]}, true
+}
+
+// Entry 获取用户当前的队列信息
+func (e *LineEngine) Entry(OpenID string) (LineEntry, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.entry(OpenID)
+}
+
+// LineLen 指定层级的人数
+func (e *LineEngine) LineLen(LineType int) int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.lineLen(LineType)
+}
+
+// Join 将用户加入指定层级，MaxCount 小于等于0时不限制容量
+func (e *LineEngine) Join(LineType int, User Line, MaxCount int) error {
+	if User.OpenID == "" {
+		return ErrEmptyOpenID
+	}
+
+	e.mu.Lock()
+	if !e.validTier(LineType) {
+		e.mu.Unlock()
+		return ErrInvalidLine
+	}
+	if _, _, ok := e.find(User.OpenID); ok {
+		e.mu.Unlock()
+		return ErrUserInLine
+	}
+	if MaxCount > 0 && e.lineLen(LineType) >= MaxCount {
+		e.mu.Unlock()
+		return ErrLineFull
+	}
+	e.commit(LineEvent{Op: EventJoin, LineType: LineType, OpenID: User.OpenID, Line: &User})
+	joined, _ := e.entry(User.OpenID)
+	e.mu.Unlock()
+
+	SendLineToWs(e.queue, joined.LineType, joined.Index, joined.Line)
+	return nil
+}
+
+// AddGift 累计用户礼物价值，并按累计后的信息重新选择层级，不在队列中的用户以本次价值加入
+func (e *LineEngine) AddGift(User Line) (Line, error) {
+	return e.upsert(User.OpenID, false, func(old Line, ok bool) Line {
+		if !ok {
+			return User
+		}
+		old.GiftPrice += User.GiftPrice
+		old.GiftName = User.GiftName
+		return old
+	})
+}
+
+// Update 修改队列中用户的信息，并按修改后的信息重新选择层级
+func (e *LineEngine) Update(OpenID string, fn func(*Line)) (Line, error) {
+	return e.upsert(OpenID, true, func(old Line, _ bool) Line {
+		fn(&old)
+		return old
+	})
+}
+
+// upsert 按 merge 的结果加入或更新用户，层级由 PickTier 决定，颜色使用层级配置
+// mustExist 为 true 时只更新队列中的用户，检查与更新在同一次加锁中完成
+func (e *LineEngine) upsert(OpenID string, mustExist bool, merge func(old Line, ok bool) Line) (Line, error) {
+	if OpenID == "" {
+		return Line{}, ErrEmptyOpenID
+	}
+
+	e.mu.Lock()
+	if len(e.row.Tiers) == 0 {
+		e.mu.Unlock()
+		return Line{}, ErrNoLineTier
+	}
+	old, ok := e.entry(OpenID)
+	if mustExist && !ok {
+		e.mu.Unlock()
+		return Line{}, ErrUserNotInLine
+	}
+	User := merge(old.Line, ok)
+	LineType := PickTier(User)
+	if !e.validTier(LineType) {
+		LineType = len(e.row.Tiers) - 1
+	}
+	User.PrintColor = TierColor(LineType)
+	e.commit(LineEvent{Op: EventPlace, LineType: LineType, OpenID: OpenID, Line: &User})
+	placed, _ := e.entry(OpenID)
+	e.mu.Unlock()
+
+	if ok {
+		SendDelToWs(e.queue, old.LineType, old.Index, OpenID)
+	}
+	SendLineToWs(e.queue, placed.LineType, placed.Index, placed.Line)
+	return placed.Line, nil
+}
+
+// Remove 将用户从所在层级中移除
+func (e *LineEngine) Remove(OpenID string) error {
+	_, err := e.Take(OpenID)
+	return err
+}
+
+// Take 将用户从所在层级中移除，并返回移除前的队列信息
+func (e *LineEngine) Take(OpenID string) (LineEntry, error) {
+	if OpenID == "" {
+		return LineEntry{}, ErrEmptyOpenID
+	}
+
+	e.mu.Lock()
+	le, ok := e.entry(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		slog.Warn("未找到用户或无效索引", slog.String("OpenID", OpenID))
+		return LineEntry{}, ErrUserNotInLine
+	}
+	e.commit(LineEvent{Op: EventRemove, LineType: le.LineType, Index: le.Index, OpenID: OpenID})
+	e.mu.Unlock()
+
+	SendDelToWs(e.queue, le.LineType, le.Index, OpenID)
+	return le, nil
+}
+
+// Insert 将用户插入到指定层级的指定下标，用于撤销删除等需要还原位置的操作
+func (e *LineEngine) Insert(le LineEntry) error {
+	OpenID := le.OpenID()
+	if OpenID == "" {
+		return ErrEmptyOpenID
+	}
+
+	e.mu.Lock()
+	if !e.validTier(le.LineType) {
+		e.mu.Unlock()
+		return ErrInvalidLine
+	}
+	if _, _, ok := e.find(OpenID); ok {
+		e.mu.Unlock()
+		return ErrUserInLine
+	}
+	e.commit(LineEvent{Op: EventInsert, LineType: le.LineType, Index: le.Index, OpenID: OpenID, Line: &le.Line})
+	inserted, _ := e.entry(OpenID)
+	e.mu.Unlock()
+
+	SendLineToWs(e.queue, inserted.LineType, inserted.Index, inserted.Line)
+	return nil
+}
+
+// First 队首用户，按层级优先级从高到低查找
+func (e *LineEngine) First() (LineEntry, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.first()
+}
+
+// first 同 First，调用方需持有锁
+func (e *LineEngine) first() (LineEntry, bool) {
+	for LineType, t := range e.row.Tiers {
+		if len(t.Users) > 0 {
+			return LineEntry{LineType: LineType, Index: 0, Line: t.Users[0]}, true
+		}
+	}
+	return LineEntry{}, false
+}
+
+// Next 移除队首用户，按层级优先级从高到低查找，返回被移除用户的队列信息
+// 查找和移除在同一次加锁中完成，期间其他用户被移除时移除的是新的队首
+func (e *LineEngine) Next() (LineEntry, error) {
+	e.mu.Lock()
+	first, ok := e.first()
+	if !ok {
+		e.mu.Unlock()
+		return LineEntry{}, ErrLineEmpty
+	}
+	OpenID := first.OpenID()
+	e.commit(LineEvent{Op: EventRemove, LineType: first.LineType, Index: first.Index, OpenID: OpenID})
+	e.mu.Unlock()
+
+	SendDelToWs(e.queue, first.LineType, first.Index, OpenID)
+	return first, nil
+}
+
+// Move 在用户所在层级内移动到指定下标(从0开始)，越界时移动到队首或队尾，返回移动前的下标
+func (e *LineEngine) Move(OpenID string, ToIndex int) (int, error) {
+	e.mu.Lock()
+	LineType, from, ok := e.find(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return 0, ErrUserNotInLine
+	}
+	ToIndex, moved := e.move(OpenID, LineType, from, ToIndex)
+	e.mu.Unlock()
+
+	if moved {
+		SendMoveToWs(e.queue, LineType, ToIndex, OpenID)
+	}
+	return from, nil
+}
+
+// move 在层级内将用户从 from 移动到 ToIndex，越界时移动到队首或队尾，返回实际的目标下标和是否移动，调用方需持有写锁
+func (e *LineEngine) move(OpenID string, LineType, from, ToIndex int) (int, bool) {
+	if ToIndex < 0 {
+		ToIndex = 0
+	}
+	if last := e.lineLen(LineType) - 1; ToIndex > last {
+		ToIndex = last
+	}
+	if from == ToIndex {
+		return ToIndex, false
+	}
+	e.commit(LineEvent{Op: EventMove, LineType: LineType, Index: ToIndex, OpenID: OpenID})
+	return ToIndex, true
+}
+
+// Transfer 将用户移动到另一层级的指定下标(从0开始)，越界时放到队首或队尾，返回移动前的队列信息
+// 礼物价值、大航海等级等信息保留，颜色使用目标层级的配置
+func (e *LineEngine) Transfer(OpenID string, ToLineType, ToIndex int) (LineEntry, error) {
+	e.mu.Lock()
+	if !e.validTier(ToLineType) {
+		e.mu.Unlock()
+		return LineEntry{}, ErrInvalidLine
+	}
+	old, ok := e.entry(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return LineEntry{}, ErrUserNotInLine
+	}
+	if old.LineType == ToLineType {
+		ToIndex, moved := e.move(OpenID, old.LineType, old.Index, ToIndex)
+		e.mu.Unlock()
+
+		if moved {
+			SendMoveToWs(e.queue, old.LineType, ToIndex, OpenID)
+		}
+		return old, nil
+	}
+	User := old.Line
+	User.PrintColor = TierColor(ToLineType)
+	e.commit(LineEvent{Op: EventTransfer, LineType: ToLineType, Index: ToIndex, OpenID: OpenID, Line: &User})
+	moved, _ := e.entry(OpenID)
+	e.mu.Unlock()
+
+	SendDelToWs(e.queue, old.LineType, old.Index, OpenID)
+	SendLineToWs(e.queue, moved.LineType, moved.Index, moved.Line)
+	return old, nil
+}
+
+// Skip 将用户在整个队列中后移 By 位，按层级顺序计算，越过所在层级末尾时移到后面的层级
+// 后面没有其他用户时不移动并返回 false
+func (e *LineEngine) Skip(OpenID string, By int) (bool, error) {
+	e.mu.Lock()
+	old, ok := e.entry(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return false, ErrUserNotInLine
+	}
+	// 去掉该用户后的队列顺序，用户将排在第 pos+By 位用户之后
+	var rest []LineEntry
+	pos := 0
+	for t, tier := range e.row.Tiers {
+		for i, User := range tier.Users {
+			if User.OpenID == OpenID {
+				pos = len(rest)
+				continue
+			}
+			rest = append(rest, LineEntry{LineType: t, Index: i, Line: User})
+		}
+	}
+	if By <= 0 || pos >= len(rest) {
+		e.mu.Unlock()
+		return false, nil
+	}
+	after := rest[min(pos+By, len(rest))-1]
+	if after.LineType == old.LineType {
+		// 同一层级内，移除用户后前面的用户下标减一
+		ToIndex := after.Index
+		if old.Index > after.Index {
+			ToIndex++
+		}
+		e.commit(LineEvent{Op: EventMove, LineType: old.LineType, Index: ToIndex, OpenID: OpenID})
+		e.mu.Unlock()
+
+		SendMoveToWs(e.queue, old.LineType, ToIndex, OpenID)
+		return true, nil
+	}
+	User := old.Line
+	User.PrintColor = TierColor(after.LineType)
+	e.commit(LineEvent{Op: EventTransfer, LineType: after.LineType, Index: after.Index + 1, OpenID: OpenID, Line: &User})
+	moved, _ := e.entry(OpenID)
+	e.mu.Unlock()
+
+	SendDelToWs(e.queue, old.LineType, old.Index, OpenID)
+	SendLineToWs(e.queue, moved.LineType, moved.Index, moved.Line)
+	return true, nil
+}
+
+// SetOnline 设置用户在场状态
+func (e *LineEngine) SetOnline(OpenID string, IsOnline bool) error {
+	_, err := e.updateOnline(OpenID, func(bool) bool { return IsOnline })
+	return err
+}
+
+// ToggleOnline 切换用户在场状态，返回切换后的状态
+func (e *LineEngine) ToggleOnline(OpenID string) (bool, error) {
+	return e.updateOnline(OpenID, func(old bool) bool { return !old })
+}
+
+// updateOnline 在同一把锁内读取并更新用户在场状态
+func (e *LineEngine) updateOnline(OpenID string, next func(bool) bool) (bool, error) {
+	e.mu.Lock()
+	LineType, idx, ok := e.find(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return false, ErrUserNotInLine
+	}
+	IsOnline := next(e.row.Tiers[LineType].Users[idx].IsOnline)
+	e.commit(LineEvent{Op: EventOnline, LineType: LineType, Index: idx, OpenID: OpenID, IsOnline: IsOnline})
+	User := e.row.Tiers[LineType].Users[idx]
+	e.mu.Unlock()
+
+	SendStatusToWs(e.queue, LineType, idx, User)
+	return IsOnline, nil
+}
+
+// SetIdle 将在场的用户标记为无互动，显示为不在但不会因暂离超时被移出，已不在的用户不变，返回是否标记
+func (e *LineEngine) SetIdle(OpenID string) (bool, error) {
+	e.mu.Lock()
+	LineType, idx, ok := e.find(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return false, ErrUserNotInLine
+	}
+	if !e.row.Tiers[LineType].Users[idx].IsOnline {
+		e.mu.Unlock()
+		return false, nil
+	}
+	e.commit(LineEvent{Op: EventOnline, LineType: LineType, Index: idx, OpenID: OpenID, IsOnline: false, Idle: true})
+	User := e.row.Tiers[LineType].Users[idx]
+	e.mu.Unlock()
+
+	SendStatusToWs(e.queue, LineType, idx, User)
+	return true, nil
+}
+
+// SetNote 修改用户备注，不改变用户位置
+func (e *LineEngine) SetNote(OpenID, Note string) error {
+	e.mu.Lock()
+	LineType, idx, ok := e.find(OpenID)
+	if !ok {
+		e.mu.Unlock()
+		return ErrUserNotInLine
+	}
+	e.commit(LineEvent{Op: EventNote, LineType: LineType, Index: idx, OpenID: OpenID, Note: Note})
+	updated := e.row.Tiers[LineType].Users[idx]
+	e.mu.Unlock()
+
+	SendLineToWs(e.queue, LineType, idx, updated)
+	return nil
+}
+
+// Clear 清空全部层级，返回清空前的队列
+func (e *LineEngine) Clear() LineRow {
+	e.mu.Lock()
+	removed := e.row.Clone()
+	e.commit(LineEvent{Op: EventClear})
+	e.mu.Unlock()
+
+	// 从队尾开始通知前端，保证下标有效
+	for t := len(removed.Tiers) - 1; t >= 0; t-- {
+		users := removed.Tiers[t].Users
+		for i := len(users) - 1; i >= 0; i-- {
+			SendDelToWs(e.queue, t, i, users[i].OpenID)
+		}
+	}
+	return removed
+}
+
+// SyncTiers 按层级配置调整队列结构，同名层级保留原有用户和顺序，其余用户按准入条件重新分配
+// 层级结构没有变化时不做任何修改
+func (e *LineEngine) SyncTiers(tiers []LineTier) {
+	e.mu.Lock()
+	if sameTiers(e.row, tiers) {
+		e.mu.Unlock()
+		return
+	}
+	row := layoutTiers(e.row, tiers)
+	e.commit(LineEvent{Op: EventReset, Row: &row})
+	e.mu.Unlock()
+
+	slog.Info("队列层级已更新", slog.String("Queue", e.queue), slog.Int("Tiers", len(tiers)))
+	SendReloadToWs(e.queue)
+}
+
+// RestoreAt 将队列恢复到指定时间点的状态，恢复本身也会作为一条事件写入日志
+func (e *LineEngine) RestoreAt(At time.Time) error {
+	e.mu.Lock()
+	// 退出时 Close 会在锁内关闭队列日志
+	if e.journal == nil {
+		e.mu.Unlock()
+		return ErrNoJournal
+	}
+	row, err := e.journal.StateAt(At)
+	if err != nil {
+		e.mu.Unlock()
+		return err
+	}
+	// 历史队列的层级结构可能与当前配置不同
+	if tiers := ActiveLineTiers(); !sameTiers(row, tiers) {
+		row = layoutTiers(row, tiers)
+	}
+	e.commit(LineEvent{Op: EventReset, Row: &row})
+	e.mu.Unlock()
+
+	SendReloadToWs(e.queue)
+	return nil
+}
+
+// Reset 用给定的队列替换当前队列，用于从备份恢复，层级结构按当前配置调整
+func (e *LineEngine) Reset(row LineRow) {
+	if tiers := ActiveLineTiers(); !sameTiers(row, tiers) {
+		row = layoutTiers(row, tiers)
+	}
+	e.mu.Lock()
+	e.commit(LineEvent{Op: EventReset, Row: &row})
+	e.mu.Unlock()
+
+	SendReloadToWs(e.queue)
+}
+
+// LastClearTime 最近一次清空队列的时间
+func (e *LineEngine) LastClearTime() (time.Time, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.journal == nil {
+		return time.Time{}, false
+	}
+	return e.journal.LastEventTime(EventClear)
+}
+
+// sameTiers 队列的层级名称和排序方式是否与配置一致
+func sameTiers(row LineRow, tiers []LineTier) bool {
+	if len(row.Tiers) != len(tiers) {
+		return false
+	}
+	for i, t := range tiers {
+		if row.Tiers[i].Name != t.Name || row.Tiers[i].Sort != t.Sort {
+			return false
+		}
+	}
+	return true
+}
+
+// layoutTiers 按层级配置重新排布队列，同名层级整体保留，其余用户按准入条件放入新层级
+func layoutTiers(row LineRow, tiers []LineTier) LineRow {
+	placer := &LineEngine{row: LineRow{Tiers: make([]TierLine, len(tiers))}}
+	for i, t := range tiers {
+		placer.row.Tiers[i] = TierLine{Name: t.Name, Sort: t.Sort, Users: []Line{}}
+	}
+	used := make([]bool, len(row.Tiers))
+	for i := range placer.row.Tiers {
+		for j, old := range row.Tiers {
+			if !used[j] && old.Name == placer.row.Tiers[i].Name {
+				placer.row.Tiers[i].Users = append(placer.row.Tiers[i].Users, old.Users...)
+				used[j] = true
+				break
+			}
+		}
+	}
+	placer.reindex()
+	if len(tiers) == 0 {
+		return placer.row
+	}
+	for j, old := range row.Tiers {
+		if used[j] {
+			continue
+		}
+		for _, User := range old.Users {
+			if _, _, ok := placer.find(User.OpenID); ok {
+				continue
+			}
+			LineType := pickTier(tiers, User)
+			User.PrintColor = tiers[LineType].Color
+			placer.place(LineType, User)
+		}
+	}
+	return placer.row
+}
+
+// guardRank 大航海排序权重，总督最前，手动添加的特殊用户排在大航海之后
+func guardRank(GuardLevel int) int {
+	if GuardLevel <= 0 {
+		return 4
+	}
+	return GuardLevel
+}
+
+// insertElement 在切片的 index 位置插入元素
+func insertElement[T any](s []T, index int, item T) []T {
+	s = append(s, item)
+	copy(s[index+1:], s[index:])
+	s[index] = item
+	return s
+}
+
+// moveElement 将切片中 from 位置的元素移动到 to 位置
+func moveElement[T any](s []T, from, to int) []T {
+	item := s[from]
+	s = append(s[:from], s[from+1:]...)
+	s = append(s[:to], append([]T{item}, s[to:]...)...)
+	return s
+}
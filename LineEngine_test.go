@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// engineTestTiers 舰长层级按等级排序，礼物层级要求累计10电池并按价值排序，普通层级按加入顺序
+var engineTestTiers = []LineTier{
+	{Name: "舰长", Rule: TierRule{GuardLevel: 3}, Color: LineColor{R: 1}, Sort: TierSortGuard},
+	{Name: "礼物", Rule: TierRule{MinGiftPrice: 10}, Color: LineColor{G: 2}, Sort: TierSortGift},
+	{Name: "普通", Color: LineColor{B: 3}, Sort: TierSortJoin},
+}
+
+func newTestEngine(t *testing.T) *LineEngine {
+	t.Helper()
+	return useTestQueues(t, RunConfig{IdCode: "ABCDEF", LineTiers: engineTestTiers}).Engine
+}
+
+// checkEngine 检查索引与队列内容一致，且各层级按排序方式排列
+func checkEngine(t *testing.T, e *LineEngine) {
+	t.Helper()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	n := 0
+	for ti, tier := range e.row.Tiers {
+		for i, User := range tier.Users {
+			n++
+			if got, ok := e.index[User.OpenID]; !ok || got != (lineIndex{Tier: ti, Index: i}) {
+				t.Errorf("%s 的索引为 %+v，应为层级%d下标%d", User.OpenID, got, ti, i)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := tier.Users[i-1]
+			switch tier.Sort {
+			case TierSortGift:
+				if prev.GiftPrice < User.GiftPrice {
+					t.Errorf("礼物层级中 %s(%v) 排在 %s(%v) 之前", prev.OpenID, prev.GiftPrice, User.OpenID, User.GiftPrice)
+				}
+			case TierSortGuard:
+				if guardRank(prev.GuardLevel) > guardRank(User.GuardLevel) {
+					t.Errorf("舰长层级中 %s(%d) 排在 %s(%d) 之前", prev.OpenID, prev.GuardLevel, User.OpenID, User.GuardLevel)
+				}
+			}
+		}
+	}
+	if len(e.index) != n {
+		t.Errorf("索引有 %d 项，队列有 %d 位用户", len(e.index), n)
+	}
+}
+
+// tierIDs 各层级中用户的 OpenID
+func tierIDs(e *LineEngine) [][]string {
+	row := e.Snapshot()
+	res := make([][]string, len(row.Tiers))
+	for i, tier := range row.Tiers {
+		res[i] = []string{}
+		for _, User := range tier.Users {
+			res[i] = append(res[i], User.OpenID)
+		}
+	}
+	return res
+}
+
+func TestLineEngineJoin(t *testing.T) {
+	e := newTestEngine(t)
+	if err := e.Join(2, Line{OpenID: "a"}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lineType int
+		user     Line
+		max      int
+		wantErr  error
+	}{
+		{"空OpenID", 2, Line{}, 0, ErrEmptyOpenID},
+		{"层级不存在", 3, Line{OpenID: "b"}, 0, ErrInvalidLine},
+		{"负数层级", -1, Line{OpenID: "b"}, 0, ErrInvalidLine},
+		{"已在队列中", 0, Line{OpenID: "a"}, 0, ErrUserInLine},
+		{"加入", 2, Line{OpenID: "b"}, 2, nil},
+		{"层级已满", 2, Line{OpenID: "c"}, 2, ErrLineFull},
+		{"不限容量", 2, Line{OpenID: "c"}, 0, nil},
+	}
+	for _, tt := range tests {
+		if err := e.Join(tt.lineType, tt.user, tt.max); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s：错误 %v，应为 %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if got := fmt.Sprint(tierIDs(e)); got != "[[] [] [a b c]]" {
+		t.Errorf("队列为 %s", got)
+	}
+	checkEngine(t, e)
+}
+
+func TestLineEngineSort(t *testing.T) {
+	e := newTestEngine(t)
+	joins := []struct {
+		lineType int
+		user     Line
+	}{
+		{0, Line{OpenID: "captain", GuardLevel: 3}},
+		{0, Line{OpenID: "governor", GuardLevel: 1}},
+		{0, Line{OpenID: "admiral", GuardLevel: 2}},
+		{0, Line{OpenID: "captain2", GuardLevel: 3}},
+		{0, Line{OpenID: "special"}},
+		{1, Line{OpenID: "g10", GiftPrice: 10}},
+		{1, Line{OpenID: "g30", GiftPrice: 30}},
+		{1, Line{OpenID: "g10b", GiftPrice: 10}},
+		{1, Line{OpenID: "g20", GiftPrice: 20}},
+		{2, Line{OpenID: "c1", GiftPrice: 5}},
+		{2, Line{OpenID: "c2", GuardLevel: 1}},
+	}
+	for _, j := range joins {
+		if err := e.Join(j.lineType, j.user, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "[[governor admiral captain captain2 special] [g30 g20 g10 g10b] [c1 c2]]"
+	if got := fmt.Sprint(tierIDs(e)); got != want {
+		t.Errorf("队列为\n%s\n应为\n%s", got, want)
+	}
+	checkEngine(t, e)
+}
+
+func TestLineEngineUpsert(t *testing.T) {
+	e := newTestEngine(t)
+	steps := []struct {
+		name    string
+		run     func() (Line, error)
+		wantErr error
+		want    string
+		color   LineColor
+	}{
+		{
+			name:  "礼物价值不足时加入普通层级",
+			run:   func() (Line, error) { return e.AddGift(Line{OpenID: "a", GiftPrice: 4}) },
+			want:  "[[] [] [a]]",
+			color: engineTestTiers[2].Color,
+		},
+		{
+			name:  "累计达到门槛后移到礼物层级",
+			run:   func() (Line, error) { return e.AddGift(Line{OpenID: "a", GiftPrice: 6, GiftName: "小花花"}) },
+			want:  "[[] [a] []]",
+			color: engineTestTiers[1].Color,
+		},
+		{
+			name:  "新用户直接进入礼物层级并按价值排序",
+			run:   func() (Line, error) { return e.AddGift(Line{OpenID: "b", GiftPrice: 50}) },
+			want:  "[[] [b a] []]",
+			color: engineTestTiers[1].Color,
+		},
+		{
+			name:  "修改后重新选择层级",
+			run:   func() (Line, error) { return e.Update("a", func(l *Line) { l.GuardLevel = 3 }) },
+			want:  "[[a] [b] []]",
+			color: engineTestTiers[0].Color,
+		},
+		{
+			name:    "修改不在队列中的用户",
+			run:     func() (Line, error) { return e.Update("missing", func(l *Line) { l.GiftPrice = 100 }) },
+			wantErr: ErrUserNotInLine,
+			want:    "[[a] [b] []]",
+		},
+		{
+			name:    "空OpenID",
+			run:     func() (Line, error) { return e.AddGift(Line{GiftPrice: 100}) },
+			wantErr: ErrEmptyOpenID,
+			want:    "[[a] [b] []]",
+		},
+	}
+	for _, s := range steps {
+		User, err := s.run()
+		if !errors.Is(err, s.wantErr) {
+			t.Fatalf("%s：错误 %v，应为 %v", s.name, err, s.wantErr)
+		}
+		if got := fmt.Sprint(tierIDs(e)); got != s.want {
+			t.Errorf("%s：队列为 %s，应为 %s", s.name, got, s.want)
+		}
+		if err == nil && User.PrintColor != s.color {
+			t.Errorf("%s：颜色 %+v，应为 %+v", s.name, User.PrintColor, s.color)
+		}
+		checkEngine(t, e)
+	}
+	if le, _ := e.Entry("a"); le.Line.GiftPrice != 10 || le.Line.GiftName != "小花花" {
+		t.Errorf("累计后礼物价值 %v %q，应为 10 小花花", le.Line.GiftPrice, le.Line.GiftName)
+	}
+}
+
+func TestLineEngineMoveTransfer(t *testing.T) {
+	e := newTestEngine(t)
+	for _, OpenID := range []string{"a", "b", "c"} {
+		if err := e.Join(2, Line{OpenID: OpenID, GiftPrice: 3}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Join(1, Line{OpenID: "g", GiftPrice: 20}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		run     func() error
+		wantErr error
+		want    string
+	}{
+		{"层级内移到队首", func() error { _, err := e.Move("c", 0); return err }, nil, "[[] [g] [c a b]]"},
+		{"越界移到队尾", func() error { _, err := e.Move("c", 10); return err }, nil, "[[] [g] [a b c]]"},
+		{"负数下标移到队首", func() error { _, err := e.Move("b", -3); return err }, nil, "[[] [g] [b a c]]"},
+		{"移动不在队列中的用户", func() error { _, err := e.Move("x", 0); return err }, ErrUserNotInLine, "[[] [g] [b a c]]"},
+		{"转移到其他层级", func() error { _, err := e.Transfer("a", 1, 0); return err }, nil, "[[] [a g] [b c]]"},
+		{"转移到同一层级时移动", func() error { _, err := e.Transfer("a", 1, 5); return err }, nil, "[[] [g a] [b c]]"},
+		{"转移到不存在的层级", func() error { _, err := e.Transfer("a", 3, 0); return err }, ErrInvalidLine, "[[] [g a] [b c]]"},
+		{"转移不在队列中的用户", func() error { _, err := e.Transfer("x", 0, 0); return err }, ErrUserNotInLine, "[[] [g a] [b c]]"},
+	}
+	for _, s := range steps {
+		if err := s.run(); !errors.Is(err, s.wantErr) {
+			t.Fatalf("%s：错误 %v，应为 %v", s.name, err, s.wantErr)
+		}
+		if got := fmt.Sprint(tierIDs(e)); got != s.want {
+			t.Errorf("%s：队列为 %s，应为 %s", s.name, got, s.want)
+		}
+	}
+	// 转移后使用目标层级的颜色，保留礼物价值
+	if le, _ := e.Entry("a"); le.Line.PrintColor != engineTestTiers[1].Color || le.Line.GiftPrice != 3 {
+		t.Errorf("转移后用户信息 %+v，颜色应为 %+v，礼物价值应为 3", le.Line, engineTestTiers[1].Color)
+	}
+	checkEngine(t, e)
+}
+
+// TestLineEngineNextConcurrent 叫号与移除同时进行时，叫号总是移除当时的队首，不会因队首被移除而失败
+func TestLineEngineNextConcurrent(t *testing.T) {
+	e := newTestEngine(t)
+	const n = 200
+	for i := 0; i < n; i++ {
+		if err := e.Join(2, Line{OpenID: fmt.Sprintf("u%03d", i)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		removed = make(map[string]int)
+	)
+	record := func(OpenID string) {
+		mu.Lock()
+		removed[OpenID]++
+		mu.Unlock()
+	}
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				le, err := e.Next()
+				if errors.Is(err, ErrLineEmpty) {
+					return
+				}
+				if err != nil {
+					t.Errorf("叫号失败：%v", err)
+					return
+				}
+				record(le.OpenID())
+			}
+		}()
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += 4 {
+				OpenID := fmt.Sprintf("u%03d", i)
+				if err := e.Remove(OpenID); err == nil {
+					record(OpenID)
+				} else if !errors.Is(err, ErrUserNotInLine) {
+					t.Errorf("移除失败：%v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if len(removed) != n || e.Len() != 0 {
+		t.Errorf("移除 %d 位用户，队列剩余 %d 位，应全部移除", len(removed), e.Len())
+	}
+	for OpenID, count := range removed {
+		if count != 1 {
+			t.Errorf("%s 被移除 %d 次", OpenID, count)
+		}
+	}
+	checkEngine(t, e)
+}
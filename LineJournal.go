@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// LineJournalFile 队列变更日志，每行一条 LineEvent
+	LineJournalFile = "line.journal"
+	// LineSnapshotDir 队列快照目录
+	LineSnapshotDir = "LineSnapshot"
+
+	// 每写入多少条事件或间隔多久生成一次快照
+	snapshotEveryEvents = 100
+	snapshotInterval    = 5 * time.Minute
+	// 保留的快照数量，更早的日志会在压缩时丢弃
+	maxSnapshots = 48
+)
+
+// 队列事件类型
+const (
+	EventJoin     = "join"
+	EventGift     = "gift" // 旧版礼物事件，仅用于回放旧日志
+	EventPlace    = "place"
+	EventInsert   = "insert"
+	EventRemove   = "remove"
+	EventOnline   = "online"
+	EventNote     = "note"
+	EventMove     = "move"
+	EventTransfer = "transfer"
+	EventClear    = "clear"
+	EventReset    = "reset"
+)
+
+var (
+	ErrNoJournal = errors.New("line journal disabled")
+	ErrNoHistory = errors.New("no line history at the given time")
+)
+
+// LineEvent 队列变更事件
+type LineEvent struct {
+	Seq      uint64
+	Time     int64 // 毫秒时间戳
+	Op       string
+	LineType int      `json:",omitempty"`
+	Index    int      `json:",omitempty"`
+	OpenID   string   `json:",omitempty"`
+	IsOnline bool     `json:",omitempty"`
+	Idle     bool     `json:",omitempty"` // 因无互动标记为不在，不记录离场时间
+	Note     string   `json:",omitempty"`
+	Line     *Line    `json:",omitempty"`
+	GiftLine *Line    `json:",omitempty"` // 旧版礼物事件的用户信息
+	Row      *LineRow `json:",omitempty"`
+}
+
+// LineSnapshot 队列快照，Seq 为快照包含的最后一条事件序号
+type LineSnapshot struct {
+	Seq  uint64
+	Time int64
+	Row  LineRow
+}
+
+// LineJournal 队列日志，负责追加事件、定期生成快照和按时间点重建队列
+type LineJournal struct {
+	mu            sync.Mutex
+	path          string
+	snapshotDir   string
+	lineFile      string
+	file          *os.File
+	seq           uint64
+	sinceSnapshot int
+	lastSnapshot  time.Time
+}
+
+// NewLineJournal lineFile 为同步保存的队列文件
+func NewLineJournal(path, snapshotDir, lineFile string) *LineJournal {
+	return &LineJournal{path: path, snapshotDir: snapshotDir, lineFile: lineFile}
+}
+
+// Load 读取最近一份可用的快照并回放之后的日志得到当前队列，没有快照时以 line.json 作为当前状态
+func (j *LineJournal) Load() (LineRow, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(j.snapshotDir, 0o755); err != nil {
+		return LineRow{}, err
+	}
+
+	events, err := j.readEvents()
+	if err != nil {
+		return LineRow{}, err
+	}
+
+	snapshots := j.listSnapshots()
+	var base LineSnapshot
+	if len(snapshots) == 0 {
+		row, err := GetLine(j.lineFile)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("队列文件读取失败，可在备份恢复中选择备份", err, slog.String("path", j.lineFile))
+		}
+		// 快照被删除时 line.json 已是最后一次快照的状态，日志中的事件不再回放，序号从日志中最大的序号继续
+		base = LineSnapshot{Row: row, Time: time.Now().UnixMilli()}
+		for _, ev := range events {
+			if ev.Seq > base.Seq {
+				base.Seq = ev.Seq
+			}
+		}
+		if base.Seq > 0 {
+			slog.Warn("没有队列快照，以队列文件作为当前队列，不回放日志", slog.String("path", j.lineFile), slog.Uint64("seq", base.Seq))
+		}
+	} else {
+		base, err = j.latestSnapshot(snapshots)
+		if err != nil {
+			return LineRow{}, err
+		}
+	}
+
+	replay := NewLineEngine("", base.Row, nil)
+	j.seq = base.Seq
+	replayed := 0
+	for _, ev := range events {
+		if ev.Seq <= base.Seq {
+			continue
+		}
+		replay.apply(ev)
+		j.seq = ev.Seq
+		replayed++
+	}
+	slog.Info("队列日志回放完成", slog.Uint64("snapshot", base.Seq), slog.Int("events", replayed))
+
+	j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+	if err != nil {
+		return LineRow{}, err
+	}
+
+	if len(snapshots) == 0 || replayed > 0 {
+		j.snapshot(replay.row)
+	} else {
+		j.lastSnapshot = time.UnixMilli(base.Time)
+	}
+	return replay.row, nil
+}
+
+// Append 追加一条事件，row 为应用事件后的队列，达到条件时生成快照
+func (j *LineJournal) Append(ev LineEvent, row LineRow) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return ErrNoJournal
+	}
+
+	j.seq++
+	ev.Seq = j.seq
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err = j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.sinceSnapshot++
+	if j.sinceSnapshot >= snapshotEveryEvents || time.Since(j.lastSnapshot) >= snapshotInterval {
+		j.snapshot(row)
+	}
+	return nil
+}
+
+// Close 写入最后一份快照并关闭日志文件，退出程序时调用
+func (j *LineJournal) Close(row LineRow) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	if j.sinceSnapshot > 0 {
+		j.snapshot(row)
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// StateAt 重建指定时间点的队列：取该时间之前最近的快照，再回放到该时间为止的事件
+func (j *LineJournal) StateAt(At time.Time) (LineRow, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	at := At.UnixMilli()
+	var base *LineSnapshot
+	for _, path := range j.listSnapshots() {
+		snap, err := readSnapshot(path)
+		if err != nil {
+			slog.Warn("队列快照读取失败", err, slog.String("path", path))
+			continue
+		}
+		if snap.Time <= at {
+			base = &snap
+		}
+	}
+	if base == nil {
+		return LineRow{}, ErrNoHistory
+	}
+
+	events, err := j.readEvents()
+	if err != nil {
+		return LineRow{}, err
+	}
+	replay := NewLineEngine("", base.Row, nil)
+	for _, ev := range events {
+		if ev.Seq > base.Seq && ev.Time <= at {
+			replay.apply(ev)
+		}
+	}
+	return replay.row.Clone(), nil
+}
+
+// LastEventTime 获取最近一次指定类型事件的时间
+func (j *LineJournal) LastEventTime(Op string) (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	events, err := j.readEvents()
+	if err != nil {
+		return time.Time{}, false
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Op == Op {
+			return time.UnixMilli(events[i].Time), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// snapshot 写入快照并压缩日志，调用方需持有锁
+func (j *LineJournal) snapshot(row LineRow) {
+	now := time.Now()
+	data, err := json.Marshal(LineSnapshot{Seq: j.seq, Time: now.UnixMilli(), Row: row})
+	if err != nil {
+		slog.Error("队列快照序列化失败", err)
+		return
+	}
+	path := filepath.Join(j.snapshotDir, fmt.Sprintf("snapshot-%012d.json", j.seq))
+	if err = WriteFileAtomic(path, data); err != nil {
+		slog.Error("队列快照写入失败", err)
+		return
+	}
+	// 保留 line.json 供旧版本和人工查看
+	SetLine(j.lineFile, row)
+
+	j.sinceSnapshot = 0
+	j.lastSnapshot = now
+	j.compact()
+}
+
+// compact 删除多余的快照，并丢弃最早快照之前的日志，调用方需持有锁
+func (j *LineJournal) compact() {
+	snapshots := j.listSnapshots()
+	if len(snapshots) <= maxSnapshots {
+		return
+	}
+	for _, path := range snapshots[:len(snapshots)-maxSnapshots] {
+		_ = os.Remove(path)
+	}
+	oldest, err := readSnapshot(snapshots[len(snapshots)-maxSnapshots])
+	if err != nil {
+		return
+	}
+
+	events, err := j.readEvents()
+	if err != nil {
+		return
+	}
+	var buf strings.Builder
+	for _, ev := range events {
+		if ev.Seq <= oldest.Seq {
+			continue
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	_ = j.file.Close()
+	if err = WriteFileAtomic(j.path, []byte(buf.String())); err != nil {
+		slog.Error("队列日志压缩失败", err)
+	}
+	j.file, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+	if err != nil {
+		slog.Error("队列日志重新打开失败", err)
+		j.file = nil
+	}
+}
+
+// readEvents 读取全部日志，进程异常退出时最后一行可能不完整，解析失败的行会被跳过
+func (j *LineJournal) readEvents() ([]LineEvent, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []LineEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev LineEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			slog.Warn("跳过无法解析的队列日志", err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// listSnapshots 按序号升序列出快照文件
+func (j *LineJournal) listSnapshots() []string {
+	paths, _ := filepath.Glob(filepath.Join(j.snapshotDir, "snapshot-*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+// latestSnapshot 从最新的快照往前找到第一份可以读取的快照，全部无法读取时返回最新快照的错误
+func (j *LineJournal) latestSnapshot(snapshots []string) (LineSnapshot, error) {
+	var first error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snap, err := readSnapshot(snapshots[i])
+		if err == nil {
+			return snap, nil
+		}
+		slog.Warn("队列快照读取失败，尝试更早的快照", err, slog.String("path", snapshots[i]))
+		if first == nil {
+			first = err
+		}
+	}
+	return LineSnapshot{}, first
+}
+
+func readSnapshot(path string) (LineSnapshot, error) {
+	var snap LineSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// ParseRestoreTime 解析恢复时间，支持秒级时间戳和 "2006-01-02 15:04:05" 格式
+func ParseRestoreTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTiers 测试使用的两个层级
+var testTiers = LineRow{Tiers: []TierLine{
+	{Name: "礼物", Sort: TierSortGift, Users: []Line{}},
+	{Name: "普通", Sort: TierSortJoin, Users: []Line{}},
+}}
+
+// newTestJournal 在临时目录中创建队列日志
+func newTestJournal(t *testing.T, dir string) *LineJournal {
+	t.Helper()
+	return NewLineJournal(filepath.Join(dir, LineJournalFile), filepath.Join(dir, LineSnapshotDir), filepath.Join(dir, LineFile))
+}
+
+// testEvents 生成 n 条事件，第一条设置层级，之后依次为加入、在场、备注、移动和移出，时间从 start 起每条间隔1秒
+func testEvents(n int, start time.Time) []LineEvent {
+	events := make([]LineEvent, 0, n)
+	for i := 0; len(events) < n; i++ {
+		at := start.Add(time.Duration(len(events)) * time.Second).UnixMilli()
+		OpenID := fmt.Sprintf("user-%d", i%7)
+		var ev LineEvent
+		switch {
+		case i == 0:
+			row := testTiers.Clone()
+			ev = LineEvent{Op: EventReset, Row: &row}
+		case i%5 == 1:
+			User := Line{OpenID: OpenID, UserName: OpenID, IsOnline: true, GiftPrice: float64(i % 3)}
+			ev = LineEvent{Op: EventJoin, LineType: i % 2, OpenID: OpenID, Line: &User}
+		case i%5 == 2:
+			ev = LineEvent{Op: EventOnline, OpenID: OpenID, IsOnline: i%3 == 0}
+		case i%5 == 3:
+			ev = LineEvent{Op: EventNote, OpenID: OpenID, Note: fmt.Sprintf("note-%d", i)}
+		case i%5 == 4:
+			ev = LineEvent{Op: EventMove, LineType: 1, OpenID: OpenID}
+		default:
+			ev = LineEvent{Op: EventRemove, OpenID: OpenID}
+		}
+		ev.Time = at
+		events = append(events, ev)
+	}
+	return events
+}
+
+// rowJson 比较队列时忽略版本号以及空切片和 nil 的区别
+func rowJson(t *testing.T, row LineRow) string {
+	t.Helper()
+	row.SchemaVersion = 0
+	if len(row.Tiers) == 0 {
+		row.Tiers = nil
+	}
+	for i := range row.Tiers {
+		if len(row.Tiers[i].Users) == 0 {
+			row.Tiers[i].Users = nil
+		}
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// appendEvents 将事件应用到参照队列并写入日志，返回参照队列
+func appendEvents(t *testing.T, j *LineJournal, events []LineEvent) *LineEngine {
+	t.Helper()
+	ref := NewLineEngine("", LineRow{}, nil)
+	for _, ev := range events {
+		ref.apply(ev)
+		if err := j.Append(ev, ref.row.Clone()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ref
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLineJournalReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		events int
+		// 关闭时是否写入快照，未关闭时模拟进程异常退出
+		close bool
+	}{
+		{name: "少于快照间隔", events: 30, close: false},
+		{name: "少于快照间隔并关闭", events: 30, close: true},
+		{name: "跨越快照", events: snapshotEveryEvents*2 + 17, close: false},
+		{name: "超过快照保留数量后压缩", events: snapshotEveryEvents * (maxSnapshots + 3), close: false},
+		{name: "压缩后关闭", events: snapshotEveryEvents*(maxSnapshots+3) + 5, close: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			j := newTestJournal(t, dir)
+			if _, err := j.Load(); err != nil {
+				t.Fatal(err)
+			}
+			events := testEvents(tt.events, time.Now())
+			ref := appendEvents(t, j, events)
+			if tt.close {
+				if err := j.Close(ref.row); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				_ = j.file.Close()
+			}
+
+			snapshots := j.listSnapshots()
+			if len(snapshots) > maxSnapshots {
+				t.Errorf("快照数量 %d 超过 %d", len(snapshots), maxSnapshots)
+			}
+			// 压缩后日志只保留最早快照之后的事件
+			oldest, err := readSnapshot(snapshots[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := countLines(t, j.path), tt.events-int(oldest.Seq); got != want {
+				t.Errorf("日志行数 %d，应为 %d", got, want)
+			}
+
+			// 最新快照与快照序号处的队列一致
+			latest, err := readSnapshot(snapshots[len(snapshots)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			atSeq := NewLineEngine("", LineRow{}, nil)
+			for _, ev := range events[:latest.Seq] {
+				atSeq.apply(ev)
+			}
+			if got, want := rowJson(t, latest.Row), rowJson(t, atSeq.row); got != want {
+				t.Errorf("快照 %d 的队列\n%s\n应为\n%s", latest.Seq, got, want)
+			}
+
+			// 重新加载得到的队列与直接应用全部事件的结果一致
+			reopened := newTestJournal(t, dir)
+			row, err := reopened.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close(row)
+			if got, want := rowJson(t, row), rowJson(t, ref.row); got != want {
+				t.Errorf("回放得到\n%s\n应为\n%s", got, want)
+			}
+			if reopened.seq != uint64(tt.events) {
+				t.Errorf("回放后序号 %d，应为 %d", reopened.seq, tt.events)
+			}
+		})
+	}
+}
+
+func TestLineJournalStateAt(t *testing.T) {
+	dir := t.TempDir()
+	j := newTestJournal(t, dir)
+	before := time.Now().Add(-time.Second)
+	if _, err := j.Load(); err != nil {
+		t.Fatal(err)
+	}
+	// 事件时间晚于启动时写入的快照
+	start := time.Now().Add(time.Minute)
+	events := testEvents(20, start)
+	appendEvents(t, j, events)
+	defer j.Close(LineRow{})
+
+	// stateAfter 应用前 n 条事件后的队列
+	stateAfter := func(n int) LineRow {
+		e := NewLineEngine("", LineRow{}, nil)
+		for _, ev := range events[:n] {
+			e.apply(ev)
+		}
+		return e.row
+	}
+	eventTime := func(i int) time.Time {
+		return time.UnixMilli(events[i].Time)
+	}
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		want    LineRow
+		wantErr error
+	}{
+		{name: "早于第一份快照", at: before, wantErr: ErrNoHistory},
+		{name: "快照之后第一条事件之前", at: eventTime(0).Add(-time.Millisecond), want: stateAfter(0)},
+		{name: "恰好为第一条事件", at: eventTime(0), want: stateAfter(1)},
+		{name: "事件前1毫秒不包含该事件", at: eventTime(10).Add(-time.Millisecond), want: stateAfter(10)},
+		{name: "恰好为事件时间包含该事件", at: eventTime(10), want: stateAfter(11)},
+		{name: "恰好为最后一条事件", at: eventTime(len(events) - 1), want: stateAfter(len(events))},
+		{name: "晚于全部事件", at: eventTime(len(events) - 1).Add(time.Hour), want: stateAfter(len(events))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := j.StateAt(tt.at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 %v，应为 %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got, want := rowJson(t, row), rowJson(t, tt.want); got != want {
+				t.Errorf("得到\n%s\n应为\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestLineJournalLoadRecovery(t *testing.T) {
+	const total = snapshotEveryEvents*3 + 12
+	tests := []struct {
+		name string
+		// damage 修改日志目录，模拟快照损坏或丢失
+		damage  func(t *testing.T, snapshots []string)
+		wantErr bool
+	}{
+		{
+			name: "最新快照损坏时使用更早的快照",
+			damage: func(t *testing.T, snapshots []string) {
+				if err := os.WriteFile(snapshots[len(snapshots)-1], []byte("{"), 0o666); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "多份快照损坏",
+			damage: func(t *testing.T, snapshots []string) {
+				for _, path := range snapshots[1:] {
+					if err := os.WriteFile(path, nil, 0o666); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "快照全部丢失时以队列文件为准不重复回放",
+			damage: func(t *testing.T, snapshots []string) {
+				for _, path := range snapshots {
+					if err := os.Remove(path); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "快照全部损坏",
+			damage: func(t *testing.T, snapshots []string) {
+				for _, path := range snapshots {
+					if err := os.WriteFile(path, []byte("null}"), 0o666); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			j := newTestJournal(t, dir)
+			if _, err := j.Load(); err != nil {
+				t.Fatal(err)
+			}
+			events := testEvents(total, time.Now())
+			ref := appendEvents(t, j, events)
+			if err := j.Close(ref.row); err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, j.listSnapshots())
+
+			reopened := newTestJournal(t, dir)
+			row, err := reopened.Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("应返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rowJson(t, row), rowJson(t, ref.row); got != want {
+				t.Errorf("加载得到\n%s\n应为\n%s", got, want)
+			}
+			if reopened.seq != total {
+				t.Errorf("加载后序号 %d，应为 %d", reopened.seq, total)
+			}
+
+			// 之后的事件从原序号继续
+			next := LineEvent{Op: EventRemove, OpenID: "user-1", Time: time.Now().UnixMilli()}
+			ref.apply(next)
+			if err = reopened.Append(next, ref.row.Clone()); err != nil {
+				t.Fatal(err)
+			}
+			if reopened.seq != total+1 {
+				t.Errorf("追加后序号 %d，应为 %d", reopened.seq, total+1)
+			}
+			if err = reopened.Close(ref.row); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// 层级内排序方式
+const (
+	// TierSortJoin 按加入顺序
+	TierSortJoin = "join"
+	// TierSortGift 按累计礼物价值降序，同价值按加入顺序
+	TierSortGift = "gift"
+	// TierSortGuard 按大航海等级，同等级按加入顺序
+	TierSortGuard = "guard"
+)
+
+var ErrNoLineTier = errors.New("at least one line tier is required")
+
+// TierRule 层级准入条件，满足任一已设置的条件即可进入，全部未设置时接受所有用户
+type TierRule struct {
+	// 特殊用户
+	Special bool
+	// 大航海等级不低于，1总督 2提督 3舰长，0为不要求
+	GuardLevel int
+	// 送过计入队列的礼物
+	Gift bool
+	// 累计礼物价值(电池)不低于，0为不要求
+	MinGiftPrice float64
+	// 粉丝牌等级不低于，0为不要求
+	MinMedalLevel int
+}
+
+// LineTier 队列层级配置
+type LineTier struct {
+	Name string
+	Rule TierRule
+	// 显示颜色
+	Color LineColor
+	// 层级容量，0为不限
+	MaxCount int
+	// 层级内排序方式
+	Sort string
+	// 不受重新排队冷却和叫号次数限制
+	IgnoreServeLimit bool
+}
+
+// IsEmpty 是否未设置任何条件
+func (r TierRule) IsEmpty() bool {
+	return !r.Special && r.GuardLevel <= 0 && !r.Gift && r.MinGiftPrice <= 0 && r.MinMedalLevel <= 0
+}
+
+// Match 用户是否满足层级准入条件
+func (r TierRule) Match(User Line) bool {
+	if r.IsEmpty() {
+		return true
+	}
+	switch {
+	case r.Special && IsSpecialUser(User.OpenID):
+		return true
+	case r.GuardLevel > 0 && User.GuardLevel > 0 && User.GuardLevel <= r.GuardLevel:
+		return true
+	case r.Gift && User.GiftPrice > 0:
+		return true
+	case r.MinGiftPrice > 0 && User.GiftPrice >= r.MinGiftPrice:
+		return true
+	case r.MinMedalLevel > 0 && User.FansMedalLevel >= r.MinMedalLevel:
+		return true
+	}
+	return false
+}
+
+// DefaultLineTiers 默认层级，与旧版的舰长、礼物、普通三条队列一致，队列容量由各队列的 MaxLineCount 限制
+func DefaultLineTiers(Config RunConfig) []LineTier {
+	guardRule := TierRule{Special: true}
+	if Config.GuardAutoJoin || Config.GuardBuyAutoJoin {
+		guardRule.GuardLevel = 3
+	}
+	return []LineTier{
+		{Name: "舰长", Rule: guardRule, Color: Config.GuardPrintColor, Sort: TierSortGuard, IgnoreServeLimit: Config.ServeRules.ExemptPaidTiers},
+		{Name: "礼物", Rule: TierRule{Gift: true}, Color: Config.GiftPrintColor, Sort: TierSortGift, IgnoreServeLimit: Config.ServeRules.ExemptPaidTiers},
+		{Name: "普通", Color: Config.CommonPrintColor, Sort: TierSortJoin},
+	}
+}
+
+// ActiveLineTiers 当前生效的层级配置
+func ActiveLineTiers() []LineTier {
+	Config := Configuration()
+	if len(Config.LineTiers) > 0 {
+		return Config.LineTiers
+	}
+	return DefaultLineTiers(Config)
+}
+
+// PickTier 按当前层级配置为用户选择层级，没有满足条件的层级时放入最后一个层级
+func PickTier(User Line) int {
+	return pickTier(ActiveLineTiers(), User)
+}
+
+func pickTier(tiers []LineTier, User Line) int {
+	for i, t := range tiers {
+		if t.Rule.Match(User) {
+			return i
+		}
+	}
+	return len(tiers) - 1
+}
+
+// TierColor 层级显示颜色
+func TierColor(Tier int) LineColor {
+	tiers := ActiveLineTiers()
+	if Tier < 0 || Tier >= len(tiers) {
+		return Configuration().CommonPrintColor
+	}
+	return tiers[Tier].Color
+}
+
+// TierMaxCount 层级容量，0为不限
+func TierMaxCount(Tier int) int {
+	tiers := ActiveLineTiers()
+	if Tier < 0 || Tier >= len(tiers) {
+		return 0
+	}
+	return tiers[Tier].MaxCount
+}
+
+// IsSpecialUser 用户是否为未过期的特殊用户
+func IsSpecialUser(OpenID string) bool {
+	UserStruct, ok := SpecialUser(OpenID)
+	return ok && UserStruct.EndTime >= time.Now().Unix()
+}
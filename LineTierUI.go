@@ -0,0 +1,212 @@
+package main
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// 层级排序方式的显示名称，与 TierSort* 一一对应
+var tierSortNames = []string{"按加入顺序", "按礼物价值", "按大航海等级"}
+var tierSortValues = []string{TierSortJoin, TierSortGift, TierSortGuard}
+
+// 大航海条件的显示名称，下标即 TierRule.GuardLevel
+var tierGuardNames = []string{"不要求", "总督", "提督及以上", "舰长及以上"}
+
+// tierEditorRow 层级编辑器中的一行
+type tierEditorRow struct {
+	Name          *widget.Entry
+	Sort          *widget.Select
+	MaxCount      *widget.Entry
+	Special       *widget.Check
+	Guard         *widget.Select
+	Gift          *widget.Check
+	MinGiftPrice  *widget.Entry
+	MinMedalLevel *widget.Entry
+	IgnoreServe   *widget.Check
+	Color         *canvas.Text
+}
+
+func newTierEditorRow(t LineTier) *tierEditorRow {
+	r := &tierEditorRow{
+		Name:          widget.NewEntry(),
+		Sort:          widget.NewSelect(tierSortNames, nil),
+		MaxCount:      widget.NewEntry(),
+		Special:       widget.NewCheck("特殊用户", nil),
+		Guard:         widget.NewSelect(tierGuardNames, nil),
+		Gift:          widget.NewCheck("送过礼物", nil),
+		MinGiftPrice:  widget.NewEntry(),
+		MinMedalLevel: widget.NewEntry(),
+		IgnoreServe:   widget.NewCheck("不受重新排队冷却和次数限制", nil),
+		Color:         canvas.NewText("显示颜色", color.RGBA{R: 255, G: 255, B: 255, A: 255}),
+	}
+	r.Name.SetPlaceHolder("层级名称")
+	r.Name.SetText(t.Name)
+	r.Sort.SetSelectedIndex(0)
+	for i, v := range tierSortValues {
+		if v == t.Sort {
+			r.Sort.SetSelectedIndex(i)
+		}
+	}
+	r.MaxCount.SetPlaceHolder("容量，留空不限")
+	if t.MaxCount > 0 {
+		r.MaxCount.SetText(strconv.Itoa(t.MaxCount))
+	}
+	r.Special.SetChecked(t.Rule.Special)
+	r.Guard.SetSelectedIndex(0)
+	if t.Rule.GuardLevel > 0 && t.Rule.GuardLevel < len(tierGuardNames) {
+		r.Guard.SetSelectedIndex(t.Rule.GuardLevel)
+	}
+	r.Gift.SetChecked(t.Rule.Gift)
+	r.MinGiftPrice.SetPlaceHolder("累计礼物价值不低于(电池)")
+	if t.Rule.MinGiftPrice > 0 {
+		r.MinGiftPrice.SetText(strconv.FormatFloat(t.Rule.MinGiftPrice, 'f', -1, 64))
+	}
+	r.MinMedalLevel.SetPlaceHolder("粉丝牌等级不低于")
+	if t.Rule.MinMedalLevel > 0 {
+		r.MinMedalLevel.SetText(strconv.Itoa(t.Rule.MinMedalLevel))
+	}
+	r.IgnoreServe.SetChecked(t.IgnoreServeLimit)
+	if !t.Color.IsEmpty() {
+		r.Color.Color = t.Color.ToRGBA()
+	}
+	return r
+}
+
+// read 读取编辑后的层级配置
+func (r *tierEditorRow) read() (LineTier, error) {
+	t := LineTier{
+		Name:             strings.TrimSpace(r.Name.Text),
+		Sort:             tierSortValues[r.Sort.SelectedIndex()],
+		Color:            ToLineColor(r.Color.Color),
+		IgnoreServeLimit: r.IgnoreServe.Checked,
+		Rule: TierRule{
+			Special:    r.Special.Checked,
+			GuardLevel: r.Guard.SelectedIndex(),
+			Gift:       r.Gift.Checked,
+		},
+	}
+	if t.Name == "" {
+		return t, DisplayError{Message: "层级名称不能为空"}
+	}
+	var err error
+	if text := strings.TrimSpace(r.MaxCount.Text); text != "" {
+		if t.MaxCount, err = strconv.Atoi(text); err != nil || t.MaxCount < 0 {
+			return t, DisplayError{Message: t.Name + "：容量应该是不小于0的整数"}
+		}
+	}
+	if text := strings.TrimSpace(r.MinGiftPrice.Text); text != "" {
+		if t.Rule.MinGiftPrice, err = strconv.ParseFloat(text, 64); err != nil || t.Rule.MinGiftPrice < 0 {
+			return t, DisplayError{Message: t.Name + "：礼物价值应该是不小于0的数字"}
+		}
+	}
+	if text := strings.TrimSpace(r.MinMedalLevel.Text); text != "" {
+		if t.Rule.MinMedalLevel, err = strconv.Atoi(text); err != nil || t.Rule.MinMedalLevel < 0 {
+			return t, DisplayError{Message: t.Name + "：粉丝牌等级应该是不小于0的整数"}
+		}
+	}
+	return t, nil
+}
+
+// ShowLineTierEditor 打开队列层级编辑窗口，保存时回调编辑后的层级，恢复默认时回调 nil
+// 层级按从上到下的顺序决定优先级，用户进入第一个满足条件的层级，都不满足时进入最后一个层级
+func ShowLineTierEditor(tiers []LineTier, onSave func([]LineTier)) {
+	w := App.NewWindow("编辑队列层级")
+	w.Resize(fyne.NewSize(700, 600))
+
+	var rows []*tierEditorRow
+	for _, t := range tiers {
+		rows = append(rows, newTierEditorRow(t))
+	}
+
+	list := container.NewVBox()
+	var render func()
+	render = func() {
+		list.RemoveAll()
+		for i, r := range rows {
+			i, r := i, r
+			upBtn := widget.NewButton("↑", func() {
+				if i > 0 {
+					rows[i-1], rows[i] = rows[i], rows[i-1]
+					render()
+				}
+			})
+			downBtn := widget.NewButton("↓", func() {
+				if i < len(rows)-1 {
+					rows[i], rows[i+1] = rows[i+1], rows[i]
+					render()
+				}
+			})
+			deleteBtn := widget.NewButton("删除", func() {
+				rows = append(rows[:i], rows[i+1:]...)
+				render()
+			})
+			deleteBtn.Importance = widget.DangerImportance
+
+			list.Add(widget.NewCard(strconv.Itoa(i+1)+". "+r.Name.Text, "", container.NewVBox(
+				container.NewGridWithColumns(3, r.Name, r.Sort, r.MaxCount),
+				widget.NewLabel("准入条件(满足任一即可，全部不设置时接受所有用户)"),
+				container.NewGridWithColumns(3, r.Special, r.Gift, r.Guard),
+				container.NewGridWithColumns(2, r.MinGiftPrice, r.MinMedalLevel),
+				r.IgnoreServe,
+				r.Color,
+				MakeSelectColor(r.Color),
+				container.NewHBox(upBtn, downBtn, deleteBtn),
+			)))
+		}
+		list.Refresh()
+	}
+	render()
+
+	addBtn := widget.NewButton("添加层级", func() {
+		rows = append(rows, newTierEditorRow(LineTier{Name: "新层级", Sort: TierSortJoin}))
+		render()
+	})
+	resetBtn := widget.NewButton("恢复默认", func() {
+		dialog.ShowConfirm("恢复默认", "将使用舰长、礼物、普通三个默认层级", func(ok bool) {
+			if !ok {
+				return
+			}
+			onSave(nil)
+			w.Close()
+		}, w)
+	})
+	saveBtn := widget.NewButton("保存", func() {
+		if len(rows) == 0 {
+			dialog.ShowError(DisplayError{Message: "至少需要一个层级"}, w)
+			return
+		}
+		res := make([]LineTier, 0, len(rows))
+		names := make(map[string]bool)
+		for _, r := range rows {
+			t, err := r.read()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if names[t.Name] {
+				dialog.ShowError(DisplayError{Message: "层级名称不能重复：" + t.Name}, w)
+				return
+			}
+			names[t.Name] = true
+			res = append(res, t)
+		}
+		onSave(res)
+		w.Close()
+	})
+	saveBtn.Importance = widget.HighImportance
+
+	w.SetContent(container.NewBorder(
+		widget.NewLabel("层级从上到下优先级依次降低，不满足任何层级条件的用户进入最后一个层级"),
+		container.NewHBox(addBtn, resetBtn, saveBtn),
+		nil, nil,
+		container.NewVScroll(list),
+	))
+	w.Show()
+}
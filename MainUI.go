@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -24,7 +25,11 @@ import (
 var Pic404 []byte
 
 func MakeMainUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
-	Windows.SetTitle("主页面")
+	if Profile == DefaultProfile {
+		Windows.SetTitle("主页面")
+	} else {
+		Windows.SetTitle("主页面 - " + Profile)
+	}
 	var RoomInformationObtained RoomInfo
 	for RoomId == 0 {
 	}
@@ -71,14 +76,14 @@ func MakeMainUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 		Windows.SetContent(MakeConfigUI(Windows, Config))
 	})
 	CopyLineUrlButton := widget.NewButton("复制排队组件Url", func() {
-		err = clipboard.WriteAll("http://127.0.0.1:100/web")
+		err = clipboard.WriteAll(LocalURL("/web"))
 		if err != nil {
 			dialog.ShowError(DisplayError{"写入剪贴板错误"}, Windows)
 			return
 		}
 	})
 	CopyDmUrlButton := widget.NewButton("复制弹幕组件Url", func() {
-		err := clipboard.WriteAll("http://127.0.0.1:100/dm")
+		err := clipboard.WriteAll(LocalURL("/dm"))
 		if err != nil {
 			dialog.ShowError(DisplayError{"写入剪贴板错误"}, Windows)
 			return
@@ -102,10 +107,52 @@ func MakeMainUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 	// 	SpecialUserSetWindows.Show()
 	// })
 
-	ReconnectButton := widget.NewButton("重连弹幕服务器", func() {
-		Restart()
+	BlacklistButton := widget.NewButton("黑名单管理", ShowBlacklistWindow)
+	BackupButton := widget.NewButton("备份恢复", ShowBackupWindow)
+
+	// 每个配置档案有独立的身份码、配置、队列和日志，输入新名称即创建新的配置档案
+	ProfileSelect := widget.NewSelectEntry(ListProfiles())
+	ProfileSelect.SetText(Profile)
+	ProfileSelect.SetPlaceHolder("配置档案名称")
+	SwitchProfileButton := widget.NewButton("切换配置档案", func() {
+		Name := strings.TrimSpace(ProfileSelect.Text)
+		if Name == Profile {
+			return
+		}
+		if err := ValidateProfileName(Name); err != nil {
+			dialog.ShowError(DisplayError{Message: err.Error()}, Windows)
+			return
+		}
+		dialog.ShowConfirm("切换配置档案", "切换到配置档案 "+Name+" 并重新启动", func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := SwitchProfile(Name); err != nil {
+				slog.Error("切换配置档案失败", err)
+				dialog.ShowError(DisplayError{Message: "切换配置档案失败：" + err.Error()}, Windows)
+			}
+		}, Windows)
+	})
+	ProfileBox := container.NewBorder(nil, nil, widget.NewLabel("配置档案"), SwitchProfileButton, ProfileSelect)
+
+	var ReconnectButton *widget.Button
+	ReconnectButton = widget.NewButton("重连弹幕服务器", func() {
+		// 连接可能需要数秒，在后台进行，完成后提示结果
+		ReconnectButton.Disable()
+		go func() {
+			err := ReconnectRoom(Configuration().IdCode)
+			fyne.Do(func() {
+				ReconnectButton.Enable()
+				if err != nil {
+					slog.Error("重连弹幕服务器失败", err)
+					dialog.ShowError(DisplayError{Message: "重连弹幕服务器失败，请检查身份码"}, Windows)
+					return
+				}
+				dialog.ShowInformation("重连成功", "已重新连接弹幕服务器", Windows)
+			})
+		}()
 	})
-	if !globalConfiguration.EnableMusicServer {
+	if !Configuration().EnableMusicServer {
 		CopyMusicUrlButton.Hide()
 	}
 
@@ -131,9 +178,9 @@ func MakeMainUI(Windows fyne.Window, Config RunConfig) *fyne.Container {
 			canvas.NewText(difference.String(), color.White),
 		)
 
-		return container.NewVBox(TittleDisplay, LiveStatusDisplay, DescDisplay, LiveCoverDisplay, LiveStarTimeDisplay, LiveKeepTimeDisplay, CopyLineUrlButton, CopyDmUrlButton, CopyMusicUrlButton, JumpToConfigUI, ReconnectButton, assist)
+		return container.NewVBox(TittleDisplay, LiveStatusDisplay, DescDisplay, LiveCoverDisplay, LiveStarTimeDisplay, LiveKeepTimeDisplay, CopyLineUrlButton, CopyDmUrlButton, CopyMusicUrlButton, JumpToConfigUI, BlacklistButton, BackupButton, ProfileBox, ReconnectButton, assist)
 	} else {
-		return container.NewVBox(TittleDisplay, LiveStatusDisplay, DescDisplay, LiveCoverDisplay, CopyLineUrlButton, CopyDmUrlButton, CopyMusicUrlButton, JumpToConfigUI, ReconnectButton, assist)
+		return container.NewVBox(TittleDisplay, LiveStatusDisplay, DescDisplay, LiveCoverDisplay, CopyLineUrlButton, CopyDmUrlButton, CopyMusicUrlButton, JumpToConfigUI, BlacklistButton, BackupButton, ProfileBox, ReconnectButton, assist)
 	}
 }
 
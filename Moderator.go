@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/vtb-link/bianka/proto"
+)
+
+// 房管指令标识，只有主播和房管发送时生效
+const (
+	CommandNext   = "next"
+	CommandPause  = "pause"
+	CommandResume = "resume"
+	CommandClear  = "clear"
+	CommandRemove = "remove"
+	CommandTop    = "top"
+)
+
+// AuditLogFile 房管指令审计记录，每行一条 AuditRecord
+const AuditLogFile = "audit.log"
+
+var ErrUserNameNotFound = errors.New("no user with this name in any line")
+
+// 房管指令
+var moderatorCommands = map[string]bool{
+	CommandNext:   true,
+	CommandPause:  true,
+	CommandResume: true,
+	CommandClear:  true,
+	CommandRemove: true,
+	CommandTop:    true,
+}
+
+// IsModeratorCommand 是否为只有主播和房管可用的指令
+func IsModeratorCommand(Name string) bool {
+	return moderatorCommands[Name]
+}
+
+// ModeratorConfig 可以通过弹幕控制队列的房管
+type ModeratorConfig struct {
+	OpenID   string
+	UserName string
+}
+
+// AnchorUid 当前直播间主播的 UID，连接直播间时设置
+var AnchorUid int
+
+// IsModerator 弹幕发送者是否为主播或配置的房管，只按 UID 和 OpenID 判断，用户名可以被他人使用
+func IsModerator(Dm *proto.CmdDanmuData) bool {
+	if AnchorUid != 0 && Dm.Uid == AnchorUid {
+		return true
+	}
+	for _, m := range Configuration().Moderators {
+		if m.OpenID == Dm.OpenID {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseModerators 解析每行一条 "OpenID|名称" 的房管配置，名称可省略
+func ParseModerators(s string) ([]ModeratorConfig, error) {
+	var res []ModeratorConfig
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		OpenID, UserName, _ := strings.Cut(line, "|")
+		OpenID = strings.TrimSpace(OpenID)
+		if OpenID == "" {
+			return nil, fmt.Errorf("房管配置格式错误：%s", line)
+		}
+		if seen[OpenID] {
+			return nil, fmt.Errorf("房管重复：%s", OpenID)
+		}
+		seen[OpenID] = true
+		res = append(res, ModeratorConfig{OpenID: OpenID, UserName: strings.TrimSpace(UserName)})
+	}
+	return res, nil
+}
+
+// FormatModerators 将房管配置格式化为每行一条 "OpenID|名称"
+func FormatModerators(list []ModeratorConfig) string {
+	lines := make([]string, 0, len(list))
+	for _, m := range list {
+		lines = append(lines, m.OpenID+"|"+m.UserName)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runModeratorCommand 执行房管指令，与控制界面按钮使用相同的操作，返回执行结果
+// 下一位、暂停排队、恢复排队、清空的参数为队列标识或名称，留空为默认队列；移除、置顶的参数为用户名
+func runModeratorCommand(Name, Arg string) (string, error) {
+	switch Name {
+	case CommandRemove, CommandTop:
+		q, User, ok := queues.FindByName(Arg)
+		if !ok {
+			return "", ErrUserNameNotFound
+		}
+		if Name == CommandTop {
+			return "置顶 " + User.UserName + "(" + q.Title() + ")", OperatorMoveAction(User.OpenID, MoveTop, 0)
+		}
+		return "移除 " + User.UserName + "(" + q.Title() + ")", OperatorRemove(User.OpenID)
+	}
+
+	q, err := queues.Lookup(Arg)
+	if err != nil {
+		return "", err
+	}
+	switch Name {
+	case CommandNext:
+		User, err := OperatorNext(q)
+		if err != nil {
+			return "", err
+		}
+		return q.Title() + " 叫号 " + User.UserName, nil
+	case CommandPause:
+		q.SetPaused(true)
+		return "暂停 " + q.Title(), nil
+	case CommandResume:
+		q.SetPaused(false)
+		return "恢复 " + q.Title(), nil
+	case CommandClear:
+		OperatorClear(q)
+		return "清空 " + q.Title(), nil
+	}
+	return "", fmt.Errorf("unknown moderator command %s", Name)
+}
+
+// ResponseModeratorCommand 执行房管指令并写入审计记录，非房管发送时忽略
+func ResponseModeratorCommand(Name, Arg string, Dm *proto.CmdDanmuData) {
+	if !IsModerator(Dm) {
+		return
+	}
+	rec := AuditRecord{
+		OpenID:   Dm.OpenID,
+		UserName: Dm.Uname,
+		Command:  Name,
+		Arg:      Arg,
+	}
+	Result, err := runModeratorCommand(Name, Arg)
+	if err != nil {
+		rec.Error = err.Error()
+		slog.Warn("房管指令执行失败", slog.String("UserName", Dm.Uname), slog.String("Command", Name), slog.String("error", rec.Error))
+	} else {
+		rec.Result = Result
+		slog.Info("房管指令", slog.String("UserName", Dm.Uname), slog.String("Result", Result))
+	}
+	if err := auditLog.Record(rec); err != nil {
+		slog.Error("审计记录写入失败", err)
+	}
+}
+
+// AuditRecord 一条房管指令记录
+type AuditRecord struct {
+	Time     int64 // 毫秒时间戳
+	OpenID   string
+	UserName string
+	Command  string
+	Arg      string `json:",omitempty"`
+	Result   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// AuditLog 房管指令审计记录，只追加不修改
+type AuditLog struct {
+	w *JsonLinesWriter
+}
+
+// auditLog 启动时由 InitDataDir 改为配置档案目录中的文件
+var auditLog = NewAuditLog(AuditLogFile)
+
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{w: NewJsonLinesWriter(path)}
+}
+
+// Record 追加一条记录
+func (l *AuditLog) Record(rec AuditRecord) error {
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixMilli()
+	}
+	return l.w.Append(rec)
+}
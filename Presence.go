@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// PresenceSweepInterval 检查无互动和暂离超时的间隔
+const PresenceSweepInterval = 30 * time.Second
+
+// PresenceTracker 记录用户最近一次互动(弹幕、礼物、点赞、进入直播间等)的时间，用于自动判断在场状态
+// 因长时间无互动被标记为不在的用户记录在队列的 Line.Idle 中，与用户主动暂离分开，不会被暂离超时移出
+type PresenceTracker struct {
+	mu       sync.Mutex
+	started  time.Time
+	lastSeen map[string]time.Time
+}
+
+var presence = NewPresenceTracker()
+
+func NewPresenceTracker() *PresenceTracker {
+	return &PresenceTracker{
+		started:  time.Now(),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Touch 记录用户互动，用户此前因无互动被标记为不在时恢复在场
+func (p *PresenceTracker) Touch(OpenID string) {
+	if OpenID == "" {
+		return
+	}
+	p.mu.Lock()
+	p.lastSeen[OpenID] = time.Now()
+	p.mu.Unlock()
+
+	q, ok := queues.Find(OpenID)
+	if !ok {
+		return
+	}
+	if le, ok := q.Engine.Entry(OpenID); ok && le.Line.Idle {
+		if err := q.Engine.SetOnline(OpenID, true); err != nil && err != ErrUserNotInLine {
+			slog.Error("恢复在场状态失败", err, slog.String("OpenID", OpenID))
+		}
+	}
+}
+
+// LastSeen 用户最近一次互动时间，本次运行中没有互动时返回 false
+func (p *PresenceTracker) LastSeen(OpenID string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.lastSeen[OpenID]
+	return t, ok
+}
+
+// MarkIdle 将超过 Idle 没有互动的在场用户标记为无互动，本次运行中没有互动的用户从启动时间算起，返回标记人数
+func (p *PresenceTracker) MarkIdle(Now time.Time, Idle time.Duration) int {
+	marked := 0
+	for _, q := range queues.All() {
+		var idle []string
+		p.mu.Lock()
+		for _, tier := range q.Engine.Snapshot().Tiers {
+			for _, User := range tier.Users {
+				if !User.IsOnline {
+					continue
+				}
+				last, ok := p.lastSeen[User.OpenID]
+				if !ok {
+					last = p.started
+				}
+				if Now.Sub(last) >= Idle {
+					idle = append(idle, User.OpenID)
+				}
+			}
+		}
+		p.mu.Unlock()
+
+		for _, OpenID := range idle {
+			if ok, err := q.Engine.SetIdle(OpenID); err != nil || !ok {
+				continue
+			}
+			marked++
+			slog.Info("长时间无互动标记为不在", slog.String("OpenID", OpenID))
+		}
+	}
+	return marked
+}
+
+// SetPresence 用户通过弹幕标记自己在场或暂离，状态未变化时不产生事件
+func SetPresence(OpenID string, IsOnline bool) error {
+	q, ok := queues.Find(OpenID)
+	if !ok {
+		return ErrUserNotInLine
+	}
+	// 无互动标记的用户主动暂离时改为暂离，开始计算暂离时间
+	if le, ok := q.Engine.Entry(OpenID); ok && le.Line.IsOnline == IsOnline && !le.Line.Idle {
+		return nil
+	}
+	return q.Engine.SetOnline(OpenID, IsOnline)
+}
+
+// RemoveAwayUsers 移出暂离超过 Timeout 的用户，视同用户取消排队，无互动标记的用户不移出，返回移出人数
+func RemoveAwayUsers(Now time.Time, Timeout time.Duration) int {
+	var expired []string
+	for _, q := range queues.All() {
+		for _, tier := range q.Engine.Snapshot().Tiers {
+			for _, User := range tier.Users {
+				if !User.IsOnline && !User.Idle && User.AwaySince > 0 && Now.Sub(time.Unix(User.AwaySince, 0)) >= Timeout {
+					expired = append(expired, User.OpenID)
+				}
+			}
+		}
+	}
+	removed := 0
+	for _, OpenID := range expired {
+		if err := DeleteLine(OpenID); err != nil {
+			continue
+		}
+		removed++
+		slog.Info("暂离超时移出队列", slog.String("OpenID", OpenID))
+	}
+	return removed
+}
+
+// RunPresenceSweeper 定期将无互动的用户标记为不在，并移出暂离超时的用户，时间每次按当前配置读取
+func RunPresenceSweeper() {
+	tk := time.NewTicker(PresenceSweepInterval)
+	defer tk.Stop()
+	for now := range tk.C {
+		Config := Configuration()
+		if Config.IdleTimeout > 0 {
+			presence.MarkIdle(now, time.Duration(Config.IdleTimeout)*time.Minute)
+		}
+		if Config.AwayTimeout > 0 {
+			RemoveAwayUsers(now, time.Duration(Config.AwayTimeout)*time.Minute)
+		}
+	}
+}
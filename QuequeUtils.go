@@ -1,99 +1,238 @@
 package main
 
 import (
+	"errors"
 	"strings"
 	"time"
 
+	"golang.org/x/exp/slog"
+
 	"github.com/vtb-link/bianka/proto"
 )
 
 func ResponseQueCtrl(DmParsed *proto.CmdDanmuData) {
-	// 音乐点歌功能（保持不变）
-	if globalConfiguration.EnableMusicServer {
-		if strings.HasPrefix(DmParsed.Msg, "点歌 ") {
-			SendMusicServer("search", DmParsed.Msg[7:])
-		}
-	}
 	SendDmToWs(DmParsed)
 
-	// 取消排队指令（保持不变）
-	if DmParsed.Msg == "取消排队" {
-		DeleteLine(DmParsed.OpenID)
+	// 排队以外的弹幕指令
+	if c, Arg, ok := commands.Match(DmParsed.Msg); ok {
+		if commands.Allow(c, DmParsed.OpenID) {
+			runCommand(c.Name, Arg, DmParsed)
+		}
 		return
 	}
 
-	// 寻址指令（保持不变）
-	if DmParsed.Msg == "我在哪" {
-		SendWhereToWs(DmParsed.OpenID)
+	join := commands.Get(CommandJoin)
+	if !join.Enabled {
 		return
 	}
 
-	// 仅礼物模式（保持不变）
-	if globalConfiguration.IsOnlyGift {
+	// 弹幕带有大航海等级且开启了自动加入
+	Config := Configuration()
+	isGuard := Config.GuardAutoJoin && DmParsed.GuardLevel > 0
+
+	// 仅礼物模式，可配置为大航海用户不受限制
+	if Config.IsOnlyGift && !(isGuard && Config.GuardIgnoreOnlyGift) {
 		return
 	}
 
-	// 关键词匹配（保持不变）
-	if !KeyWordMatchMap[DmParsed.Msg] {
+	// 按关键词选择队列
+	q, Note, ok := queues.Match(DmParsed.Msg, join.Match)
+	if !ok {
 		return
 	}
 
 	openID := DmParsed.OpenID
+	if !commands.Allow(join, openID) {
+		return
+	}
 
-	// 检查是否已在队列中（保持不变）
-	if line.GuardIndex[openID] != 0 || line.GiftIndex[openID] != 0 || line.CommonIndex[openID] != 0 {
+	// 黑名单用户不能加入任何队列
+	if entry, banned := blacklist.Check(openID); banned {
+		slog.Info("黑名单用户尝试排队", slog.String("UserName", DmParsed.Uname), slog.String("reason", entry.Reason))
+		return
+	}
+
+	// 同一用户同时只能在一个队列中
+	if _, waiting := queues.FindWaiting(openID); waiting || queues.Contains(openID) {
 		return
 	}
 
 	//暂停排队功能
-	if paused {
+	if q.Paused() {
 		return
 	}
 
-	// 特殊用户处理
-	_, ok := SpecialUserList[openID]
-	switch {
-	case ok: // 特殊用户
-		UserStruct := SpecialUserList[openID]
-		if UserStruct.EndTime < time.Now().Unix() {
-			delete(SpecialUserList, openID)
-			globalConfiguration.SpecialUserList = SpecialUserList
-			SetConfig(globalConfiguration)
-			return
+	// 粉丝牌和大航海资格，不满足时在队列页面提示原因
+	if reason := q.JoinRule().Check(DmParsed); reason != "" {
+		slog.Info("用户不满足排队条件", slog.String("UserName", DmParsed.Uname), slog.String("reason", reason))
+		SendRejectToWs(q.Name, Line{OpenID: openID, UserName: DmParsed.Uname}, reason)
+		return
+	}
+
+	// 特殊用户过期后移出名单，按普通用户处理
+	if UserStruct, ok := SpecialUser(openID); ok && UserStruct.EndTime < time.Now().Unix() {
+		UpdateSpecialUsers(func(users map[string]SpecialUserStruct) {
+			delete(users, openID)
+		})
+	}
+
+	lineTemp := Line{
+		OpenID:         openID,
+		UserName:       DmParsed.Uname,
+		Avatar:         DmParsed.UFace,
+		IsOnline:       true, // 默认设置为在线状态
+		FansMedalLevel: DmParsed.FansMedalLevel,
+		Note:           Note,
+	}
+	if isGuard {
+		lineTemp.GuardLevel = DmParsed.GuardLevel
+	}
+	tier := PickTier(lineTemp)
+	// 刚被叫号或取消排队的用户在冷却时间内不能重新排队
+	if err := CheckServeLimit(openID, tier); err != nil {
+		slog.Info("用户暂不能重新排队", slog.String("UserName", DmParsed.Uname), slog.String("reason", err.Error()))
+		SendRejectToWs(q.Name, lineTemp, ServeLimitReason(err))
+		return
+	}
+	lineTemp.PrintColor = TierColor(tier)
+	// 队列或层级已满时加入等候名单，大航海用户可配置为不受队列总容量限制
+	if err := JoinOrWait(q, tier, lineTemp); err != nil {
+		slog.Error("加入队列失败", err, slog.String("OpenID", openID))
+	}
+}
+
+// runCommand 执行排队以外的弹幕指令，Arg 为指令参数
+func runCommand(Name, Arg string, DmParsed *proto.CmdDanmuData) {
+	if IsModeratorCommand(Name) {
+		ResponseModeratorCommand(Name, Arg, DmParsed)
+		return
+	}
+	switch Name {
+	case CommandLeave:
+		DeleteLine(DmParsed.OpenID)
+
+	case CommandWhere:
+		if q, ok := queues.Find(DmParsed.OpenID); ok {
+			SendWhereToWs(q.Name, DmParsed.OpenID)
 		}
 
-		lineTemp := Line{
-			OpenID:     openID,
-			UserName:   DmParsed.Uname,
-			Avatar:     DmParsed.UFace,
-			PrintColor: globalConfiguration.GuardPrintColor,
-			IsOnline:   true, // 默认设置为在线状态
+	case CommandNote:
+		if q, ok := queues.Find(DmParsed.OpenID); ok {
+			if err := q.Engine.SetNote(DmParsed.OpenID, Arg); err != nil {
+				slog.Error("修改备注失败", err, slog.String("OpenID", DmParsed.OpenID))
+			}
 		}
-		line.GuardLine = append(line.GuardLine, lineTemp)
-		line.GuardIndex[openID] = len(line.GuardLine)
-		SendLineToWs(lineTemp, GiftLine{}, GuardLineType)
-		SetLine(line)
-
-		// case DmParsed.GuardLevel <= 3 && DmParsed.GuardLevel != 0: // 舰长/提督
-		// 	lineTemp := Line{
-		// 		OpenID:     openID,
-		// 		UserName:   DmParsed.Uname,
-		// 		Avatar:     DmParsed.UFace,
-		// 		PrintColor: globalConfiguration.GuardPrintColor,
-		// 		IsOnline:   true, // 默认设置为在线状态
-		// 	}
-
-	case len(line.CommonLine) < globalConfiguration.MaxLineCount: // 普通用户
-		lineTemp := Line{
-			OpenID:     openID,
-			UserName:   DmParsed.Uname,
-			Avatar:     DmParsed.UFace,
-			PrintColor: globalConfiguration.CommonPrintColor,
-			IsOnline:   true, // 默认设置为在线状态
+
+	case CommandSong:
+		if Configuration().EnableMusicServer && Arg != "" {
+			SendMusicServer("search", Arg)
 		}
-		line.CommonLine = append(line.CommonLine, lineTemp)
-		line.CommonIndex[openID] = len(line.CommonLine)
-		SendLineToWs(lineTemp, GiftLine{}, CommonLineType)
-		SetLine(line)
+
+	case CommandConfirm:
+		rollCall.Confirm(DmParsed.OpenID)
+
+	case CommandAway, CommandBack:
+		if err := SetPresence(DmParsed.OpenID, Name == CommandBack); err != nil && err != ErrUserNotInLine {
+			slog.Error("修改在场状态失败", err, slog.String("OpenID", DmParsed.OpenID))
+		}
+	}
+}
+
+// ResponseGuard 处理大航海开通事件：记录流水、推送弹幕页面，按配置授予特殊用户并加入队列
+func ResponseGuard(GuardData *proto.CmdGuardData) {
+	openID := GuardData.UserInfo.OpenID
+	guardValue := float64(GuardData.Price*GuardData.GuardNum) / 100.0
+
+	if err := giftLedger.Record(GiftRecord{
+		Kind:       LedgerGuard,
+		OpenID:     openID,
+		UserName:   GuardData.UserInfo.Uname,
+		GiftName:   GuardLevelName(GuardData.GuardLevel),
+		Num:        GuardData.GuardNum,
+		Price:      guardValue,
+		Paid:       true,
+		GuardLevel: GuardData.GuardLevel,
+		GuardUnit:  GuardData.GuardUnit,
+	}); err != nil {
+		slog.Error("大航海流水写入失败", err)
+	}
+
+	SendDmEventToWs(DmEventGuard, GuardData)
+
+	if openID == "" {
+		return
+	}
+
+	// 特殊用户有效期从原到期时间或当前时间开始顺延
+	Config := Configuration()
+	if Config.GuardBuySpecialUser {
+		UpdateSpecialUsers(func(users map[string]SpecialUserStruct) {
+			start := time.Now()
+			if old, ok := users[openID]; ok && old.EndTime > start.Unix() {
+				start = time.Unix(old.EndTime, 0)
+			}
+			users[openID] = SpecialUserStruct{
+				EndTime:  start.Add(GuardDuration(GuardData.GuardNum, GuardData.GuardUnit)).Unix(),
+				UserName: GuardData.UserInfo.Uname,
+			}
+		})
+	}
+
+	if !Config.GuardBuyAutoJoin {
+		return
+	}
+	if _, banned := blacklist.Check(openID); banned {
+		return
+	}
+	// 已在队列或等候名单中的用户按新的大航海等级重新选择层级
+	q := queues.ForUser(openID)
+	if _, ok := q.Waitlist.Update(openID, func(User *Line) {
+		User.GuardLevel = GuardData.GuardLevel
+	}); ok {
+		PromoteWaitlist(q)
+		return
+	}
+	if q.Engine.Contains(openID) {
+		if _, err := q.Engine.Update(openID, func(User *Line) {
+			User.GuardLevel = GuardData.GuardLevel
+		}); err != nil {
+			slog.Error("大航海用户调整层级失败", err, slog.String("OpenID", openID))
+		}
+		return
+	}
+	lineTemp := Line{
+		OpenID:         openID,
+		UserName:       GuardData.UserInfo.Uname,
+		Avatar:         GuardData.UserInfo.Uface,
+		IsOnline:       true,
+		GuardLevel:     GuardData.GuardLevel,
+		FansMedalLevel: GuardData.FansMedalLevel,
+	}
+	tier := PickTier(lineTemp)
+	if err := CheckServeLimit(openID, tier); err != nil {
+		slog.Info("大航海用户暂不能重新排队", slog.String("UserName", GuardData.UserInfo.Uname), slog.String("reason", err.Error()))
+		return
+	}
+	lineTemp.PrintColor = TierColor(tier)
+	if err := JoinOrWait(q, tier, lineTemp); err != nil && !errors.Is(err, ErrUserInLine) {
+		slog.Error("大航海用户加入队列失败", err, slog.String("OpenID", openID))
+	}
+}
+
+// GuardDuration 根据开通数量和单位计算大航海时长，未知单位按月计算
+func GuardDuration(GuardNum int, GuardUnit string) time.Duration {
+	if GuardNum <= 0 {
+		GuardNum = 1
+	}
+	day := 24 * time.Hour
+	unit := 30 * day
+	switch {
+	case strings.Contains(GuardUnit, "年"):
+		unit = 365 * day
+	case strings.Contains(GuardUnit, "周"):
+		unit = 7 * day
+	case strings.Contains(GuardUnit, "天"), strings.Contains(GuardUnit, "日"):
+		unit = day
 	}
+	return time.Duration(GuardNum) * unit
 }
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/slog"
+)
+
+// DefaultQueueName 默认队列标识，使用 RunConfig 中的 LineKey 和 MaxLineCount
+const DefaultQueueName = "default"
+
+var (
+	ErrQueueNotFound = errors.New("queue not found")
+	ErrSameQueue     = errors.New("user already in target queue")
+	ErrQueueNotEmpty = errors.New("removed queue still has users")
+)
+
+var queueNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// QueueConfig 命名队列配置
+type QueueConfig struct {
+	// 队列标识，用于 /web?queue=
+	Name string
+	// 显示名称，为空时使用队列标识
+	Title string
+	// 排队关键词，分隔方式与 LineKey 相同
+	LineKey string
+	// 队列最大容量，0为不限
+	MaxLineCount int
+	// 弹幕排队资格要求
+	JoinRule JoinRule
+}
+
+// Queue 一个命名队列，每个队列有独立的关键词、容量、暂停状态和队列日志
+type Queue struct {
+	Name   string
+	Engine *LineEngine
+	// 队列或层级已满时的等候名单
+	Waitlist *Waitlist
+	paused   atomic.Bool
+	settings atomic.Pointer[queueSettings]
+}
+
+// queueSettings 队列的配置项，创建后不再修改，重新加载配置时整体替换
+type queueSettings struct {
+	title        string
+	keywords     []string
+	maxLineCount int
+	joinRule     JoinRule
+}
+
+// newQueueSettings 按队列配置创建配置项，名称为空时使用队列标识
+func newQueueSettings(qc QueueConfig) *queueSettings {
+	title := qc.Title
+	if title == "" {
+		title = qc.Name
+	}
+	return &queueSettings{
+		title:        title,
+		keywords:     ParseKeyWords(qc.LineKey),
+		maxLineCount: qc.MaxLineCount,
+		joinRule:     qc.JoinRule,
+	}
+}
+
+// Title 显示名称
+func (q *Queue) Title() string {
+	return q.settings.Load().title
+}
+
+// MaxLineCount 队列最大容量，0为不限
+func (q *Queue) MaxLineCount() int {
+	return q.settings.Load().maxLineCount
+}
+
+// JoinRule 弹幕排队资格要求
+func (q *Queue) JoinRule() JoinRule {
+	return q.settings.Load().joinRule
+}
+
+// Paused 是否暂停排队
+func (q *Queue) Paused() bool {
+	return q.paused.Load()
+}
+
+// SetPaused 设置暂停排队
+func (q *Queue) SetPaused(Paused bool) {
+	q.paused.Store(Paused)
+}
+
+// Match 弹幕是否为本队列的排队指令，Mode 为排队指令的匹配方式，指令参数作为备注返回
+// 多个关键词都匹配时选择最长的关键词，同样长时按配置顺序选择靠前的
+func (q *Queue) Match(Msg, Mode string) (string, bool) {
+	_, Note, ok := q.match(Msg, Mode)
+	return Note, ok
+}
+
+// match 同 Match，同时返回匹配到的关键词
+func (q *Queue) match(Msg, Mode string) (keyword, Note string, ok bool) {
+	for _, k := range q.settings.Load().keywords {
+		if len(k) <= len(keyword) {
+			continue
+		}
+		if n, matched := MatchTrigger(Msg, k, Mode); matched {
+			keyword, Note, ok = k, n, true
+		}
+	}
+	return keyword, Note, ok
+}
+
+// QueueManager 全部命名队列，第一个队列为默认队列
+type QueueManager struct {
+	mu     sync.RWMutex
+	queues []*Queue
+}
+
+var queues = &QueueManager{}
+
+// queueFiles 配置档案目录中的队列日志、快照目录和队列文件路径，默认队列沿用旧版文件名
+func queueFiles(Name string) (journal, snapshotDir, lineFile string) {
+	if Name == DefaultQueueName {
+		return DataPath(LineJournalFile), DataPath(LineSnapshotDir), DataPath(LineFile)
+	}
+	return DataPath("line-" + Name + ".journal"), DataPath(LineSnapshotDir + "-" + Name), DataPath("line-" + Name + ".json")
+}
+
+// openQueueEngine 加载队列日志并创建队列引擎，日志不可用时退回到队列文件
+func openQueueEngine(Name string) *LineEngine {
+	journalPath, snapshotDir, lineFile := queueFiles(Name)
+	journal := NewLineJournal(journalPath, snapshotDir, lineFile)
+	row, err := journal.Load()
+	if err != nil {
+		slog.Error("队列日志加载失败", err, slog.String("Queue", Name))
+		if row, err = GetLine(lineFile); err != nil && !os.IsNotExist(err) {
+			slog.Error("队列文件读取失败，可在备份恢复中选择备份", err, slog.String("path", lineFile))
+		}
+		journal = nil
+	}
+	return NewLineEngine(Name, row, journal)
+}
+
+// QueueConfigs 当前配置的全部队列，默认队列在最前
+func QueueConfigs(Config RunConfig) []QueueConfig {
+	res := []QueueConfig{{
+		Name:         DefaultQueueName,
+		Title:        "默认队列",
+		LineKey:      Config.LineKey,
+		MaxLineCount: Config.MaxLineCount,
+		JoinRule:     Config.JoinRule,
+	}}
+	return append(res, Config.Queues...)
+}
+
+// removed 配置中不再包含的队列，调用方需持有锁
+func (m *QueueManager) removed(Config RunConfig) []*Queue {
+	names := make(map[string]bool)
+	for _, qc := range QueueConfigs(Config) {
+		names[qc.Name] = true
+	}
+	var res []*Queue
+	for _, q := range m.queues {
+		if !names[q.Name] {
+			res = append(res, q)
+		}
+	}
+	return res
+}
+
+// CheckRemoval 配置移除的队列中仍有排队或等候的用户时返回错误，需要先清空或将用户转移到其他队列
+func (m *QueueManager) CheckRemoval(Config RunConfig) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, q := range m.removed(Config) {
+		if n := q.Engine.Len() + q.Waitlist.Len(); n > 0 {
+			return fmt.Errorf("%w: 队列 %s 中还有 %d 位用户，请先清空或转移到其他队列", ErrQueueNotEmpty, q.Name, n)
+		}
+	}
+	return nil
+}
+
+// Apply 按配置创建或更新队列，已存在的队列保留队列内容，配置中移除的队列写入快照后关闭队列日志
+// 移除前应先通过 CheckRemoval 确认队列为空
+func (m *QueueManager) Apply(Config RunConfig) {
+	m.mu.Lock()
+	closed := m.removed(Config)
+	old := make(map[string]*Queue, len(m.queues))
+	for _, q := range m.queues {
+		old[q.Name] = q
+	}
+	var res []*Queue
+	for _, qc := range QueueConfigs(Config) {
+		q, ok := old[qc.Name]
+		if !ok {
+			q = &Queue{Name: qc.Name, Engine: openQueueEngine(qc.Name), Waitlist: NewWaitlist()}
+		}
+		q.settings.Store(newQueueSettings(qc))
+		res = append(res, q)
+	}
+	m.queues = res
+	m.mu.Unlock()
+
+	for _, q := range closed {
+		q.Engine.Close()
+		slog.Info("队列已移除", slog.String("Queue", q.Name))
+	}
+	tiers := ActiveLineTiers()
+	for _, q := range res {
+		q.Engine.SyncTiers(tiers)
+	}
+}
+
+// All 全部队列，默认队列在最前
+func (m *QueueManager) All() []*Queue {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]*Queue{}, m.queues...)
+}
+
+// Default 默认队列，尚未加载配置时返回 nil
+func (m *QueueManager) Default() *Queue {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.queues) == 0 {
+		return nil
+	}
+	return m.queues[0]
+}
+
+// Get 按标识查找队列，标识为空时返回默认队列
+func (m *QueueManager) Get(Name string) (*Queue, error) {
+	if Name == "" {
+		Name = DefaultQueueName
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, q := range m.queues {
+		if q.Name == Name {
+			return q, nil
+		}
+	}
+	return nil, ErrQueueNotFound
+}
+
+// Lookup 按队列标识或名称查找队列，为空时返回默认队列
+func (m *QueueManager) Lookup(NameOrTitle string) (*Queue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if NameOrTitle == "" && len(m.queues) > 0 {
+		return m.queues[0], nil
+	}
+	for _, q := range m.queues {
+		if q.Name == NameOrTitle || q.Title() == NameOrTitle {
+			return q, nil
+		}
+	}
+	return nil, ErrQueueNotFound
+}
+
+// FindByName 按用户名查找排队中的用户，同名时返回靠前队列中的第一个
+func (m *QueueManager) FindByName(UserName string) (*Queue, Line, bool) {
+	if UserName == "" {
+		return nil, Line{}, false
+	}
+	for _, q := range m.All() {
+		for _, tier := range q.Engine.Snapshot().Tiers {
+			for _, User := range tier.Users {
+				if User.UserName == UserName {
+					return q, User, true
+				}
+			}
+		}
+	}
+	return nil, Line{}, false
+}
+
+// Match 按排队关键词选择队列并返回备注，多个关键词都匹配时选择最长的关键词，如 "排队2" 优先于 "排队"
+// 多个队列使用同一关键词时选择靠前的队列
+func (m *QueueManager) Match(Msg, Mode string) (*Queue, string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var (
+		res     *Queue
+		Note    string
+		longest string
+	)
+	for _, q := range m.queues {
+		if keyword, n, ok := q.match(Msg, Mode); ok && len(keyword) > len(longest) {
+			res, Note, longest = q, n, keyword
+		}
+	}
+	return res, Note, res != nil
+}
+
+// Find 查找用户所在的队列，同一用户同时只会在一个队列中
+func (m *QueueManager) Find(OpenID string) (*Queue, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, q := range m.queues {
+		if q.Engine.Contains(OpenID) {
+			return q, true
+		}
+	}
+	return nil, false
+}
+
+// FindWaiting 用户所在的等候名单
+func (m *QueueManager) FindWaiting(OpenID string) (*Queue, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, q := range m.queues {
+		if q.Waitlist.Contains(OpenID) {
+			return q, true
+		}
+	}
+	return nil, false
+}
+
+// ForUser 用户所在或等候的队列，都不在时返回默认队列，用于礼物、醒目留言等不带关键词的入队
+func (m *QueueManager) ForUser(OpenID string) *Queue {
+	if q, ok := m.Find(OpenID); ok {
+		return q
+	}
+	if q, ok := m.FindWaiting(OpenID); ok {
+		return q
+	}
+	return m.Default()
+}
+
+// Contains 用户是否在任意队列中
+func (m *QueueManager) Contains(OpenID string) bool {
+	_, ok := m.Find(OpenID)
+	return ok
+}
+
+// SyncTiers 按层级配置调整全部队列
+func (m *QueueManager) SyncTiers(tiers []LineTier) {
+	for _, q := range m.All() {
+		q.Engine.SyncTiers(tiers)
+	}
+}
+
+// ParseQueueConfigs 解析每行一条 "标识|名称|关键词|容量|粉丝牌等级|大航海等级" 的命名队列配置
+// 名称可留空，容量及之后的字段可省略，粉丝牌等级填0表示只要求佩戴本直播间粉丝牌
+func ParseQueueConfigs(s string) ([]QueueConfig, error) {
+	var res []QueueConfig
+	names := map[string]bool{DefaultQueueName: true}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("队列配置格式错误：%s", line)
+		}
+		qc := QueueConfig{Name: fields[0], Title: fields[1], LineKey: fields[2]}
+		switch {
+		case !queueNameRegexp.MatchString(qc.Name):
+			return nil, fmt.Errorf("队列标识只能包含字母、数字、下划线和减号：%s", qc.Name)
+		case names[qc.Name]:
+			return nil, fmt.Errorf("队列标识重复：%s", qc.Name)
+		case len(ParseKeyWords(qc.LineKey)) == 0:
+			return nil, fmt.Errorf("队列 %s 没有排队关键词", qc.Name)
+		}
+		if len(fields) > 3 && fields[3] != "" {
+			count, err := strconv.Atoi(fields[3])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("队列 %s 的容量无效：%s", qc.Name, fields[3])
+			}
+			qc.MaxLineCount = count
+		}
+		var Medal, Guard string
+		if len(fields) > 4 {
+			Medal = fields[4]
+		}
+		if len(fields) > 5 {
+			Guard = fields[5]
+		}
+		rule, err := parseJoinRule(qc.Name, Medal, Guard)
+		if err != nil {
+			return nil, err
+		}
+		qc.JoinRule = rule
+		names[qc.Name] = true
+		res = append(res, qc)
+	}
+	return res, nil
+}
+
+// FormatQueueConfigs 将命名队列配置格式化为每行一条 "标识|名称|关键词|容量|粉丝牌等级|大航海等级"
+func FormatQueueConfigs(configs []QueueConfig) string {
+	lines := make([]string, 0, len(configs))
+	for _, qc := range configs {
+		fields := []string{qc.Name, qc.Title, qc.LineKey, strconv.Itoa(qc.MaxLineCount)}
+		if Medal, Guard := formatJoinRule(qc.JoinRule); Medal != "" || Guard != "" {
+			fields = append(fields, Medal, Guard)
+		}
+		lines = append(lines, strings.Join(fields, "|"))
+	}
+	return strings.Join(lines, "\n")
+}
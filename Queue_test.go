@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// useTempProfile 将配置档案目录指向临时目录，队列文件和日志写入其中
+func useTempProfile(t *testing.T) {
+	t.Helper()
+	old := ProfileDir
+	ProfileDir = t.TempDir()
+	t.Cleanup(func() { ProfileDir = old })
+}
+
+func TestQueueManagerRemoval(t *testing.T) {
+	useTempProfile(t)
+	withSong := ConfigWithDefaults(RunConfig{IdCode: "ABCDEF"})
+	withSong.Queues = []QueueConfig{{Name: "song", LineKey: "点歌"}}
+	withoutSong := withSong
+	withoutSong.Queues = nil
+
+	m := &QueueManager{}
+	m.Apply(withSong)
+	song, err := m.Get("song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	User := Line{OpenID: "user", UserName: "user"}
+	if err = song.Engine.Join(0, User, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		prepare func()
+		wantErr error
+	}{
+		{name: "队列中还有用户", wantErr: ErrQueueNotEmpty},
+		{
+			name: "等候名单中还有用户",
+			prepare: func() {
+				_ = song.Engine.Remove(User.OpenID)
+				if _, err := song.Waitlist.Add(0, User); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrQueueNotEmpty,
+		},
+		{
+			name:    "队列为空",
+			prepare: func() { song.Waitlist.Remove(User.OpenID) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+			if err := m.CheckRemoval(withoutSong); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 %v，应为 %v", err, tt.wantErr)
+			}
+			if err := m.CheckRemoval(withSong); err != nil {
+				t.Fatalf("未移除队列时不应返回错误：%v", err)
+			}
+		})
+	}
+
+	m.Apply(withoutSong)
+	if _, err = m.Get("song"); !errors.Is(err, ErrQueueNotFound) {
+		t.Fatalf("移除后查找队列错误 %v，应为 %v", err, ErrQueueNotFound)
+	}
+	if song.Engine.journal != nil {
+		t.Error("移除的队列应关闭队列日志")
+	}
+
+	// 重新添加时重新加载队列日志，不与已关闭的引擎共用
+	m.Apply(withSong)
+	readded, err := m.Get("song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readded == song || readded.Engine.journal == nil {
+		t.Error("重新添加的队列应使用新的队列引擎和日志")
+	}
+	if n := readded.Engine.Len(); n != 0 {
+		t.Errorf("重新添加的队列有 %d 位用户，应为空", n)
+	}
+	for _, q := range m.All() {
+		q.Engine.Close()
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// 叫号确认超时的处理方式
+const (
+	// RollCallSkip 后移 SkipBy 位，后移后仍在队首(后面没有其他人)时移出队列
+	RollCallSkip = "skip"
+	// RollCallRemove 移出队列
+	RollCallRemove = "remove"
+)
+
+// 超时处理方式的显示名称，与 rollCallPolicyValues 一一对应
+var rollCallPolicyNames = []string{"超时后移", "超时移出队列"}
+var rollCallPolicyValues = []string{RollCallSkip, RollCallRemove}
+
+// RollCallInterval 检查队首变化和确认超时的间隔
+const RollCallInterval = time.Second
+
+// RollCallConfig 叫号确认配置
+type RollCallConfig struct {
+	Enabled bool
+	// 确认时间(秒)
+	Timeout int
+	// 超时处理方式，见 RollCall* 常量
+	Policy string
+	// 超时后移的位数
+	SkipBy int
+}
+
+// DefaultRollCallConfig 默认叫号确认配置，默认关闭
+func DefaultRollCallConfig() RollCallConfig {
+	return RollCallConfig{Timeout: 60, Policy: RollCallSkip, SkipBy: 3}
+}
+
+// RollCallState 队列当前的叫号确认状态
+type RollCallState struct {
+	OpenID    string
+	UserName  string
+	Deadline  time.Time
+	Confirmed bool
+}
+
+// Remaining 剩余确认时间，已确认或已超时为0
+func (s RollCallState) Remaining(Now time.Time) time.Duration {
+	if s.Confirmed || !Now.Before(s.Deadline) {
+		return 0
+	}
+	return s.Deadline.Sub(Now)
+}
+
+// RollCall 叫号确认，用户成为队首时开始计时，按队列标识保存
+type RollCall struct {
+	mu    sync.Mutex
+	calls map[string]RollCallState
+}
+
+var rollCall = NewRollCall()
+
+func NewRollCall() *RollCall {
+	return &RollCall{calls: make(map[string]RollCallState)}
+}
+
+// State 队列当前的叫号确认状态
+func (r *RollCall) State(Queue string) (RollCallState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.calls[Queue]
+	return s, ok
+}
+
+// Confirm 被叫用户确认到场，用户不是任何队列的被叫用户时返回 false
+func (r *RollCall) Confirm(OpenID string) bool {
+	r.mu.Lock()
+	var confirmed []string
+	for Queue, s := range r.calls {
+		if s.OpenID == OpenID && !s.Confirmed && time.Now().Before(s.Deadline) {
+			s.Confirmed = true
+			r.calls[Queue] = s
+			confirmed = append(confirmed, Queue)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, Queue := range confirmed {
+		q, err := queues.Get(Queue)
+		if err != nil {
+			continue
+		}
+		if le, ok := q.Engine.Entry(OpenID); ok {
+			SendCallToWs(Queue, le.LineType, le.Line, 0)
+		}
+	}
+	return len(confirmed) > 0
+}
+
+// Tick 队首变化时开始新的叫号确认，超时未确认的按配置后移或移出
+func (r *RollCall) Tick(Now time.Time, Config RollCallConfig) {
+	if !Config.Enabled || Config.Timeout <= 0 {
+		r.mu.Lock()
+		r.calls = make(map[string]RollCallState)
+		r.mu.Unlock()
+		return
+	}
+	for _, q := range queues.All() {
+		first, ok := q.Engine.First()
+
+		r.mu.Lock()
+		cur, has := r.calls[q.Name]
+		switch {
+		case !ok:
+			delete(r.calls, q.Name)
+			r.mu.Unlock()
+
+		case has && cur.OpenID == first.Line.OpenID:
+			if cur.Remaining(Now) > 0 || cur.Confirmed {
+				r.mu.Unlock()
+				continue
+			}
+			delete(r.calls, q.Name)
+			r.mu.Unlock()
+			r.expire(q, cur, Config)
+
+		default:
+			Deadline := Now.Add(time.Duration(Config.Timeout) * time.Second)
+			r.calls[q.Name] = RollCallState{OpenID: first.Line.OpenID, UserName: first.Line.UserName, Deadline: Deadline}
+			r.mu.Unlock()
+			SendCallToWs(q.Name, first.LineType, first.Line, Deadline.UnixMilli())
+		}
+	}
+}
+
+// expire 超时未确认，按整个队列的顺序后移，跨越层级；后面没有其他用户时移出队列
+func (r *RollCall) expire(q *Queue, s RollCallState, Config RollCallConfig) {
+	slog.Info("叫号确认超时", slog.String("Queue", q.Name), slog.String("UserName", s.UserName), slog.String("Policy", Config.Policy))
+	if Config.Policy == RollCallSkip && Config.SkipBy > 0 {
+		moved, err := q.Engine.Skip(s.OpenID, Config.SkipBy)
+		if err != nil {
+			if err != ErrUserNotInLine {
+				slog.Error("叫号确认超时后移失败", err, slog.String("OpenID", s.OpenID))
+			}
+			return
+		}
+		if moved {
+			return
+		}
+	}
+	if err := DeleteLine(s.OpenID); err != nil && err != ErrUserNotInLine {
+		slog.Error("叫号确认超时移出失败", err, slog.String("OpenID", s.OpenID))
+	}
+}
+
+// RunRollCall 定期检查各队列的叫号确认，配置每次按当前配置读取
+func RunRollCall() {
+	tk := time.NewTicker(RollCallInterval)
+	defer tk.Stop()
+	for now := range tk.C {
+		rollCall.Tick(now, Configuration().RollCall)
+	}
+}
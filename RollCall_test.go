@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// joinLayout 按层级依次加入用户，颜色使用层级配置
+func joinLayout(t *testing.T, e *LineEngine, layout [][]string) {
+	t.Helper()
+	for tier, ids := range layout {
+		for _, OpenID := range ids {
+			if err := e.Join(tier, Line{OpenID: OpenID, UserName: OpenID, PrintColor: TierColor(tier)}, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestLineEngineSkip(t *testing.T) {
+	layout := [][]string{{"a", "b"}, {"c"}, {"d", "e"}}
+	tests := []struct {
+		name      string
+		layout    [][]string
+		openID    string
+		by        int
+		wantMoved bool
+		wantErr   error
+		want      string
+	}{
+		{name: "层级内后移", openID: "a", by: 1, wantMoved: true, want: "[[b a] [c] [d e]]"},
+		{name: "越过层级末尾移到下一层级", openID: "a", by: 2, wantMoved: true, want: "[[b] [c a] [d e]]"},
+		{name: "跨越多个层级", openID: "a", by: 3, wantMoved: true, want: "[[b] [c] [d a e]]"},
+		{name: "层级末尾的用户移到下一层级", openID: "b", by: 1, wantMoved: true, want: "[[a] [c b] [d e]]"},
+		{name: "后移位数超过剩余人数时移到最后", openID: "a", by: 10, wantMoved: true, want: "[[b] [c] [d e a]]"},
+		{name: "最后一个层级内后移", openID: "d", by: 1, wantMoved: true, want: "[[a b] [c] [e d]]"},
+		{name: "已在最后不移动", openID: "e", by: 1, want: "[[a b] [c] [d e]]"},
+		{name: "后移0位不移动", openID: "a", by: 0, want: "[[a b] [c] [d e]]"},
+		{name: "只有一人时不移动", layout: [][]string{{}, {"a"}, {}}, openID: "a", by: 3, want: "[[] [a] []]"},
+		{name: "中间层级为空", layout: [][]string{{"a"}, {}, {"b"}}, openID: "a", by: 1, wantMoved: true, want: "[[] [] [b a]]"},
+		{name: "不在队列中", openID: "x", by: 1, wantErr: ErrUserNotInLine, want: "[[a b] [c] [d e]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			if tt.layout == nil {
+				tt.layout = layout
+			}
+			joinLayout(t, e, tt.layout)
+
+			moved, err := e.Skip(tt.openID, tt.by)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误 %v，应为 %v", err, tt.wantErr)
+			}
+			if moved != tt.wantMoved {
+				t.Errorf("移动 %t，应为 %t", moved, tt.wantMoved)
+			}
+			if got := fmt.Sprint(tierIDs(e)); got != tt.want {
+				t.Errorf("队列为 %s，应为 %s", got, tt.want)
+			}
+			if le, ok := e.Entry(tt.openID); ok && le.Line.PrintColor != engineTestTiers[le.LineType].Color {
+				t.Errorf("后移后颜色 %+v，应使用所在层级的颜色", le.Line.PrintColor)
+			}
+			checkEngine(t, e)
+		})
+	}
+}
+
+// TestRollCallExpire 队首超时未确认时按整个队列后移，后面没有其他人时移出
+func TestRollCallExpire(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  [][]string
+		policy  string
+		confirm bool
+		want    string
+	}{
+		{name: "跨层级后移", layout: [][]string{{"a"}, {"b"}, {"c"}}, policy: RollCallSkip, want: "[[] [b] [c a]]"},
+		{name: "后面没有其他人时移出", layout: [][]string{{"a"}, {}, {}}, policy: RollCallSkip, want: "[[] [] []]"},
+		{name: "超时移出", layout: [][]string{{"a"}, {"b"}, {}}, policy: RollCallRemove, want: "[[] [b] []]"},
+		{name: "已确认不处理", layout: [][]string{{"a"}, {"b"}, {}}, policy: RollCallSkip, confirm: true, want: "[[a] [b] []]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config := RollCallConfig{Enabled: true, Timeout: 60, Policy: tt.policy, SkipBy: 2}
+			e := useTestQueues(t, RunConfig{IdCode: "ABCDEF", LineTiers: engineTestTiers, RollCall: Config}).Engine
+			joinLayout(t, e, tt.layout)
+
+			r := NewRollCall()
+			now := time.Now()
+			r.Tick(now, Config)
+			if s, ok := r.State(DefaultQueueName); !ok || s.OpenID != "a" {
+				t.Fatalf("叫号状态 %+v，应为队首 a", s)
+			}
+			if tt.confirm && !r.Confirm("a") {
+				t.Fatal("确认失败")
+			}
+			r.Tick(now.Add(59*time.Second), Config)
+			if got := fmt.Sprint(tierIDs(e)); got != fmt.Sprint(tt.layout) {
+				t.Fatalf("未超时时队列为 %s", got)
+			}
+			r.Tick(now.Add(60*time.Second), Config)
+			if got := fmt.Sprint(tierIDs(e)); got != tt.want {
+				t.Errorf("超时后队列为 %s，应为 %s", got, tt.want)
+			}
+		})
+	}
+}
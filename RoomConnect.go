@@ -3,8 +3,7 @@ package main
 import (
 	"fmt"
 	"regexp"
-	"sort"
-	"sync"
+	"sync/atomic"
 
 	"golang.org/x/exp/slog"
 
@@ -13,10 +12,6 @@ import (
 	"github.com/vtb-link/bianka/proto"
 )
 
-var (
-	lineMu sync.RWMutex // 添加互斥锁保护共享数据
-)
-
 func messageHandle(ws *basic.WsClient, msg *proto.Message) error {
 	cmd, data, err := proto.AutomaticParsingMessageCommand(msg.Payload())
 	if err != nil {
@@ -27,82 +22,136 @@ func messageHandle(ws *basic.WsClient, msg *proto.Message) error {
 	case proto.CmdLiveOpenPlatformDanmu:
 		DanmuData := data.(*proto.CmdDanmuData)
 		slog.Info(DanmuData.Uname, DanmuData.Msg)
+		presence.Touch(DanmuData.OpenID)
 		ResponseQueCtrl(DanmuData)
 
 	case proto.CmdLiveOpenPlatformSendGift:
 		GiftData := data.(*proto.CmdSendGiftData)
+		presence.Touch(GiftData.OpenID)
 		fmt.Printf("检测到礼物：%v  礼物价值(电池)：%v 礼物数量：%v 是否为付费：%v \n",
 			GiftData.GiftName, GiftData.Price, GiftData.GiftNum, GiftData.Paid)
 
-		if !globalConfiguration.AutoJoinGiftLine {
+		giftValue := float64(GiftData.Price*GiftData.GiftNum) / 100.0 // 修改处：除以100
+
+		if err := giftLedger.Record(GiftRecord{
+			Kind:     LedgerGift,
+			OpenID:   GiftData.OpenID,
+			UserName: GiftData.Uname,
+			GiftID:   GiftData.GiftID,
+			GiftName: GiftData.GiftName,
+			Num:      GiftData.GiftNum,
+			Price:    giftValue,
+			Paid:     GiftData.Paid,
+		}); err != nil {
+			slog.Error("礼物流水写入失败", err)
+		}
+
+		Config := Configuration()
+		if !Config.AutoJoinGiftLine {
+			break
+		}
+		// 黑名单用户的礼物只记录流水，不加入队列
+		if entry, banned := blacklist.Check(GiftData.OpenID); banned {
+			slog.Info("黑名单用户送礼不加入队列", slog.String("UserName", GiftData.Uname), slog.String("reason", entry.Reason))
 			break
 		}
 
-		//如果不是付费礼物则不执行以下代码。
-		if !GiftData.Paid {
+		// 按礼物规则计算价值，未计入的礼物不影响队列
+		decision := giftRules.Evaluate(Config.GiftRules, Config.GiftLinePrice, GiftInput{
+			OpenID: GiftData.OpenID,
+			GiftID: GiftData.GiftID,
+			Price:  GiftData.Price,
+			Num:    GiftData.GiftNum,
+			Paid:   GiftData.Paid,
+		})
+		if !decision.Counted {
+			slog.Info("礼物不计入队列", slog.String("GiftName", GiftData.GiftName), slog.String("reason", decision.Reason))
 			break
 		}
 
-		lineMu.Lock() // 加锁保护共享数据
-		defer lineMu.Unlock()
-
-		//检测送礼用户在不在排队列表，在的话就删除排队列表的数据添加到礼物列表
-		if idx, exists := line.CommonIndex[GiftData.OpenID]; exists && idx > 0 {
-			// 确保索引有效
-			if idx <= len(line.CommonLine) {
-				// 从CommonLine删除
-				line.CommonLine = append(line.CommonLine[:idx-1], line.CommonLine[idx:]...)
-
-				// 重建索引
-				delete(line.CommonIndex, GiftData.OpenID)
-				for i, user := range line.CommonLine {
-					line.CommonIndex[user.OpenID] = i + 1
-				}
-			} else {
-				// 索引无效时清除错误索引
-				delete(line.CommonIndex, GiftData.OpenID)
+		// 已有礼物价值的用户直接累计，其余用户达到门槛时以本场累计价值计入
+		giftPrice := decision.Value
+		q := queues.ForUser(GiftData.OpenID)
+		le, inLine := q.Engine.Entry(GiftData.OpenID)
+		if waiting, ok := q.Waitlist.Get(GiftData.OpenID); ok {
+			le, inLine = LineEntry{LineType: waiting.Tier, Line: waiting.Line}, true
+		}
+		if !inLine || le.Line.GiftPrice <= 0 {
+			if !decision.Join {
+				slog.Info("礼物未达到入队门槛", slog.String("UserName", GiftData.Uname), slog.Float64("Total", decision.Total))
+				break
 			}
+			giftPrice = decision.Total
 		}
 
-		giftValue := float64(GiftData.Price*GiftData.GiftNum) / 100.0 // 修改处：除以100
-
-		if idx, exists := line.GiftIndex[GiftData.OpenID]; exists {
-			line.GiftLine[idx-1].GiftPrice += giftValue
-			fmt.Printf("目前用户：%v 累计礼物价值为：%v \n", GiftData.Uname, line.GiftLine[idx-1].GiftPrice)
-		} else {
-			lineTemp := GiftLine{
-				OpenID:     GiftData.OpenID,
-				UserName:   GiftData.Uname,
-				Avatar:     GiftData.Uface,
-				PrintColor: globalConfiguration.GiftPrintColor,
-				GiftPrice:  giftValue,
-				IsOnline:   true,
-				GiftName:   GiftData.GiftName,
+		giftLine := Line{
+			OpenID:    GiftData.OpenID,
+			UserName:  GiftData.Uname,
+			Avatar:    GiftData.Uface,
+			GiftPrice: giftPrice,
+			IsOnline:  true,
+			GiftName:  GiftData.GiftName,
+		}
+		// 不在队列中的用户按将要进入的层级检查重新排队限制
+		if !inLine {
+			if err := CheckServeLimit(GiftData.OpenID, PickTier(giftLine)); err != nil {
+				slog.Info("礼物用户暂不能重新排队", slog.String("UserName", GiftData.Uname), slog.String("reason", err.Error()))
+				break
 			}
-			line.GiftLine = append(line.GiftLine, lineTemp)
 		}
 
-		// 按礼物价值降序排序
-		sort.SliceStable(line.GiftLine, func(i, j int) bool {
-			return line.GiftLine[i].GiftPrice > line.GiftLine[j].GiftPrice
-		})
-
-		// 重建索引确保一致性
-		line.GiftIndex = make(map[string]int)
-		for i, item := range line.GiftLine {
-			line.GiftIndex[item.OpenID] = i + 1
+		//送礼用户累计礼物价值后按层级条件重新排队，层级已满时进入等候名单
+		updated, err := AddGiftOrWait(q, giftLine)
+		if err != nil {
+			slog.Error("礼物队列更新失败", err)
+			break
 		}
+		fmt.Printf("目前用户：%v 累计礼物价值为：%v \n", updated.UserName, updated.GiftPrice)
 
-		// 发送更新到WS并保存状态
-		if len(line.GiftLine) > 0 && line.GiftIndex[GiftData.OpenID] > 0 {
-			SendLineToWs(Line{}, line.GiftLine[line.GiftIndex[GiftData.OpenID]-1], GiftLineType)
-		}
-		SetLine(line)
+	case proto.CmdLiveOpenPlatformSuperChat:
+		ScData := data.(*proto.CmdSuperChatData)
+		presence.Touch(ScData.OpenID)
+		slog.Info("检测到醒目留言", slog.String("UserName", ScData.Uname), slog.Int("Rmb", ScData.Rmb), slog.String("Message", ScData.Message))
+		ResponseSuperChat(ScData)
+
+	case proto.CmdLiveOpenPlatformSuperChatDel:
+		ResponseSuperChatDel(data.(*proto.CmdSuperChatDelData))
+
+	case proto.CmdLiveOpenPlatformGuard:
+		GuardData := data.(*proto.CmdGuardData)
+		presence.Touch(GuardData.UserInfo.OpenID)
+		slog.Info("检测到大航海", slog.String("UserName", GuardData.UserInfo.Uname), slog.String("GuardLevel", GuardLevelName(GuardData.GuardLevel)),
+			slog.Int("GuardNum", GuardData.GuardNum), slog.String("GuardUnit", GuardData.GuardUnit))
+		ResponseGuard(GuardData)
+
+	// 点赞和进入直播间只用于判断在场状态
+	case proto.CmdLiveOpenPlatformLike:
+		presence.Touch(data.(*proto.CmdLikeData).OpenID)
+
+	case proto.CmdLiveOpenPlatformRoomEnter:
+		presence.Touch(data.(*proto.CmdLiveRoomEnterData).OpenID)
+
+	case proto.CmdLiveOpenPlatformLiveStart:
+		StartLiveSession(data.(*proto.CmdLiveStartData).Timestamp)
 	}
 
 	return nil
 }
 
+// liveStartTime 最近一次开播的时间戳，重连后重复收到同一次开播消息时不重复清空
+var liveStartTime atomic.Int64
+
+// StartLiveSession 直播间开播时开始新的一场直播，清空累计礼物价值和叫号记录
+// 断线重连、重新连接弹幕服务器和修改配置都不算新的一场直播
+func StartLiveSession(Timestamp int64) {
+	if liveStartTime.Swap(Timestamp) == Timestamp {
+		return
+	}
+	giftRules.ResetSession()
+	serveHistory.ResetSession()
+	slog.Info("直播开始，重新统计累计礼物价值和叫号次数", slog.Int64("Timestamp", Timestamp))
+}
+
 var (
 	AccessSecret        = "AccessSecret"
 	AppID         int64 = 123456789
@@ -110,7 +159,8 @@ var (
 	CurrentIdCode string
 )
 
-func RoomConnect(IdCode string) (AppClient *live.Client, GameId string, WsClient *basic.WsClient, HeartbeatCloseChan chan bool) {
+// RoomConnect 使用身份码开启应用并连接弹幕服务器，失败时返回错误，由调用方决定是否重试
+func RoomConnect(IdCode string) (AppClient *live.Client, GameId string, WsClient *basic.WsClient, HeartbeatCloseChan chan bool, err error) {
 	//	初始化应用连接信息配置，自编译请申明以下3个值
 	LinkConfig := live.NewConfig(AccessKey, AccessSecret, AppID)
 
@@ -119,22 +169,20 @@ func RoomConnect(IdCode string) (AppClient *live.Client, GameId string, WsClient
 	//	开始身份码认证流程
 
 	AppStart, err := client.AppStart(IdCode)
-	RoomId = AppStart.AnchorInfo.RoomID
 	if err != nil {
 		slog.Error("应用流程开启失败", err)
-		return nil, "", nil, nil
+		return nil, "", nil, nil, err
 	}
-	// 开启心跳
-	HeartbeatCloseChan = make(chan bool, 1)
-	NewHeartbeat(client, AppStart.GameInfo.GameID, HeartbeatCloseChan)
+	RoomId = AppStart.AnchorInfo.RoomID
+	AnchorUid = AppStart.AnchorInfo.Uid
 
 	dispatcherHandleMap := basic.DispatcherHandleMap{
 		proto.OperationMessage: messageHandle,
 	}
 	onCloseCallback := func(wcs *basic.WsClient, startResp basic.StartResp, closeType int) {
 		slog.Info("WebsocketClient onClose", startResp)
-		// 注意检查关闭类型, 避免无限重连
-		if closeType == live.CloseReceivedShutdownMessage || closeType == live.CloseAuthFailed {
+		// 注意检查关闭类型, 避免无限重连，身份码变化时主动关闭的连接也不重连
+		if closeType == live.CloseReceivedShutdownMessage || closeType == live.CloseAuthFailed || closeType == live.CloseActively {
 			slog.Info("WebsocketClient exit")
 			return
 		}
@@ -146,17 +194,31 @@ func RoomConnect(IdCode string) (AppClient *live.Client, GameId string, WsClient
 	// 一键开启websocket
 	wsClient, err := basic.StartWebsocket(AppStart, dispatcherHandleMap, onCloseCallback, logger)
 	if err != nil {
-		panic(err)
+		slog.Error("弹幕服务器连接失败", err)
+		// 关闭已开启的应用，避免身份码被占用到超时
+		if endErr := client.AppEnd(AppStart.GameInfo.GameID); endErr != nil {
+			slog.Error("应用流程关闭失败", endErr)
+		}
+		return nil, "", nil, nil, err
 	}
-	return client, AppStart.GameInfo.GameID, wsClient, HeartbeatCloseChan
-}
 
-var KeyWordMatchMap = make(map[string]bool)
+	// 开启心跳
+	HeartbeatCloseChan = make(chan bool, 1)
+	NewHeartbeat(client, AppStart.GameInfo.GameID, HeartbeatCloseChan)
+	return client, AppStart.GameInfo.GameID, wsClient, HeartbeatCloseChan, nil
+}
 
-func KeyWordMatchInit(keyWord string) {
+// ParseKeyWords 解析排队关键词，以常见标点分隔，按填写顺序返回，重复的关键词只保留第一个
+func ParseKeyWords(keyWord string) []string {
+	var res []string
+	seen := make(map[string]bool)
 	reg := regexp.MustCompile(`[^.,!！；：’"'"?？;:，。、-]+`)
 	matches := reg.FindAllString(keyWord, -1)
 	for _, match := range matches {
-		KeyWordMatchMap[match] = true
+		if !seen[match] {
+			seen[match] = true
+			res = append(res, match)
+		}
 	}
+	return res
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 叫号记录类型
+const (
+	// ServeServed 被主播叫号或删除，计入本场叫号次数
+	ServeServed = "served"
+	// ServeRemoved 用户自行取消排队，只影响冷却
+	ServeRemoved = "removed"
+)
+
+var (
+	ErrRejoinCooldown = errors.New("user is in rejoin cooldown")
+	ErrServeQuota     = errors.New("user reached serve quota for this session")
+)
+
+// ServeRuleConfig 重新排队限制
+type ServeRuleConfig struct {
+	// 被叫号或移出队列后再次排队的冷却时间(秒)，0为不限
+	RejoinCooldown int
+	// 每场直播每个用户最多被叫号的次数，0为不限
+	MaxServePerSession int
+	// 默认层级中的舰长、礼物层级不受限制，自定义层级按层级的 IgnoreServeLimit
+	ExemptPaidTiers bool
+}
+
+// ServeRecord 一条叫号记录，Time 为秒级时间戳
+type ServeRecord struct {
+	Time     int64
+	OpenID   string
+	UserName string
+	Queue    string
+	Kind     string
+}
+
+// ServeHistory 本场直播的叫号记录，冷却和次数限制按记录判断
+type ServeHistory struct {
+	mu      sync.Mutex
+	records []ServeRecord
+}
+
+var serveHistory = NewServeHistory()
+
+func NewServeHistory() *ServeHistory {
+	return &ServeHistory{}
+}
+
+// ResetSession 开始新的一场直播，清空叫号记录
+func (h *ServeHistory) ResetSession() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = nil
+}
+
+// Record 追加一条叫号记录
+func (h *ServeHistory) Record(Queue string, User Line, Kind string) ServeRecord {
+	rec := ServeRecord{
+		Time:     time.Now().Unix(),
+		OpenID:   User.OpenID,
+		UserName: User.UserName,
+		Queue:    Queue,
+		Kind:     Kind,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, rec)
+	return rec
+}
+
+// Forget 删除一条叫号记录，用于撤销删除操作
+func (h *ServeHistory) Forget(rec ServeRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.records[i] == rec {
+			h.records = append(h.records[:i], h.records[i+1:]...)
+			return
+		}
+	}
+}
+
+// Check 按规则判断用户能否再次排队
+func (h *ServeHistory) Check(Rules ServeRuleConfig, OpenID string, Now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var last int64
+	served := 0
+	for _, rec := range h.records {
+		if rec.OpenID != OpenID {
+			continue
+		}
+		last = rec.Time
+		if rec.Kind == ServeServed {
+			served++
+		}
+	}
+	switch {
+	case Rules.MaxServePerSession > 0 && served >= Rules.MaxServePerSession:
+		return ErrServeQuota
+	case Rules.RejoinCooldown > 0 && last > 0 && Now.Unix()-last < int64(Rules.RejoinCooldown):
+		return ErrRejoinCooldown
+	}
+	return nil
+}
+
+// History 本场叫号记录，最新的在前
+func (h *ServeHistory) History() []ServeRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	res := make([]ServeRecord, len(h.records))
+	for i, rec := range h.records {
+		res[len(h.records)-1-i] = rec
+	}
+	return res
+}
+
+// CheckServeLimit 用户进入指定层级前检查冷却和叫号次数，层级不受限制时总是允许
+func CheckServeLimit(OpenID string, Tier int) error {
+	tiers := ActiveLineTiers()
+	if Tier >= 0 && Tier < len(tiers) && tiers[Tier].IgnoreServeLimit {
+		return nil
+	}
+	return serveHistory.Check(Configuration().ServeRules, OpenID, time.Now())
+}
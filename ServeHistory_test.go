@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServeHistoryCheck(t *testing.T) {
+	User := Line{OpenID: "user", UserName: "user"}
+	other := Line{OpenID: "other", UserName: "other"}
+	tests := []struct {
+		name    string
+		rules   ServeRuleConfig
+		records []string // 依次写入的用户记录类型
+		others  int      // 其他用户的叫号记录数
+		after   int64    // 最后一条记录之后经过的秒数
+		wantErr error
+	}{
+		{name: "没有记录", rules: ServeRuleConfig{RejoinCooldown: 60, MaxServePerSession: 1}},
+		{name: "冷却中", rules: ServeRuleConfig{RejoinCooldown: 60}, records: []string{ServeServed}, after: 59, wantErr: ErrRejoinCooldown},
+		{name: "冷却刚好结束", rules: ServeRuleConfig{RejoinCooldown: 60}, records: []string{ServeServed}, after: 60},
+		{name: "自行取消也有冷却", rules: ServeRuleConfig{RejoinCooldown: 60}, records: []string{ServeRemoved}, after: 1, wantErr: ErrRejoinCooldown},
+		{name: "不限冷却", records: []string{ServeServed}},
+		{name: "达到叫号次数", rules: ServeRuleConfig{MaxServePerSession: 2}, records: []string{ServeServed, ServeServed}, after: 3600, wantErr: ErrServeQuota},
+		{name: "未达到叫号次数", rules: ServeRuleConfig{MaxServePerSession: 2}, records: []string{ServeServed}},
+		{name: "自行取消不计入次数", rules: ServeRuleConfig{MaxServePerSession: 1}, records: []string{ServeRemoved, ServeRemoved}},
+		{name: "次数优先于冷却", rules: ServeRuleConfig{RejoinCooldown: 60, MaxServePerSession: 1}, records: []string{ServeServed}, wantErr: ErrServeQuota},
+		{name: "其他用户的记录不影响", rules: ServeRuleConfig{RejoinCooldown: 60, MaxServePerSession: 1}, others: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewServeHistory()
+			var last ServeRecord
+			for _, kind := range tt.records {
+				last = h.Record(DefaultQueueName, User, kind)
+			}
+			for i := 0; i < tt.others; i++ {
+				h.Record(DefaultQueueName, other, ServeServed)
+			}
+			now := time.Now()
+			if last.Time > 0 {
+				now = time.Unix(last.Time+tt.after, 0)
+			}
+			if err := h.Check(tt.rules, User.OpenID, now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("错误 %v，应为 %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeHistoryForget(t *testing.T) {
+	h := NewServeHistory()
+	User := Line{OpenID: "user"}
+	rules := ServeRuleConfig{MaxServePerSession: 1}
+	rec := h.Record(DefaultQueueName, User, ServeServed)
+	if err := h.Check(rules, User.OpenID, time.Now()); !errors.Is(err, ErrServeQuota) {
+		t.Fatalf("错误 %v，应为 %v", err, ErrServeQuota)
+	}
+	h.Forget(rec)
+	if err := h.Check(rules, User.OpenID, time.Now()); err != nil {
+		t.Errorf("撤销后不应受限：%v", err)
+	}
+}
+
+// TestStartLiveSession 只有新的开播消息清空本场记录，重连后重复收到同一次开播消息时保留
+func TestStartLiveSession(t *testing.T) {
+	User := Line{OpenID: "live-session-user"}
+	rules := ServeRuleConfig{MaxServePerSession: 1}
+	start := time.Now().Unix()
+
+	StartLiveSession(start)
+	serveHistory.Record(DefaultQueueName, User, ServeServed)
+	StartLiveSession(start)
+	if err := serveHistory.Check(rules, User.OpenID, time.Now()); !errors.Is(err, ErrServeQuota) {
+		t.Fatalf("重复的开播消息不应清空记录，错误 %v", err)
+	}
+	StartLiveSession(start + 3600)
+	if err := serveHistory.Check(rules, User.OpenID, time.Now()); err != nil {
+		t.Errorf("新的一场直播应清空记录：%v", err)
+	}
+}
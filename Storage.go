@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// 持久化文件的结构版本，与对应迁移表的长度一致，结构变化时在迁移表末尾添加迁移
+const (
+	ConfigSchemaVersion = 1
+	LineSchemaVersion   = 1
+)
+
+// 自动备份保留的份数和两次备份的最小间隔
+const (
+	BackupCount    = 5
+	BackupInterval = 10 * time.Minute
+)
+
+var ErrInvalidBackup = errors.New("backup file is not valid json")
+
+// WriteFileAtomic 先写入同目录的临时文件再重命名，写入中途崩溃不会留下不完整的文件
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+	}
+	return err
+}
+
+// JsonLinesWriter 只追加的 JSON Lines 文件，每次写入一行，首次写入时打开文件
+type JsonLinesWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewJsonLinesWriter(path string) *JsonLinesWriter {
+	return &JsonLinesWriter{path: path}
+}
+
+// Append 将 v 序列化为一行追加到文件末尾
+func (w *JsonLinesWriter) Append(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(append(data, '\n'))
+	return err
+}
+
+// writeWithBackup 备份旧文件后原子写入
+func writeWithBackup(path string, data []byte) error {
+	if err := rotateBackups(path, false); err != nil {
+		slog.Error("备份文件失败", err, slog.String("path", path))
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// backupPath 第 n 份备份的路径，1为最新
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.bak%d", path, n)
+}
+
+// rotateBackups 将当前文件保存为最新备份，较旧的备份依次后移，超过 BackupCount 的删除
+// 最新备份不足 BackupInterval 时不备份，force 为 true 时总是备份；当前文件不是有效的 JSON 时不备份，避免覆盖完好的备份
+func rotateBackups(path string, force bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !json.Valid(data) {
+		return nil
+	}
+	if !force {
+		if info, err := os.Stat(backupPath(path, 1)); err == nil && time.Since(info.ModTime()) < BackupInterval {
+			return nil
+		}
+	}
+	_ = os.Remove(backupPath(path, BackupCount))
+	for n := BackupCount - 1; n >= 1; n-- {
+		if _, err := os.Stat(backupPath(path, n)); err == nil {
+			if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return WriteFileAtomic(backupPath(path, 1), data)
+}
+
+// BackupFile 一份自动备份
+type BackupFile struct {
+	Path    string
+	ModTime time.Time
+	// 是否为有效的 JSON
+	Valid bool
+}
+
+// ListBackups 文件的全部备份，按时间从新到旧排列
+func ListBackups(path string) []BackupFile {
+	var res []BackupFile
+	for n := 1; n <= BackupCount; n++ {
+		p := backupPath(path, n)
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		res = append(res, BackupFile{Path: p, ModTime: info.ModTime(), Valid: err == nil && json.Valid(data)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ModTime.After(res[j].ModTime)
+	})
+	return res
+}
+
+// RestoreBackup 用备份覆盖文件，覆盖前当前文件也会被备份
+func RestoreBackup(backup, path string) error {
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(data) {
+		return ErrInvalidBackup
+	}
+	if err = rotateBackups(path, true); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// migration 将文件结构从版本 i 升级到 i+1，i 为迁移在迁移表中的下标
+type migration func(map[string]json.RawMessage) error
+
+// migrate 按文件中的 SchemaVersion 依次执行迁移，没有版本号的文件为版本0，返回迁移后的内容和迁移前的版本
+func migrate(data []byte, migrations []migration) ([]byte, int, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if v, ok := raw["SchemaVersion"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, 0, err
+		}
+	}
+	if version >= len(migrations) {
+		if version > len(migrations) {
+			slog.Warn("文件由更新的版本写入，未知的字段将被忽略", slog.Int("SchemaVersion", version))
+		}
+		return data, version, nil
+	}
+	for v := version; v < len(migrations); v++ {
+		if err := migrations[v](raw); err != nil {
+			return nil, version, fmt.Errorf("migrate schema %d: %w", v, err)
+		}
+	}
+	raw["SchemaVersion"], _ = json.Marshal(len(migrations))
+	res, err := json.Marshal(raw)
+	return res, version, err
+}
+
+// configMigrations 配置文件迁移表
+var configMigrations = []migration{
+	// 0 -> 1：旧版配置没有版本号，排队关键词为空时使用默认的"排队"，空的特殊用户名单去掉
+	func(raw map[string]json.RawMessage) error {
+		var LineKey string
+		if v, ok := raw["LineKey"]; ok {
+			if err := json.Unmarshal(v, &LineKey); err != nil {
+				return err
+			}
+		}
+		if LineKey == "" {
+			raw["LineKey"], _ = json.Marshal("排队")
+		}
+		if v, ok := raw["SpecialUserList"]; ok && string(v) == "null" {
+			delete(raw, "SpecialUserList")
+		}
+		return nil
+	},
+}
+
+// lineMigrations 队列文件迁移表
+var lineMigrations = []migration{
+	// 0 -> 1：旧版的舰长、礼物、普通三条队列转换为层级
+	func(raw map[string]json.RawMessage) error {
+		if _, ok := raw["Tiers"]; ok {
+			return nil
+		}
+		legacy := []struct{ Key, Name, Sort string }{
+			{"GuardLine", "舰长", TierSortGuard},
+			{"GiftLine", "礼物", TierSortGift},
+			{"CommonLine", "普通", TierSortJoin},
+		}
+		var tiers []TierLine
+		for _, l := range legacy {
+			tier := TierLine{Name: l.Name, Sort: l.Sort}
+			if v, ok := raw[l.Key]; ok {
+				if err := json.Unmarshal(v, &tier.Users); err != nil {
+					return err
+				}
+				delete(raw, l.Key)
+			}
+			tiers = append(tiers, tier)
+		}
+		var err error
+		raw["Tiers"], err = json.Marshal(tiers)
+		return err
+	},
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetLineMigration(t *testing.T) {
+	guard := Line{OpenID: "guard", UserName: "舰长用户", GuardLevel: 3, IsOnline: true}
+	gift := Line{OpenID: "gift", UserName: "礼物用户", GiftName: "小花花", GiftPrice: 1.5, IsOnline: true}
+	common := Line{OpenID: "common", UserName: "普通用户"}
+	legacyTiers := func(guardUsers, giftUsers, commonUsers []Line) LineRow {
+		return LineRow{SchemaVersion: LineSchemaVersion, Tiers: []TierLine{
+			{Name: "舰长", Sort: TierSortGuard, Users: guardUsers},
+			{Name: "礼物", Sort: TierSortGift, Users: giftUsers},
+			{Name: "普通", Sort: TierSortJoin, Users: commonUsers},
+		}}
+	}
+	custom := []TierLine{{Name: "全部", Sort: TierSortJoin, Users: []Line{common}}}
+
+	tests := []struct {
+		name    string
+		file    interface{}
+		want    LineRow
+		wantErr bool
+	}{
+		{
+			name: "旧版三条队列",
+			file: map[string]interface{}{"GuardLine": []Line{guard}, "GiftLine": []Line{gift}, "CommonLine": []Line{common}},
+			want: legacyTiers([]Line{guard}, []Line{gift}, []Line{common}),
+		},
+		{
+			name: "旧版缺少部分队列",
+			file: map[string]interface{}{"CommonLine": []Line{common}},
+			want: legacyTiers(nil, nil, []Line{common}),
+		},
+		{
+			name: "旧版空队列",
+			file: map[string]interface{}{"GuardLine": nil, "GiftLine": []Line{}, "CommonLine": []Line{}},
+			want: legacyTiers(nil, nil, nil),
+		},
+		{
+			name: "没有版本号但已是层级结构",
+			file: map[string]interface{}{"Tiers": custom},
+			want: LineRow{SchemaVersion: LineSchemaVersion, Tiers: custom},
+		},
+		{
+			name: "当前版本不迁移",
+			file: map[string]interface{}{"SchemaVersion": LineSchemaVersion, "Tiers": custom, "GuardLine": []Line{guard}},
+			want: LineRow{SchemaVersion: LineSchemaVersion, Tiers: custom},
+		},
+		{
+			name:    "版本号无效",
+			file:    map[string]interface{}{"SchemaVersion": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "旧版队列格式错误",
+			file:    map[string]interface{}{"GuardLine": "guard"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), LineFile)
+			if err = os.WriteFile(path, data, 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			row, err := GetLine(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("应返回错误，得到 %+v", row)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rowJson(t, row), rowJson(t, tt.want); got != want {
+				t.Errorf("得到\n%s\n应为\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigMigration(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		wantVersion int
+		wantLineKey string
+		wantSpecial bool
+	}{
+		{name: "旧版空关键词", file: `{"LineKey":"","SpecialUserList":null}`, wantVersion: 0, wantLineKey: "排队"},
+		{name: "旧版没有关键词", file: `{"IdCode":"abc"}`, wantVersion: 0, wantLineKey: "排队"},
+		{name: "旧版保留关键词和特殊用户", file: `{"LineKey":"上车","SpecialUserList":{"a":{"EndTime":1}}}`, wantVersion: 0, wantLineKey: "上车", wantSpecial: true},
+		{name: "当前版本不迁移", file: `{"SchemaVersion":1,"LineKey":""}`, wantVersion: ConfigSchemaVersion, wantLineKey: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, version, err := migrate([]byte(tt.file), configMigrations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("迁移前版本 %d，应为 %d", version, tt.wantVersion)
+			}
+			var raw map[string]json.RawMessage
+			if err = json.Unmarshal(data, &raw); err != nil {
+				t.Fatal(err)
+			}
+			var Config RunConfig
+			if err = json.Unmarshal(data, &Config); err != nil {
+				t.Fatal(err)
+			}
+			if Config.SchemaVersion != ConfigSchemaVersion {
+				t.Errorf("迁移后版本 %d，应为 %d", Config.SchemaVersion, ConfigSchemaVersion)
+			}
+			if Config.LineKey != tt.wantLineKey {
+				t.Errorf("排队关键词 %q，应为 %q", Config.LineKey, tt.wantLineKey)
+			}
+			if _, ok := raw["SpecialUserList"]; ok != tt.wantSpecial {
+				t.Errorf("特殊用户名单存在 %t，应为 %t", ok, tt.wantSpecial)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/vtb-link/bianka/proto"
+)
+
+// 醒目留言在礼物队列中显示的礼物名
+const SuperChatGiftName = "醒目留言"
+
+var ErrSuperChatNotFound = errors.New("super chat not found")
+
+// SuperChat 醒目留言，时间为秒级时间戳
+type SuperChat struct {
+	MessageID int    `json:"message_id"`
+	OpenID    string `json:"open_id"`
+	UserName  string `json:"uname"`
+	Avatar    string `json:"uface"`
+	Message   string `json:"message"`
+	Rmb       int    `json:"rmb"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	IsRead    bool   `json:"is_read"`
+}
+
+// SuperChatList 当前醒目留言，过期的留言在读取时清理
+type SuperChatList struct {
+	mu      sync.Mutex
+	items   map[int]SuperChat
+	version uint64
+}
+
+var superChats = NewSuperChatList()
+
+func NewSuperChatList() *SuperChatList {
+	return &SuperChatList{items: make(map[int]SuperChat)}
+}
+
+// Add 添加或更新一条醒目留言
+func (l *SuperChatList) Add(sc SuperChat) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.items[sc.MessageID] = sc
+	l.version++
+}
+
+// Delete 删除醒目留言，返回实际删除的ID
+func (l *SuperChatList) Delete(MessageIds []int) []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var deleted []int
+	for _, id := range MessageIds {
+		if _, ok := l.items[id]; ok {
+			delete(l.items, id)
+			deleted = append(deleted, id)
+		}
+	}
+	if len(deleted) > 0 {
+		l.version++
+	}
+	return deleted
+}
+
+// MarkRead 标记醒目留言为已读
+func (l *SuperChatList) MarkRead(MessageID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sc, ok := l.items[MessageID]
+	if !ok {
+		return ErrSuperChatNotFound
+	}
+	sc.IsRead = true
+	l.items[MessageID] = sc
+	l.version++
+	return nil
+}
+
+// Active 未过期的醒目留言，按开始时间排序
+func (l *SuperChatList) Active() []SuperChat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().Unix()
+	res := make([]SuperChat, 0, len(l.items))
+	for id, sc := range l.items {
+		if sc.EndTime > 0 && sc.EndTime <= now {
+			delete(l.items, id)
+			l.version++
+			continue
+		}
+		res = append(res, sc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].StartTime != res[j].StartTime {
+			return res[i].StartTime < res[j].StartTime
+		}
+		return res[i].MessageID < res[j].MessageID
+	})
+	return res
+}
+
+// Version 醒目留言列表的修改次数，用于判断界面是否需要刷新
+func (l *SuperChatList) Version() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.version
+}
+
+// ResponseSuperChat 处理醒目留言：记录流水、推送弹幕页面，按配置加入礼物队列
+func ResponseSuperChat(ScData *proto.CmdSuperChatData) {
+	// 1元 = 10电池
+	scValue := float64(ScData.Rmb * 10)
+
+	if err := giftLedger.Record(GiftRecord{
+		Kind:     LedgerSuperChat,
+		OpenID:   ScData.OpenID,
+		UserName: ScData.Uname,
+		GiftName: SuperChatGiftName,
+		Num:      1,
+		Price:    scValue,
+		Paid:     true,
+	}); err != nil {
+		slog.Error("醒目留言流水写入失败", err)
+	}
+
+	sc := SuperChat{
+		MessageID: ScData.MessageID,
+		OpenID:    ScData.OpenID,
+		UserName:  ScData.Uname,
+		Avatar:    ScData.Uface,
+		Message:   ScData.Message,
+		Rmb:       ScData.Rmb,
+		StartTime: int64(ScData.StartTime),
+		EndTime:   int64(ScData.EndTime),
+	}
+	superChats.Add(sc)
+	SendDmEventToWs(DmEventSuperChat, sc)
+
+	if Config := Configuration(); !Config.SuperChatJoinLine || scValue < Config.SuperChatLinePrice {
+		return
+	}
+	if _, banned := blacklist.Check(ScData.OpenID); banned {
+		return
+	}
+	scLine := Line{
+		OpenID:         ScData.OpenID,
+		UserName:       ScData.Uname,
+		Avatar:         ScData.Uface,
+		GiftPrice:      scValue,
+		IsOnline:       true,
+		GiftName:       SuperChatGiftName,
+		FansMedalLevel: ScData.FansMedalLevel,
+	}
+	q := queues.ForUser(ScData.OpenID)
+	if !q.Engine.Contains(ScData.OpenID) && !q.Waitlist.Contains(ScData.OpenID) {
+		if err := CheckServeLimit(ScData.OpenID, PickTier(scLine)); err != nil {
+			slog.Info("醒目留言用户暂不能重新排队", slog.String("UserName", ScData.Uname), slog.String("reason", err.Error()))
+			return
+		}
+	}
+	updated, err := AddGiftOrWait(q, scLine)
+	if err != nil {
+		slog.Error("醒目留言加入礼物队列失败", err)
+		return
+	}
+	slog.Info("醒目留言加入礼物队列", slog.String("UserName", updated.UserName), slog.Float64("GiftPrice", updated.GiftPrice))
+}
+
+// ResponseSuperChatDel 处理醒目留言删除
+func ResponseSuperChatDel(ScDelData *proto.CmdSuperChatDelData) {
+	deleted := superChats.Delete(ScDelData.MessageIds)
+	SendDmEventToWs(DmEventSuperChatDel, ScDelData.MessageIds)
+	slog.Info("醒目留言被删除", slog.Any("MessageIds", deleted))
+}
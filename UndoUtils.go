@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// 撤销栈最多保留的操作数
+const maxUndoActions = 50
+
+var (
+	ErrNothingToUndo   = errors.New("nothing to undo")
+	ErrNothingToRedo   = errors.New("nothing to redo")
+	ErrNoUpperLine     = errors.New("user already in the highest line")
+	ErrNoLowerLine     = errors.New("user already in the lowest line")
+	ErrInvalidMoveType = errors.New("invalid move action")
+)
+
+// 操作员移动用户的方式
+const (
+	MoveUp      = "up"
+	MoveDown    = "down"
+	MoveTop     = "top"
+	MoveBottom  = "bottom"
+	MoveTo      = "to"
+	MovePromote = "promote"
+	MoveDemote  = "demote"
+	MoveQueue   = "queue"
+)
+
+// OperatorAction 一次可撤销的操作员操作
+type OperatorAction struct {
+	Name string
+	Undo func() error
+	Redo func() error
+}
+
+// UndoStack 操作员操作的撤销/重做栈
+type UndoStack struct {
+	mu   sync.Mutex
+	undo []OperatorAction
+	redo []OperatorAction
+}
+
+var operatorHistory = &UndoStack{}
+
+// Push 记录一次新操作，同时清空重做栈
+func (s *UndoStack) Push(action OperatorAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.undo = append(s.undo, action)
+	if len(s.undo) > maxUndoActions {
+		s.undo = s.undo[len(s.undo)-maxUndoActions:]
+	}
+	s.redo = nil
+}
+
+// Undo 撤销最近一次操作，返回被撤销的操作名
+func (s *UndoStack) Undo() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.undo) == 0 {
+		return "", ErrNothingToUndo
+	}
+	action := s.undo[len(s.undo)-1]
+	s.undo = s.undo[:len(s.undo)-1]
+	if err := action.Undo(); err != nil {
+		return action.Name, err
+	}
+	s.redo = append(s.redo, action)
+	return action.Name, nil
+}
+
+// Redo 重做最近一次被撤销的操作，返回被重做的操作名
+func (s *UndoStack) Redo() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.redo) == 0 {
+		return "", ErrNothingToRedo
+	}
+	action := s.redo[len(s.redo)-1]
+	s.redo = s.redo[:len(s.redo)-1]
+	if err := action.Redo(); err != nil {
+		return action.Name, err
+	}
+	s.undo = append(s.undo, action)
+	return action.Name, nil
+}
+
+// userEngine 用户所在队列的队列引擎
+func userEngine(OpenID string) (*LineEngine, error) {
+	q, ok := queues.Find(OpenID)
+	if !ok {
+		return nil, ErrUserNotInLine
+	}
+	return q.Engine, nil
+}
+
+// OperatorRemove 操作员删除用户，视为已叫号，可撤销，撤销后用户回到原队列的原位置并删除叫号记录
+func OperatorRemove(OpenID string) error {
+	q, ok := queues.Find(OpenID)
+	if !ok {
+		return ErrUserNotInLine
+	}
+	removed, err := q.Engine.Take(OpenID)
+	if err != nil {
+		return err
+	}
+	operatorRemoved(q, removed)
+	return nil
+}
+
+// OperatorNext 叫号，操作员删除队列中优先级最高的第一位用户，可撤销
+func OperatorNext(q *Queue) (Line, error) {
+	removed, err := q.Engine.Next()
+	if err != nil {
+		return Line{}, err
+	}
+	operatorRemoved(q, removed)
+	return removed.Line, nil
+}
+
+// operatorRemoved 记录被删除用户的叫号，由等候名单补位，并记录撤销操作
+func operatorRemoved(q *Queue, removed LineEntry) {
+	e := q.Engine
+	OpenID := removed.OpenID()
+	rec := serveHistory.Record(q.Name, removed.Line, ServeServed)
+	promoted := PromoteWaitlist(q)
+	operatorHistory.Push(OperatorAction{
+		Name: "删除 " + entryName(removed),
+		Undo: func() error {
+			// 先让补位的用户回到等候名单，避免恢复后超出容量
+			DemoteWaitlist(q, promoted)
+			if err := e.Insert(removed); err != nil {
+				return err
+			}
+			serveHistory.Forget(rec)
+			return nil
+		},
+		Redo: func() error {
+			if err := e.Remove(OpenID); err != nil {
+				return err
+			}
+			rec = serveHistory.Record(q.Name, removed.Line, ServeServed)
+			promoted = PromoteWaitlist(q)
+			return nil
+		},
+	})
+}
+
+// OperatorToggleOnline 操作员切换用户在场状态，可撤销
+func OperatorToggleOnline(OpenID string) (bool, error) {
+	e, err := userEngine(OpenID)
+	if err != nil {
+		return false, err
+	}
+	old, _ := e.Entry(OpenID)
+	IsOnline, err := e.ToggleOnline(OpenID)
+	if err != nil {
+		return IsOnline, err
+	}
+	name := "离场 "
+	if IsOnline {
+		name = "在场 "
+	}
+	if le, ok := e.Entry(OpenID); ok {
+		name += entryName(le)
+	}
+	operatorHistory.Push(OperatorAction{
+		Name: name,
+		Undo: func() error {
+			// 撤销时恢复为无互动标记，避免被暂离超时移出
+			if old.Line.Idle {
+				_, err := e.SetIdle(OpenID)
+				return err
+			}
+			return e.SetOnline(OpenID, !IsOnline)
+		},
+		Redo: func() error { return e.SetOnline(OpenID, IsOnline) },
+	})
+	return IsOnline, nil
+}
+
+// OperatorMove 操作员调整用户在队列中的位置，可撤销
+func OperatorMove(OpenID string, ToIndex int) error {
+	e, err := userEngine(OpenID)
+	if err != nil {
+		return err
+	}
+	from, err := e.Move(OpenID, ToIndex)
+	if err != nil {
+		return err
+	}
+	name := "移动"
+	if le, ok := e.Entry(OpenID); ok {
+		name += " " + entryName(le)
+		ToIndex = le.Index
+	}
+	if from == ToIndex {
+		return nil
+	}
+	operatorHistory.Push(OperatorAction{
+		Name: name,
+		Undo: func() error {
+			_, err := e.Move(OpenID, from)
+			return err
+		},
+		Redo: func() error {
+			_, err := e.Move(OpenID, ToIndex)
+			return err
+		},
+	})
+	return nil
+}
+
+// OperatorTransfer 操作员将用户移动到同一队列的另一层级，可撤销，撤销后用户以原信息回到原层级的原位置
+func OperatorTransfer(OpenID string, ToLineType, ToIndex int) error {
+	e, err := userEngine(OpenID)
+	if err != nil {
+		return err
+	}
+	if LineType, _, ok := e.Find(OpenID); ok && LineType == ToLineType {
+		return OperatorMove(OpenID, ToIndex)
+	}
+	old, err := e.Transfer(OpenID, ToLineType, ToIndex)
+	if err != nil {
+		return err
+	}
+	operatorHistory.Push(OperatorAction{
+		Name: "移动 " + entryName(old) + " 到" + e.TierName(ToLineType),
+		Undo: func() error {
+			if _, err := e.Take(OpenID); err != nil {
+				return err
+			}
+			return e.Insert(old)
+		},
+		Redo: func() error {
+			_, err := e.Transfer(OpenID, ToLineType, ToIndex)
+			return err
+		},
+	})
+	return nil
+}
+
+// OperatorMoveQueue 操作员将用户移动到另一个队列，按层级条件重新排队，可撤销
+func OperatorMoveQueue(OpenID, ToQueue string) error {
+	from, ok := queues.Find(OpenID)
+	if !ok {
+		return ErrUserNotInLine
+	}
+	to, err := queues.Get(ToQueue)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return ErrSameQueue
+	}
+	old, err := moveQueue(from, to, OpenID)
+	if err != nil {
+		return err
+	}
+	PromoteWaitlist(from)
+	operatorHistory.Push(OperatorAction{
+		Name: "移动 " + entryName(old) + " 到" + to.Title(),
+		Undo: func() error {
+			if _, err := to.Engine.Take(OpenID); err != nil {
+				return err
+			}
+			return from.Engine.Insert(old)
+		},
+		Redo: func() error {
+			_, err := moveQueue(from, to, OpenID)
+			return err
+		},
+	})
+	return nil
+}
+
+// moveQueue 将用户从一个队列移出并加入另一个队列，不受目标队列容量限制，返回移动前的队列信息
+func moveQueue(from, to *Queue, OpenID string) (LineEntry, error) {
+	old, err := from.Engine.Take(OpenID)
+	if err != nil {
+		return LineEntry{}, err
+	}
+	User := old.Line
+	tier := PickTier(User)
+	User.PrintColor = TierColor(tier)
+	if err = to.Engine.Join(tier, User, 0); err != nil {
+		// 加入失败时放回原队列
+		if restoreErr := from.Engine.Insert(old); restoreErr != nil {
+			slog.Error("移动队列失败后恢复用户失败", restoreErr, slog.String("OpenID", OpenID))
+		}
+		return LineEntry{}, err
+	}
+	return old, nil
+}
+
+// OperatorMoveAction 按移动方式调整用户位置，Index 仅在 MoveTo 时使用(从0开始)
+// 升级移动到上一层级的队尾，降级移动到下一层级的队首
+func OperatorMoveAction(OpenID, Action string, Index int) error {
+	e, err := userEngine(OpenID)
+	if err != nil {
+		return err
+	}
+	LineType, idx, ok := e.Find(OpenID)
+	if !ok {
+		return ErrUserNotInLine
+	}
+	switch Action {
+	case MoveUp:
+		return OperatorMove(OpenID, idx-1)
+	case MoveDown:
+		return OperatorMove(OpenID, idx+1)
+	case MoveTop:
+		return OperatorMove(OpenID, 0)
+	case MoveBottom:
+		return OperatorMove(OpenID, e.LineLen(LineType)-1)
+	case MoveTo:
+		return OperatorMove(OpenID, Index)
+	case MovePromote:
+		if LineType == 0 {
+			return ErrNoUpperLine
+		}
+		return OperatorTransfer(OpenID, LineType-1, e.LineLen(LineType-1))
+	case MoveDemote:
+		if LineType >= e.TierCount()-1 {
+			return ErrNoLowerLine
+		}
+		return OperatorTransfer(OpenID, LineType+1, 0)
+	}
+	return ErrInvalidMoveType
+}
+
+// OperatorClear 操作员清空队列，可撤销，撤销后被清空的用户按原顺序回到各自层级的前部
+func OperatorClear(q *Queue) {
+	removed := q.Engine.Clear()
+	waiting := q.Waitlist.Clear()
+	if removed.IsEmpty() && len(waiting) == 0 {
+		return
+	}
+	if len(waiting) > 0 {
+		SendWaitlistToWs(q.Name, 0)
+	}
+	entries := rowEntries(removed)
+	operatorHistory.Push(OperatorAction{
+		Name: "清空" + q.Title(),
+		Undo: func() error {
+			for _, le := range entries {
+				if err := q.Engine.Insert(le); err != nil && !errors.Is(err, ErrUserInLine) {
+					slog.Error("撤销清空时恢复用户失败", err, slog.String("OpenID", le.OpenID()))
+				}
+			}
+			for _, w := range waiting {
+				_, _ = q.Waitlist.Add(w.Tier, w.Line)
+			}
+			SendWaitlistToWs(q.Name, q.Waitlist.Len())
+			return nil
+		},
+		Redo: func() error {
+			for _, le := range entries {
+				if err := q.Engine.Remove(le.OpenID()); err != nil && !errors.Is(err, ErrUserNotInLine) {
+					return err
+				}
+			}
+			q.Waitlist.Clear()
+			SendWaitlistToWs(q.Name, 0)
+			return nil
+		},
+	})
+}
+
+// OperatorBan 操作员拉黑用户，Duration 为0时永久有效，用户在队列中时一并移出，可撤销
+func OperatorBan(OpenID, UserName, Reason string, Duration time.Duration) error {
+	old, existed, err := blacklist.Add(OpenID, UserName, Reason, Duration)
+	if err != nil {
+		return err
+	}
+	var (
+		removed  LineEntry
+		promoted []WaitEntry
+	)
+	q, inLine := queues.Find(OpenID)
+	if inLine {
+		if removed, err = q.Engine.Take(OpenID); err != nil {
+			inLine = false
+		} else {
+			promoted = PromoteWaitlist(q)
+		}
+	}
+	// 等候中的用户直接移出等候名单，撤销时不恢复
+	LeaveWaitlist(OpenID)
+	operatorHistory.Push(OperatorAction{
+		Name: "拉黑 " + UserName,
+		Undo: func() error {
+			var err error
+			if existed {
+				err = blacklist.Restore(old)
+			} else {
+				err = blacklist.Remove(OpenID)
+			}
+			if err != nil {
+				return err
+			}
+			if inLine {
+				// 先让补位的用户回到等候名单，避免恢复后超出容量
+				DemoteWaitlist(q, promoted)
+				return q.Engine.Insert(removed)
+			}
+			return nil
+		},
+		Redo: func() error {
+			if _, _, err := blacklist.Add(OpenID, UserName, Reason, Duration); err != nil {
+				return err
+			}
+			if inLine {
+				if err := q.Engine.Remove(OpenID); err != nil {
+					return err
+				}
+				promoted = PromoteWaitlist(q)
+			}
+			return nil
+		},
+	})
+	return nil
+}
+
+// OperatorUnban 操作员解除拉黑，可撤销
+func OperatorUnban(OpenID string) error {
+	entry, ok := blacklist.Check(OpenID)
+	if !ok {
+		return ErrNotBlacklisted
+	}
+	if err := blacklist.Remove(OpenID); err != nil {
+		return err
+	}
+	operatorHistory.Push(OperatorAction{
+		Name: "解除拉黑 " + entry.UserName,
+		Undo: func() error { return blacklist.Restore(entry) },
+		Redo: func() error { return blacklist.Remove(OpenID) },
+	})
+	return nil
+}
+
+// rowEntries 按队列和下标顺序展开队列中的全部用户
+func rowEntries(row LineRow) []LineEntry {
+	var entries []LineEntry
+	for t, tier := range row.Tiers {
+		for i, l := range tier.Users {
+			entries = append(entries, LineEntry{LineType: t, Index: i, Line: l})
+		}
+	}
+	return entries
+}
+
+func entryName(le LineEntry) string {
+	return le.Line.UserName
+}
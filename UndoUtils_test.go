@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestQueues 使用临时目录中的配置、队列和黑名单，测试结束后恢复
+func useTestQueues(t *testing.T, Config RunConfig) *Queue {
+	t.Helper()
+	useTempProfile(t)
+	oldConfig, oldQueues, oldBlacklist, oldHistory := Configuration(), queues, blacklist, operatorHistory
+	t.Cleanup(func() {
+		for _, q := range queues.All() {
+			q.Engine.Close()
+		}
+		setConfiguration(oldConfig)
+		queues, blacklist, operatorHistory = oldQueues, oldBlacklist, oldHistory
+	})
+
+	Config = ConfigWithDefaults(Config)
+	setConfiguration(Config)
+	queues = &QueueManager{}
+	queues.Apply(Config)
+	blacklist = NewBlacklist(filepath.Join(ProfileDir, BlacklistFile))
+	operatorHistory = &UndoStack{}
+	return queues.Default()
+}
+
+// lineState 队列和等候名单中的用户，按顺序排列
+func lineState(q *Queue) (line, waiting []string) {
+	for _, le := range rowEntries(q.Engine.Snapshot()) {
+		line = append(line, le.OpenID())
+	}
+	for _, entry := range q.Waitlist.List() {
+		waiting = append(waiting, entry.Line.OpenID)
+	}
+	return line, waiting
+}
+
+func TestOperatorUndoPromotion(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(q *Queue) error
+	}{
+		{name: "删除", remove: func(q *Queue) error { return OperatorRemove("first") }},
+		{name: "叫号", remove: func(q *Queue) error { _, err := OperatorNext(q); return err }},
+		{name: "拉黑", remove: func(q *Queue) error { return OperatorBan("first", "first", "", time.Hour) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := useTestQueues(t, RunConfig{IdCode: "ABCDEF", MaxLineCount: 2})
+			for _, OpenID := range []string{"first", "second", "w1", "w2"} {
+				User := Line{OpenID: OpenID, UserName: OpenID}
+				if err := JoinOrWait(q, PickTier(User), User); err != nil {
+					t.Fatal(err)
+				}
+			}
+			check := func(step string, wantLine, wantWaiting []string) {
+				t.Helper()
+				line, waiting := lineState(q)
+				if !equalStrings(line, wantLine) || !equalStrings(waiting, wantWaiting) {
+					t.Errorf("%s后队列 %v 等候 %v，应为 %v 和 %v", step, line, waiting, wantLine, wantWaiting)
+				}
+			}
+			check("加入", []string{"first", "second"}, []string{"w1", "w2"})
+
+			if err := tt.remove(q); err != nil {
+				t.Fatal(err)
+			}
+			check("移出", []string{"second", "w1"}, []string{"w2"})
+
+			// 撤销后补位的用户回到等候名单最前，队列不超过容量
+			if _, err := operatorHistory.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			check("撤销", []string{"first", "second"}, []string{"w1", "w2"})
+
+			if _, err := operatorHistory.Redo(); err != nil {
+				t.Fatal(err)
+			}
+			check("重做", []string{"second", "w1"}, []string{"w2"})
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
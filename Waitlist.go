@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+var ErrUserInWaitlist = errors.New("user already in waitlist")
+
+// WaitEntry 等候名单中的一位用户，Tier 为有空位时加入的层级
+type WaitEntry struct {
+	Tier int
+	Line Line
+	Time int64 // 加入等候名单的秒级时间戳
+}
+
+// Waitlist 队列或层级已满时的等候名单，按加入顺序排列，只保存在内存中
+type Waitlist struct {
+	mu      sync.Mutex
+	entries []WaitEntry
+}
+
+func NewWaitlist() *Waitlist {
+	return &Waitlist{}
+}
+
+// index 用户在等候名单中的下标，调用方需持有锁
+func (w *Waitlist) index(OpenID string) int {
+	for i, entry := range w.entries {
+		if entry.Line.OpenID == OpenID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add 加入等候名单末尾，返回从1开始的等候位置
+func (w *Waitlist) Add(Tier int, User Line) (int, error) {
+	if User.OpenID == "" {
+		return 0, ErrEmptyOpenID
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.index(User.OpenID) >= 0 {
+		return 0, ErrUserInWaitlist
+	}
+	w.entries = append(w.entries, WaitEntry{Tier: Tier, Line: User, Time: time.Now().Unix()})
+	return len(w.entries), nil
+}
+
+// restore 将条目放回等候名单最前，用于加入队列失败时保持顺序
+func (w *Waitlist) restore(entry WaitEntry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.index(entry.Line.OpenID) < 0 {
+		w.entries = append([]WaitEntry{entry}, w.entries...)
+	}
+}
+
+// Remove 移出等候名单
+func (w *Waitlist) Remove(OpenID string) (WaitEntry, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := w.index(OpenID)
+	if i < 0 {
+		return WaitEntry{}, false
+	}
+	entry := w.entries[i]
+	w.entries = append(w.entries[:i], w.entries[i+1:]...)
+	return entry, true
+}
+
+// Update 修改等候中用户的信息，并按修改后的信息重新选择层级
+func (w *Waitlist) Update(OpenID string, fn func(*Line)) (WaitEntry, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := w.index(OpenID)
+	if i < 0 {
+		return WaitEntry{}, false
+	}
+	fn(&w.entries[i].Line)
+	w.entries[i].Tier = PickTier(w.entries[i].Line)
+	w.entries[i].Line.PrintColor = TierColor(w.entries[i].Tier)
+	return w.entries[i], true
+}
+
+// Get 等候中用户的信息
+func (w *Waitlist) Get(OpenID string) (WaitEntry, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if i := w.index(OpenID); i >= 0 {
+		return w.entries[i], true
+	}
+	return WaitEntry{}, false
+}
+
+// Contains 用户是否在等候名单中
+func (w *Waitlist) Contains(OpenID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.index(OpenID) >= 0
+}
+
+// Len 等候人数
+func (w *Waitlist) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.entries)
+}
+
+// List 等候名单，按加入顺序排列
+func (w *Waitlist) List() []WaitEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]WaitEntry{}, w.entries...)
+}
+
+// Clear 清空等候名单，返回清空前的条目
+func (w *Waitlist) Clear() []WaitEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old := w.entries
+	w.entries = nil
+	return old
+}
+
+// HasRoom 队列总容量和层级容量是否还有空位，大航海用户可配置为不受队列总容量限制
+func (q *Queue) HasRoom(Tier int, User Line) bool {
+	ignoreMax := User.GuardLevel > 0 && Configuration().GuardIgnoreMaxLine
+	if limit := q.MaxLineCount(); !ignoreMax && limit > 0 && q.Engine.Len() >= limit {
+		return false
+	}
+	if max := TierMaxCount(Tier); max > 0 && q.Engine.LineLen(Tier) >= max {
+		return false
+	}
+	return true
+}
+
+// JoinOrWait 有空位时加入队列，队列或层级已满时按顺序加入等候名单
+func JoinOrWait(q *Queue, Tier int, User Line) error {
+	if q.HasRoom(Tier, User) {
+		err := q.Engine.Join(Tier, User, TierMaxCount(Tier))
+		if !errors.Is(err, ErrLineFull) {
+			return err
+		}
+	}
+	pos, err := q.Waitlist.Add(Tier, User)
+	if err != nil {
+		return err
+	}
+	slog.Info("队列已满，加入等候名单", slog.String("Queue", q.Name), slog.String("UserName", User.UserName), slog.Int("Position", pos))
+	SendWaitlistToWs(q.Name, q.Waitlist.Len())
+	return nil
+}
+
+// AddGiftOrWait 累计用户礼物价值，等候中的用户累计到等候名单，不在队列中的用户所选层级已满时加入等候名单
+func AddGiftOrWait(q *Queue, User Line) (Line, error) {
+	if entry, ok := q.Waitlist.Update(User.OpenID, func(old *Line) {
+		old.GiftPrice += User.GiftPrice
+		old.GiftName = User.GiftName
+	}); ok {
+		PromoteWaitlist(q)
+		return entry.Line, nil
+	}
+	if !q.Engine.Contains(User.OpenID) {
+		if tier := PickTier(User); !q.HasRoom(tier, User) {
+			User.PrintColor = TierColor(tier)
+			return User, JoinOrWait(q, tier, User)
+		}
+	}
+	return q.Engine.AddGift(User)
+}
+
+// PromoteWaitlist 按等候顺序将有空位的用户加入队列，所在层级仍满的用户继续等候，返回加入队列的用户
+func PromoteWaitlist(q *Queue) []WaitEntry {
+	var promoted []WaitEntry
+	changed := false
+	for _, entry := range q.Waitlist.List() {
+		if !q.HasRoom(entry.Tier, entry.Line) {
+			continue
+		}
+		if _, ok := q.Waitlist.Remove(entry.Line.OpenID); !ok {
+			continue
+		}
+		changed = true
+		if err := q.Engine.Join(entry.Tier, entry.Line, TierMaxCount(entry.Tier)); err != nil {
+			if errors.Is(err, ErrLineFull) {
+				q.Waitlist.restore(entry)
+				continue
+			}
+			slog.Error("等候用户加入队列失败", err, slog.String("OpenID", entry.Line.OpenID))
+			continue
+		}
+		promoted = append(promoted, entry)
+		slog.Info("等候用户加入队列", slog.String("Queue", q.Name), slog.String("UserName", entry.Line.UserName))
+	}
+	if changed {
+		SendWaitlistToWs(q.Name, q.Waitlist.Len())
+	}
+	return promoted
+}
+
+// DemoteWaitlist 撤销 PromoteWaitlist，将补位的用户移出队列并按原顺序放回等候名单最前，已不在队列中的用户跳过
+func DemoteWaitlist(q *Queue, promoted []WaitEntry) {
+	if len(promoted) == 0 {
+		return
+	}
+	for i := len(promoted) - 1; i >= 0; i-- {
+		entry := promoted[i]
+		le, err := q.Engine.Take(entry.Line.OpenID)
+		if err != nil {
+			continue
+		}
+		entry.Tier, entry.Line = le.LineType, le.Line
+		q.Waitlist.restore(entry)
+	}
+	SendWaitlistToWs(q.Name, q.Waitlist.Len())
+}
+
+// LeaveWaitlist 用户取消等候
+func LeaveWaitlist(OpenID string) bool {
+	q, ok := queues.FindWaiting(OpenID)
+	if !ok {
+		return false
+	}
+	if _, ok = q.Waitlist.Remove(OpenID); ok {
+		SendWaitlistToWs(q.Name, q.Waitlist.Len())
+	}
+	return ok
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// waitlistTestTiers 礼物层级只有一个位置
+var waitlistTestTiers = []LineTier{
+	{Name: "舰长", Rule: TierRule{GuardLevel: 3}, Sort: TierSortGuard},
+	{Name: "礼物", Rule: TierRule{MinGiftPrice: 10}, Sort: TierSortGift, MaxCount: 1},
+	{Name: "普通", Sort: TierSortJoin},
+}
+
+func TestWaitlistPromotion(t *testing.T) {
+	q := useTestQueues(t, RunConfig{IdCode: "ABCDEF", MaxLineCount: 3, LineTiers: waitlistTestTiers, GuardIgnoreMaxLine: true})
+	users := map[string]Line{
+		"c1": {OpenID: "c1"}, "c2": {OpenID: "c2"}, "c3": {OpenID: "c3"}, "c4": {OpenID: "c4"},
+		"g1": {OpenID: "g1", GiftPrice: 20}, "g2": {OpenID: "g2", GiftPrice: 30},
+		"captain": {OpenID: "captain", GuardLevel: 3},
+	}
+	join := func(OpenID string) error {
+		User := users[OpenID]
+		return JoinOrWait(q, PickTier(User), User)
+	}
+
+	steps := []struct {
+		name        string
+		run         func() error
+		wantLine    string
+		wantWaiting string
+		check       func()
+	}{
+		{
+			name:        "有空位时直接加入",
+			run:         func() error { return firstErr(join("c1"), join("g1"), join("c2")) },
+			wantLine:    "[[] [g1] [c1 c2]]",
+			wantWaiting: "[]",
+		},
+		{
+			name:        "队列已满时按顺序等候",
+			run:         func() error { return firstErr(join("g2"), join("c3"), join("c4")) },
+			wantLine:    "[[] [g1] [c1 c2]]",
+			wantWaiting: "[g2 c3 c4]",
+		},
+		{
+			name:        "大航海用户不受队列总容量限制",
+			run:         func() error { return join("captain") },
+			wantLine:    "[[captain] [g1] [c1 c2]]",
+			wantWaiting: "[g2 c3 c4]",
+		},
+		{
+			name:        "已在等候名单中",
+			run:         func() error { return expectErr(join("c3"), ErrUserInWaitlist) },
+			wantLine:    "[[captain] [g1] [c1 c2]]",
+			wantWaiting: "[g2 c3 c4]",
+		},
+		{
+			name:        "补位时跳过层级仍满的用户",
+			run:         func() error { return firstErr(q.Engine.Remove("captain"), q.Engine.Remove("c1"), promote(q)) },
+			wantLine:    "[[] [g1] [c2 c3]]",
+			wantWaiting: "[g2 c4]",
+		},
+		{
+			name:        "层级有空位后按等候顺序补位",
+			run:         func() error { return firstErr(q.Engine.Remove("g1"), promote(q)) },
+			wantLine:    "[[] [g2] [c2 c3]]",
+			wantWaiting: "[c4]",
+		},
+		{
+			name: "等候中送礼累计到等候名单并重新选择层级",
+			run: func() error {
+				_, err := AddGiftOrWait(q, Line{OpenID: "c4", GiftPrice: 15, GiftName: "小花花"})
+				return err
+			},
+			wantLine:    "[[] [g2] [c2 c3]]",
+			wantWaiting: "[c4]",
+			check: func() {
+				if entry, _ := q.Waitlist.Get("c4"); entry.Tier != 1 || entry.Line.GiftPrice != 15 {
+					t.Errorf("等候用户 %+v，应在礼物层级等候且累计15电池", entry)
+				}
+			},
+		},
+		{
+			name:        "取消等候",
+			run:         func() error { return expectTrue(LeaveWaitlist("c4")) },
+			wantLine:    "[[] [g2] [c2 c3]]",
+			wantWaiting: "[]",
+		},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatalf("%s：%v", s.name, err)
+		}
+		line, waiting := fmt.Sprint(tierIDs(q.Engine)), fmt.Sprint(waitingIDs(q))
+		if line != s.wantLine || waiting != s.wantWaiting {
+			t.Errorf("%s：队列 %s 等候 %s，应为 %s 和 %s", s.name, line, waiting, s.wantLine, s.wantWaiting)
+		}
+		if s.check != nil {
+			s.check()
+		}
+	}
+}
+
+func waitingIDs(q *Queue) []string {
+	res := []string{}
+	for _, entry := range q.Waitlist.List() {
+		res = append(res, entry.Line.OpenID)
+	}
+	return res
+}
+
+func promote(q *Queue) error {
+	PromoteWaitlist(q)
+	return nil
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expectErr(err, want error) error {
+	if err != want {
+		return fmt.Errorf("错误 %v，应为 %v", err, want)
+	}
+	return nil
+}
+
+func expectTrue(ok bool) error {
+	if !ok {
+		return fmt.Errorf("应返回 true")
+	}
+	return nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	_ "embed"
 	"encoding/json"
+	"errors"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/slog"
 
@@ -33,29 +38,82 @@ var (
 	dmLock    sync.Mutex
 )
 
+// QueueWsMessage 发往队列页面的消息，只推送给订阅了同一队列的连接
+type QueueWsMessage struct {
+	Queue string
+	Data  []byte
+}
+
 var (
-	QueueChatChan = make(chan []byte, 50)
+	QueueChatChan = make(chan QueueWsMessage, 50)
 	DmChatChan    = make(chan []byte, 50)
 	upgrader      = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
-	QueueConnMap = make(map[*websocket.Conn]bool)
-	DmConnMap    = make(map[*websocket.Conn]bool)
+	QueueConnMap = make(map[*websocket.Conn]string) // 连接订阅的队列标识，由 queueLock 保护
+	DmConnMap    = make(map[*websocket.Conn]bool)   // 由 dmLock 保护
 )
 
-func StartWebServer() {
-	_, _ = http.Get("http://127.0.0.1:100/EXIT")
+// wsWriteTimeout 单次推送的超时时间，避免卡住的页面拖住推送
+const wsWriteTimeout = 5 * time.Second
+
+// 推送协程随程序启动，始终取出消息，没有页面连接时消息直接丢弃，无头模式或未打开页面时不会阻塞弹幕处理和队列操作
+func init() {
+	go broadcastQueue()
+	go broadcastDm()
+}
+
+// broadcastQueue 将队列消息推送给订阅了同一队列的连接
+func broadcastQueue() {
+	for Chat := range QueueChatChan {
+		queueLock.Lock()
+		for w, QueueName := range QueueConnMap {
+			if QueueName != Chat.Queue {
+				continue
+			}
+			if err := writeWs(w, Chat.Data); err != nil {
+				slog.Error("Failed to write message:", err)
+				delete(QueueConnMap, w)
+				_ = w.Close()
+			}
+		}
+		queueLock.Unlock()
+	}
+}
+
+// broadcastDm 将弹幕消息推送给全部弹幕页面
+func broadcastDm() {
+	for Chat := range DmChatChan {
+		dmLock.Lock()
+		for w := range DmConnMap {
+			if err := writeWs(w, Chat); err != nil {
+				slog.Error("Failed to write message:", err)
+				delete(DmConnMap, w)
+				_ = w.Close()
+			}
+		}
+		dmLock.Unlock()
+	}
+}
 
+// writeWs 写入一条文本消息，调用方需持有对应连接表的锁
+func writeWs(conn *websocket.Conn, Data []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteMessage(websocket.TextMessage, Data)
+}
+
+// StartWebServer 启动页面服务，只在监听失败时返回
+func StartWebServer() error {
+	_, _ = http.Get(LocalURL("/EXIT"))
+
+	// 页面接口允许跨域读取，不携带凭据；修改类接口另外校验来源
 	handler := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowCredentials(),
 	)(WebServer())
-	err := http.ListenAndServe(":100", handler)
-	if err != nil {
-		slog.Error(err.Error())
-		return
-	}
+	err := http.ListenAndServe(ListenAddr, handler)
+	slog.Error("页面服务启动失败", err, slog.String("addr", ListenAddr))
+	return err
 }
 
 func WebServer() *http.ServeMux {
@@ -68,47 +126,44 @@ func WebServer() *http.ServeMux {
 			return
 		}
 
-		QueueConnMap[conn] = true
+		QueueName := request.URL.Query().Get("queue")
+		if QueueName == "" {
+			QueueName = DefaultQueueName
+		}
+		queueLock.Lock()
+		QueueConnMap[conn] = QueueName
+		err = writeWs(conn, []byte("Connected"))
+		queueLock.Unlock()
 
-		err = conn.WriteMessage(websocket.TextMessage, []byte("Connected"))
-		if err != nil {
+		// 推送失败时连接已由 broadcastQueue 移除并关闭
+		defer func(conn *websocket.Conn) {
+			queueLock.Lock()
+			defer queueLock.Unlock()
+			if _, ok := QueueConnMap[conn]; !ok {
+				return
+			}
 			delete(QueueConnMap, conn)
+			if err := conn.Close(); err != nil {
+				slog.Error("Failed to close connection:", err)
+			}
+		}(conn)
+		if err != nil {
 			return
 		}
 
-		defer func(conn *websocket.Conn) {
-			err := conn.Close()
+		for {
+			_, Message, err := conn.ReadMessage()
 			if err != nil {
-				slog.Error("Failed to close connection:", err)
 				return
 			}
-		}(conn)
-
-		go func() {
-			for {
-				_, Message, err := conn.ReadMessage()
+			switch string(Message) {
+			case "ping":
+				queueLock.Lock()
+				err = writeWs(conn, []byte("pong"))
+				queueLock.Unlock()
 				if err != nil {
 					return
 				}
-				switch string(Message) {
-				case "ping":
-					err := conn.WriteMessage(websocket.TextMessage, []byte("pong"))
-					if err != nil {
-						return
-					}
-				}
-			}
-		}()
-
-		for {
-			Chat := <-QueueChatChan
-			ConnMapCopy := QueueConnMap
-			for w := range ConnMapCopy {
-				err = w.WriteMessage(websocket.TextMessage, Chat)
-				if err != nil {
-					slog.Error("Failed to write message:", err)
-					delete(QueueConnMap, w)
-				}
 			}
 		}
 	})
@@ -119,48 +174,41 @@ func WebServer() *http.ServeMux {
 			slog.Error("Websocket Upgrade Err:", err.Error())
 			return
 		}
+		dmLock.Lock()
 		DmConnMap[conn] = true
+		err = writeWs(conn, []byte("Connected"))
+		dmLock.Unlock()
 
-		err = conn.WriteMessage(websocket.TextMessage, []byte("Connected"))
+		// 推送失败时连接已由 broadcastDm 移除并关闭
+		defer func(conn *websocket.Conn) {
+			dmLock.Lock()
+			defer dmLock.Unlock()
+			if _, ok := DmConnMap[conn]; !ok {
+				return
+			}
+			delete(DmConnMap, conn)
+			if err := conn.Close(); err != nil {
+				slog.Error("Failed to close connection:", err)
+			}
+		}(conn)
 		if err != nil {
 			slog.Error("Websocket Write Err:", err.Error())
-			delete(DmConnMap, conn)
 			return
 		}
 
-		defer func(conn *websocket.Conn) {
-			err := conn.Close()
+		for {
+			_, Message, err := conn.ReadMessage()
 			if err != nil {
-				slog.Error("Failed to close connection:", err)
 				return
 			}
-		}(conn)
-
-		go func() {
-			for {
-				_, Message, err := conn.ReadMessage()
+			switch string(Message) {
+			case "ping":
+				dmLock.Lock()
+				err = writeWs(conn, []byte("pong"))
+				dmLock.Unlock()
 				if err != nil {
 					return
 				}
-				switch string(Message) {
-				case "ping":
-					err := conn.WriteMessage(websocket.TextMessage, []byte("pong"))
-					if err != nil {
-						return
-					}
-				}
-			}
-		}()
-
-		for {
-			Chat := <-DmChatChan
-			ConnMapCopy := DmConnMap
-			for w := range ConnMapCopy {
-				err = w.WriteMessage(websocket.TextMessage, Chat)
-				if err != nil {
-					slog.Error("Failed to write message:", err)
-					delete(DmConnMap, w)
-				}
 			}
 		}
 	})
@@ -170,7 +218,7 @@ func WebServer() *http.ServeMux {
 			w.Header().Set("Content-Type", "image/png")
 			w.Header().Set("Cache-Control", "public, max-age=86400") // 缓存1天
 		}
-		http.FileServer(http.Dir("Resource")).ServeHTTP(w, r)
+		http.FileServer(http.Dir(ResourceDir())).ServeHTTP(w, r)
 	})))
 
 	// 静态资源处理（修复字体文件404问题）
@@ -180,7 +228,7 @@ func WebServer() *http.ServeMux {
 			w.Header().Set("Content-Type", "font/ttf")
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 		}
-		http.FileServer(http.Dir("Resource/web")).ServeHTTP(w, r)
+		http.FileServer(http.Dir(filepath.Join(ResourceDir(), "web"))).ServeHTTP(w, r)
 	})))
 
 	mux.HandleFunc("/web", func(writer http.ResponseWriter, request *http.Request) {
@@ -198,15 +246,16 @@ func WebServer() *http.ServeMux {
 	})
 
 	mux.HandleFunc("/default.css", func(writer http.ResponseWriter, request *http.Request) {
+		// 配置档案目录中的自定义样式优先于内置样式
 		var found bool
-		dir, err := os.ReadDir("./")
+		dir, err := os.ReadDir(ProfileDir)
 		if err != nil {
 			return
 		}
 		for _, file := range dir {
 			if strings.HasSuffix(file.Name(), ".css") {
 				found = true
-				readFile, err := os.ReadFile(file.Name())
+				readFile, err := os.ReadFile(filepath.Join(ProfileDir, file.Name()))
 				if err != nil {
 					return
 				}
@@ -229,9 +278,13 @@ func WebServer() *http.ServeMux {
 		}
 	})
 
-	// 静态同步接口
+	// 静态同步接口，queue 为队列标识，留空为默认队列
 	mux.HandleFunc("/getAllLine", func(writer http.ResponseWriter, request *http.Request) {
-		lineJson, err := json.Marshal(line)
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		lineJson, err := json.Marshal(q.Engine.Snapshot())
 		if err != nil {
 			return
 		}
@@ -242,15 +295,70 @@ func WebServer() *http.ServeMux {
 	})
 
 	mux.HandleFunc("/getLineLength", func(writer http.ResponseWriter, request *http.Request) {
-		LineLength := len(line.GuardLine) + len(line.GiftLine) + len(line.CommonLine)
-		_, err := writer.Write([]byte(strconv.Itoa(LineLength)))
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		_, err := writer.Write([]byte(strconv.Itoa(q.Engine.Len())))
+		if err != nil {
+			return
+		}
+	})
+
+	// 等候名单，按加入顺序排列
+	mux.HandleFunc("/getWaitlist", func(writer http.ResponseWriter, request *http.Request) {
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		WaitlistJson, err := json.Marshal(q.Waitlist.List())
+		if err != nil {
+			return
+		}
+		_, _ = writer.Write(WaitlistJson)
+	})
+
+	mux.HandleFunc("/getSuperChat", func(writer http.ResponseWriter, request *http.Request) {
+		ScJson, err := json.Marshal(superChats.Active())
+		if err != nil {
+			return
+		}
+		_, err = writer.Write(ScJson)
+		if err != nil {
+			return
+		}
+	})
+
+	// 全部队列的标识和名称
+	mux.HandleFunc("/getQueues", func(writer http.ResponseWriter, request *http.Request) {
+		type queueInfo struct {
+			Name   string
+			Title  string
+			Length int
+			Paused bool
+		}
+		var res []queueInfo
+		for _, q := range queues.All() {
+			res = append(res, queueInfo{Name: q.Name, Title: q.Title(), Length: q.Engine.Len(), Paused: q.Paused()})
+		}
+		QueuesJson, err := json.Marshal(res)
+		if err != nil {
+			return
+		}
+		_, _ = writer.Write(QueuesJson)
+	})
+
+	// 本场叫号记录，最新的在前
+	mux.HandleFunc("/getServeHistory", func(writer http.ResponseWriter, request *http.Request) {
+		HistoryJson, err := json.Marshal(serveHistory.History())
 		if err != nil {
 			return
 		}
+		_, _ = writer.Write(HistoryJson)
 	})
 
 	mux.HandleFunc("/getConfig", func(writer http.ResponseWriter, request *http.Request) {
-		ConfigJsonByte, err := json.Marshal(globalConfiguration)
+		ConfigJsonByte, err := json.Marshal(Configuration())
 		if err != nil {
 			return
 		}
@@ -260,6 +368,279 @@ func WebServer() *http.ServeMux {
 		}
 	})
 
+	// 修改配置，请求体为 JSON，只需包含要修改的字段，校验不通过时返回全部错误
+	mux.HandleFunc("/setConfig", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) || !checkJsonBody(writer, request) {
+			return
+		}
+		// 在当前配置的副本上修改，避免解析到一半的内容影响正在使用的配置
+		var Config RunConfig
+		if CurrentJson, err := json.Marshal(Configuration()); err == nil {
+			_ = json.Unmarshal(CurrentJson, &Config)
+		}
+		if err := json.NewDecoder(request.Body).Decode(&Config); err != nil {
+			http.Error(writer, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		Config = ConfigWithDefaults(Config)
+		if err := ValidateConfig(Config); err != nil {
+			var errs ConfigErrors
+			errors.As(err, &errs)
+			ErrJson, _ := json.Marshal(errs)
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write(ErrJson)
+			return
+		}
+		if err := queues.CheckRemoval(Config); err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		if !SetConfig(Config) {
+			http.Error(writer, "config write failed", http.StatusInternalServerError)
+			return
+		}
+		if err := ApplyConfig(Config); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadGateway)
+			return
+		}
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/getBlacklist", func(writer http.ResponseWriter, request *http.Request) {
+		BlacklistJson, err := json.Marshal(blacklist.List())
+		if err != nil {
+			return
+		}
+		_, _ = writer.Write(BlacklistJson)
+	})
+
+	// 拉黑用户，duration 为秒数，留空或0为永久，用户在队列中时一并移出
+	mux.HandleFunc("/addBlacklist", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		OpenID := request.FormValue("OpenID")
+		UserName := request.FormValue("UserName")
+		if UserName == "" {
+			UserName = OpenID
+		}
+		var Seconds int
+		if Value := request.FormValue("duration"); Value != "" {
+			var err error
+			if Seconds, err = strconv.Atoi(Value); err != nil || Seconds < 0 {
+				http.Error(writer, "invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := OperatorBan(OpenID, UserName, request.FormValue("reason"), time.Duration(Seconds)*time.Second); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/removeBlacklist", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		err := OperatorUnban(request.FormValue("OpenID"))
+		switch {
+		case errors.Is(err, ErrNotBlacklisted):
+			http.Error(writer, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		default:
+			_, _ = writer.Write([]byte("OK"))
+		}
+	})
+
+	// 恢复队列到指定时间点，time 为秒级时间戳或 "2006-01-02 15:04:05"
+	mux.HandleFunc("/restoreLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		At, err := ParseRestoreTime(request.FormValue("time"))
+		if err != nil {
+			http.Error(writer, "invalid time", http.StatusBadRequest)
+			return
+		}
+		if err = q.Engine.RestoreAt(At); err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	// 调整用户位置，action 为 up/down/top/bottom/to/promote/demote/queue
+	// action=to 时 index 为目标下标(从0开始)，可选 line 指定目标层级下标
+	// action=queue 时 queue 为目标队列标识
+	mux.HandleFunc("/moveLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		OpenID := request.FormValue("OpenID")
+		Action := request.FormValue("action")
+		var err error
+		Index := 0
+		if Action == MoveTo {
+			if Index, err = strconv.Atoi(request.FormValue("index")); err != nil {
+				http.Error(writer, "invalid index", http.StatusBadRequest)
+				return
+			}
+		}
+		if Action == MoveQueue {
+			err = OperatorMoveQueue(OpenID, request.FormValue("queue"))
+		} else if LineValue := request.FormValue("line"); Action == MoveTo && LineValue != "" {
+			var LineType int
+			if LineType, err = strconv.Atoi(LineValue); err != nil {
+				http.Error(writer, "invalid line", http.StatusBadRequest)
+				return
+			}
+			err = OperatorTransfer(OpenID, LineType, Index)
+		} else {
+			err = OperatorMoveAction(OpenID, Action, Index)
+		}
+		switch {
+		case errors.Is(err, ErrUserNotInLine), errors.Is(err, ErrQueueNotFound):
+			http.Error(writer, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+		default:
+			_, _ = writer.Write([]byte("OK"))
+		}
+	})
+
+	// 叫号，移出队列中优先级最高的第一位用户并返回该用户
+	mux.HandleFunc("/nextLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		User, err := OperatorNext(q)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		UserJson, err := json.Marshal(User)
+		if err != nil {
+			return
+		}
+		_, _ = writer.Write(UserJson)
+	})
+
+	mux.HandleFunc("/removeLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		if err := OperatorRemove(request.FormValue("OpenID")); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	// 切换用户在场状态，返回切换后的状态
+	mux.HandleFunc("/toggleOnline", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		IsOnline, err := OperatorToggleOnline(request.FormValue("OpenID"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		_, _ = writer.Write([]byte(strconv.FormatBool(IsOnline)))
+	})
+
+	// 暂停或恢复排队，paused 为 true/false
+	mux.HandleFunc("/pauseLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		Paused, err := strconv.ParseBool(request.FormValue("paused"))
+		if err != nil {
+			http.Error(writer, "invalid paused", http.StatusBadRequest)
+			return
+		}
+		q.SetPaused(Paused)
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/clearLine", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		q, ok := requestQueue(writer, request)
+		if !ok {
+			return
+		}
+		OperatorClear(q)
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	// 撤销或重做最近一次操作员操作，返回操作名
+	mux.HandleFunc("/undo", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		name, err := operatorHistory.Undo()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		_, _ = writer.Write([]byte(name))
+	})
+
+	mux.HandleFunc("/redo", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		name, err := operatorHistory.Redo()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		_, _ = writer.Write([]byte(name))
+	})
+
+	// 使用当前配置的身份码重新连接直播间
+	mux.HandleFunc("/reconnect", func(writer http.ResponseWriter, request *http.Request) {
+		if !checkControlPost(writer, request) {
+			return
+		}
+		if err := ReconnectRoom(Configuration().IdCode); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadGateway)
+			return
+		}
+		_, _ = writer.Write([]byte("OK"))
+	})
+
+	// 运行状态：配置档案、直播间和连接状态
+	mux.HandleFunc("/getStatus", func(writer http.ResponseWriter, request *http.Request) {
+		StatusJson, err := json.Marshal(struct {
+			Profile    string
+			ProfileDir string
+			RoomId     int
+			Connected  bool
+		}{Profile, ProfileDir, RoomId, AppClient != nil})
+		if err != nil {
+			return
+		}
+		_, _ = writer.Write(StatusJson)
+	})
+
 	mux.HandleFunc("/EXIT", func(writer http.ResponseWriter, request *http.Request) {
 		// 添加权限验证
 		if request.RemoteAddr != "127.0.0.1" {
@@ -272,3 +653,68 @@ func WebServer() *http.ServeMux {
 
 	return mux
 }
+
+// requestQueue 按请求参数 queue 查找队列，留空为默认队列
+func requestQueue(writer http.ResponseWriter, request *http.Request) (*Queue, bool) {
+	q, err := queues.Get(request.FormValue("queue"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return q, true
+}
+
+// checkControlPost 修改类接口的统一校验：只接受 POST 请求，携带令牌的请求可从任意主机发起
+// 未携带令牌时只接受本机发起的请求，浏览器发起时来源页面也必须在本机，防止其他网页伪造请求
+func checkControlPost(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if checkToken(request) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil || !net.ParseIP(host).IsLoopback() || !isLoopbackHost(request.Host) || !checkOrigin(request) {
+		http.Error(writer, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkOrigin 浏览器发起的请求带有 Origin，只接受本机页面，未带 Origin 的请求来自脚本等非浏览器客户端
+func checkOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return isLoopbackHost(u.Host)
+}
+
+// isLoopbackHost 主机名是否指向本机，可带端口，按名称判断，避免 DNS 重绑定的域名通过校验
+func isLoopbackHost(HostPort string) bool {
+	host := HostPort
+	if h, _, err := net.SplitHostPort(HostPort); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkJsonBody 请求体必须为 JSON，表单无法伪造此类请求
+func checkJsonBody(writer http.ResponseWriter, request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(writer, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
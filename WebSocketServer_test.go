@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWsSendWithoutClients 没有页面连接时推送不会因通道写满而阻塞
+func TestWsSendWithoutClients(t *testing.T) {
+	n := cap(QueueChatChan) + cap(DmChatChan) + 10
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			SendDmEventToWs("test", i)
+			SendDelToWs(DefaultQueueName, 0, i, "user")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("推送阻塞")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"time"
@@ -13,6 +15,7 @@ import (
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 )
 
@@ -27,7 +30,6 @@ var (
 	CtrlWindows           fyne.Window
 	SpecialUserSetWindows fyne.Window
 
-	line                LineRow
 	SpecialUserList     map[string]SpecialUserStruct
 	globalConfiguration RunConfig
 
@@ -44,18 +46,23 @@ var logger *slog.Logger
 //var DanmuDataChan = make(chan *proto.CmdDanmuData, 20)
 
 func main() {
-	// 为全局变量赋值
-	line.GuardIndex = make(map[string]int)
-	line.GiftIndex = make(map[string]int)
-	line.CommonIndex = make(map[string]int)
-
-	lineTemp, err := GetLine()
-	if err == nil && !lineTemp.IsEmpty() {
-		line = lineTemp
+	DataFlag := flag.String("data", "", "数据目录，也可通过环境变量 "+DataDirEnv+" 指定")
+	ProfileFlag := flag.String("profile", "", "配置档案，也可通过环境变量 "+ProfileEnv+" 指定")
+	HeadlessFlag := flag.Bool("headless", false, "不创建窗口运行，通过本机接口和队列页面控制")
+	ListenFlag := flag.String("listen", "", "页面服务监听地址，默认 "+DefaultListenAddr+"，也可通过环境变量 "+ListenEnv+" 指定")
+	TokenFlag := flag.String("token", "", "控制接口令牌，携带令牌时可从其他主机调用，也可通过环境变量 "+TokenEnv+" 指定，未指定时每次运行生成并写入 "+ControlTokenFile)
+	flag.Parse()
+	// 数据目录不可用时退回到工作目录
+	if err := InitDataDir(*DataFlag, *ProfileFlag); err != nil {
+		fmt.Println("数据目录初始化失败:", err)
+	}
+	if err := InitControlAccess(*ListenFlag, *TokenFlag); err != nil {
+		fmt.Println("监听地址或控制接口令牌无效:", err)
+		os.Exit(2)
 	}
 
 	r := &lumberjack.Logger{
-		Filename:   "./BLine.log",
+		Filename:   DataPath("BLine.log"),
 		LocalTime:  true,
 		MaxSize:    1,
 		MaxAge:     3,
@@ -66,9 +73,12 @@ func main() {
 	logger = slog.New(slog.NewJSONHandler(r, nil))
 	slog.SetDefault(logger)
 
-	//go ResponseQueCtrl()
+	if *HeadlessFlag {
+		RunHeadless()
+		return
+	}
 
-	// CleanOldVersion()
+	//go ResponseQueCtrl()
 
 	svgResource = fyne.NewStaticResource("icon.svg", icon)
 	// 资源初始化区域
@@ -89,26 +99,38 @@ func main() {
 	MainWindows = App.NewWindow("未初始化")
 	MainWindows.SetIcon(svgResource)
 
-	//var err error
-
 	// 修改连接逻辑
 	var retryCount int
 	for {
-		globalConfiguration, err = GetConfig()
+		Config, err := GetConfig()
 		if err != nil {
 			slog.Error("Get config Err", err)
-			MainWindows.SetContent(MakeConfigUI(MainWindows, RunConfig{}))
+			queues.Apply(RunConfig{})
+			// 配置校验不通过时在配置页面中修改，配置文件存在但无法读取时提示从备份恢复
+			var configErrs ConfigErrors
+			switch {
+			case errors.As(err, &configErrs):
+				MainWindows.SetContent(MakeConfigUI(MainWindows, Config))
+				dialog.ShowError(configErrs, MainWindows)
+			case os.IsNotExist(err):
+				MainWindows.SetContent(MakeConfigUI(MainWindows, RunConfig{}))
+			default:
+				MainWindows.SetContent(MakeConfigUI(MainWindows, RunConfig{}))
+				ShowBackupWindow()
+			}
 			break
 		}
+		setConfiguration(Config)
+		// 队列由快照和日志回放得到，层级以配置为准，旧版队列会在这里转换为层级结构
+		queues.Apply(Config)
 
-		client, gameId, wsClient, closeChan := RoomConnect(globalConfiguration.IdCode)
-		if client != nil { // 仅当连接成功时退出循环
+		client, gameId, wsClient, closeChan, err := RoomConnect(Config.IdCode)
+		if err == nil { // 仅当连接成功时退出循环
 			AppClient = client
 			CloseHeartbeatChan = closeChan
 			GameId = gameId
 			WsClient = wsClient
-			KeyWordMatchInit(globalConfiguration.LineKey)
-			MainWindows.SetContent(MakeMainUI(MainWindows, globalConfiguration))
+			MainWindows.SetContent(MakeMainUI(MainWindows, Config))
 			break
 		}
 
@@ -122,6 +144,10 @@ func main() {
 		time.Sleep(5 * time.Second)
 	}
 
+	go RunPresenceSweeper()
+	go RunRollCall()
+	go WatchConfig()
+
 	//初始化控制界面
 	CtrlWindows = App.NewWindow("控制界面 点击两次 ╳ 退出")
 	CtrlWindows.SetIcon(svgResource)
@@ -131,6 +157,7 @@ func main() {
 	CtrlWindows.SetCloseIntercept(func() {
 		ClickCount++
 		if ClickCount > 1 {
+			Shutdown()
 			CtrlWindows.Close()
 			App.Quit()
 			os.Exit(0)
@@ -147,7 +174,13 @@ func main() {
 	CtrlWindows.SetContent(MakeCtrlUI(CtrlWindows))
 	CtrlWindows.Show()
 
-	go StartWebServer()
+	go func() {
+		if err := StartWebServer(); err != nil {
+			fyne.Do(func() {
+				dialog.ShowError(DisplayError{Message: "页面服务启动失败，请检查端口是否被占用：" + err.Error()}, MainWindows)
+			})
+		}
+	}()
 	MainWindows.Show()
 	App.Run()
 }
@@ -5,19 +5,26 @@ import (
 )
 
 const (
-	// GuardLineType 舰长队列标识码
-	GuardLineType = 0
-	// GiftLineType 礼物队列标识码
-	GiftLineType = 1
-	// CommonLineType 普通队列标识码
-	CommonLineType = 2
-
 	// OpDelete 删除操作标识码
 	OpDelete = 0
-	// OpAdd 添加操作标识码
+	// OpAdd 添加操作标识码，Index 为加入后的下标
 	OpAdd = 1
 	// OpWhere 寻址操作标识码
 	OpWhere = 2
+	// OpStatus 在场状态更新操作码，Line 为更新后的用户信息
+	OpStatus = 3
+	// OpMove 移动操作标识码，Index 为移动后的下标
+	OpMove = 4
+	// OpReload 重新加载操作标识码，前端需重新拉取完整队列
+	OpReload = 5
+	// OpReject 排队被拒绝操作标识码，Reason 为拒绝原因
+	OpReject = 6
+	// OpCall 叫号确认操作码，Line 为被叫用户，Deadline 为确认截止的毫秒时间戳，已确认时为0
+	OpCall = 7
+	// OpWaitlist 等候名单变化操作码，Index 为等候人数
+	OpWaitlist = 8
+	// OpConfig 配置变化操作码，前端需重新拉取配置和完整队列
+	OpConfig = 9
 )
 
 // RoomInfo 直播间信息
@@ -94,65 +101,86 @@ type RoomInfo struct {
 	} `json:"data"`
 }
 
-// LineRow 队列信息
+// LineRow 队列信息，Tiers 按优先级从高到低排列
 type LineRow struct {
-	GuardLine   []Line
-	GiftLine    []GiftLine
-	CommonLine  []Line
-	GuardIndex  map[string]int
-	GiftIndex   map[string]int
-	CommonIndex map[string]int
+	// 队列文件的结构版本，见 LineSchemaVersion
+	SchemaVersion int `json:",omitempty"`
+	Tiers         []TierLine
 }
 
-// UpdateIndex 更新队列索引，传入UpdateType 0为GuardLine 1为GiftLine 2为CommonLine
-func (r LineRow) UpdateIndex(UpdateType int) {
-	switch UpdateType {
-	case 0:
-		for i, l := range r.GuardLine {
-			r.GuardIndex[l.OpenID] = i + 1
-		}
+// TierLine 单个层级的队列，Sort 为层级内的排序方式
+type TierLine struct {
+	Name  string
+	Sort  string
+	Users []Line
+}
+
+// Clone 深拷贝队列信息
+func (r LineRow) Clone() LineRow {
+	c := LineRow{Tiers: make([]TierLine, len(r.Tiers))}
+	for i, t := range r.Tiers {
+		c.Tiers[i] = TierLine{Name: t.Name, Sort: t.Sort, Users: append([]Line{}, t.Users...)}
+	}
+	return c
+}
+
+// GuardLevelName 大航海等级名称
+func GuardLevelName(GuardLevel int) string {
+	switch GuardLevel {
 	case 1:
-		for i, l := range r.GiftLine {
-			r.GiftIndex[l.OpenID] = i + 1
-		}
+		return "总督"
 	case 2:
-		for i, l := range r.CommonLine {
-			r.CommonIndex[l.OpenID] = i + 1
-		}
+		return "提督"
+	case 3:
+		return "舰长"
 	}
+	return ""
 }
 
-// Line 单一队列基础信息
+// Line 队列用户信息
 type Line struct {
-	OpenID     string    `json:"open_id"`
-	UserName   string    `json:"UserName"`
-	Avatar     string    `json:"Avatar"`
-	PrintColor LineColor `json:"PrintColor"`
-	IsOnline   bool      `json:"is_online"`
+	OpenID         string    `json:"open_id"`
+	UserName       string    `json:"UserName"`
+	Avatar         string    `json:"Avatar"`
+	PrintColor     LineColor `json:"PrintColor"`
+	IsOnline       bool      `json:"is_online"`
+	GuardLevel     int       `json:"GuardLevel,omitempty"` // 大航海等级 1总督 2提督 3舰长，0为非大航海
+	FansMedalLevel int       `json:"FansMedalLevel,omitempty"`
+	GiftName       string    `json:"GiftName,omitempty"`  // 最近一次礼物名
+	GiftPrice      float64   `json:"GiftPrice,omitempty"` // 累计礼物价值(电池)
+	Note           string    `json:"Note,omitempty"`      // 排队指令后附带的备注
+	AwaySince      int64     `json:"AwaySince,omitempty"` // 标记为不在的秒级时间戳，在场时为0
+	Idle           bool      `json:"Idle,omitempty"`      // 长时间无互动被自动标记为不在，再次互动时恢复，不计入暂离超时
 }
 
-// GiftLine 礼物用户队列信息
-type GiftLine struct {
-	OpenID     string    `json:"open_id"`
-	UserName   string    `json:"UserName"`
-	Avatar     string    `json:"Avatar"`
-	PrintColor LineColor `json:"PrintColor"`
-	GiftName   string    `json:"GiftName"` // 添加礼物名字段
-	GiftPrice  float64   `json:"GiftPrice"`
-	IsOnline   bool      `json:"is_online"`
-}
-
-// WsPack 前端通讯Websocket包结构
+// WsPack 前端通讯Websocket包结构，LineType 为层级下标
 type WsPack struct {
 	OpMessage int
 	Index     int
 	LineType  int
 	Line      Line
-	GiftLine  GiftLine
+	Reason    string `json:",omitempty"`
+	Deadline  int64  `json:",omitempty"`
 }
 
+// DmWsEvent 弹幕页面的非弹幕事件，普通弹幕仍直接发送 CmdDanmuData
+type DmWsEvent struct {
+	EventType string
+	Data      interface{}
+}
+
+// 弹幕页面事件类型
+const (
+	DmEventGuard        = "guard"
+	DmEventSuperChat    = "superchat"
+	DmEventSuperChatDel = "superchat_del"
+	DmEventConfig       = "config"
+)
+
 // RunConfig 配置格式
 type RunConfig struct {
+	//配置文件的结构版本，见 ConfigSchemaVersion
+	SchemaVersion           int
 	IdCode                  string
 	GuardPrintColor         LineColor
 	GiftPrintColor          LineColor
@@ -173,6 +201,42 @@ type RunConfig struct {
 	//自动滚动队列
 	AutoScrollLine  bool
 	SpecialUserList map[string]SpecialUserStruct
+	//弹幕带有大航海等级的用户自动加入舰长队列
+	GuardAutoJoin bool
+	//大航海用户不受队列最大容量限制
+	GuardIgnoreMaxLine bool
+	//仅限付费用户排队时大航海用户仍可排队
+	GuardIgnoreOnlyGift bool
+	//开通大航海的用户自动加入舰长队列
+	GuardBuyAutoJoin bool
+	//开通大航海的用户在大航海有效期内成为特殊用户
+	GuardBuySpecialUser bool
+	//发送醒目留言的用户加入礼物队列，醒目留言金额按电池计入礼物价值
+	SuperChatJoinLine bool
+	//醒目留言加入礼物队列的最低价值(电池)，0为不限
+	SuperChatLinePrice float64
+	//礼物入队规则
+	GiftRules GiftRuleConfig
+	//队列层级，为空时使用由舰长、礼物、普通组成的默认层级
+	LineTiers []LineTier
+	//默认队列之外的命名队列
+	Queues []QueueConfig
+	//重新排队冷却和每场叫号次数限制
+	ServeRules ServeRuleConfig
+	//默认队列的弹幕排队资格要求
+	JoinRule JoinRule
+	//队列页面显示用户备注
+	NoteDisplay bool
+	//弹幕指令，为空时使用默认指令
+	Commands []CommandConfig
+	//可以通过弹幕指令控制队列的房管，主播总是可以
+	Moderators []ModeratorConfig
+	//标记为不在超过该时间(分钟)后自动移出队列，0为不自动移出
+	AwayTimeout int
+	//超过该时间(分钟)没有弹幕、礼物、点赞等互动时自动标记为不在，0为不自动标记
+	IdleTimeout int
+	//队首用户需发送确认弹幕，超时按配置后移或移出
+	RollCall RollCallConfig
 }
 
 // SpecialUserStruct 特殊用户配置
@@ -202,9 +266,12 @@ func (lc LineColor) IsEmpty() bool {
 }
 
 func (r LineRow) IsEmpty() bool {
-	return len(r.GuardLine) == 0 &&
-		len(r.GiftLine) == 0 &&
-		len(r.CommonLine) == 0
+	for _, t := range r.Tiers {
+		if len(t.Users) > 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // VersionSct 版本检查结构
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	_ "embed"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -59,38 +58,24 @@ func RemoveTags(str string) string {
 }
 
 // 重构SendLineToWs函数消除重复代码
-func SendLineToWs(NormalLine Line, Gift GiftLine, LineType int) {
-	var send WsPack
-	var hasContent bool
-
-	switch {
-	case len(NormalLine.OpenID) > 0:
-		send = WsPack{
-			OpMessage: OpAdd,
-			LineType:  LineType,
-			Line:      NormalLine,
-		}
-		hasContent = true
-	case len(Gift.OpenID) > 0:
-		send = WsPack{
-			OpMessage: OpAdd,
-			LineType:  LineType,
-			GiftLine:  Gift,
-		}
-		hasContent = true
-	default:
-		slog.Debug("发送空数据包", slog.Any("NormalLine", NormalLine), slog.Any("Gift", Gift))
+func SendLineToWs(Queue string, LineType, Index int, User Line) {
+	if len(User.OpenID) == 0 {
+		slog.Debug("发送空数据包", slog.Any("User", User))
 		return
 	}
 
-	if hasContent {
-		SendWsJson, err := json.Marshal(send)
-		if err != nil {
-			slog.Error("WebSocket数据封禁失败", err, slog.Any("send", send))
-			return
-		}
-		QueueChatChan <- SendWsJson
+	send := WsPack{
+		OpMessage: OpAdd,
+		Index:     Index,
+		LineType:  LineType,
+		Line:      User,
+	}
+	SendWsJson, err := json.Marshal(send)
+	if err != nil {
+		slog.Error("WebSocket数据封禁失败", err, slog.Any("send", send))
+		return
 	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
 }
 
 func SendDmToWs(Dm *proto.CmdDanmuData) {
@@ -101,6 +86,15 @@ func SendDmToWs(Dm *proto.CmdDanmuData) {
 	DmChatChan <- SendDmWsJson
 }
 
+// SendDmEventToWs 向弹幕页面推送非弹幕事件
+func SendDmEventToWs(EventType string, Data interface{}) {
+	SendWsJson, err := json.Marshal(DmWsEvent{EventType: EventType, Data: Data})
+	if err != nil {
+		return
+	}
+	DmChatChan <- SendWsJson
+}
+
 func SendMusicServer(Path, Keyword string) {
 	for i := 0; i < 3; i++ {
 		get, err := http.Get("http://127.0.0.1:99/" + Path + "?keyword=" + Keyword)
@@ -113,7 +107,7 @@ func SendMusicServer(Path, Keyword string) {
 	}
 }
 
-func SendDelToWs(LineType, index int, OpenId string) {
+func SendDelToWs(Queue string, LineType, index int, OpenId string) {
 	Send := WsPack{
 		OpMessage: OpDelete,
 		Index:     index,
@@ -126,10 +120,10 @@ func SendDelToWs(LineType, index int, OpenId string) {
 	if err != nil {
 		return
 	}
-	QueueChatChan <- SendWsJson
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
 }
 
-func SendWhereToWs(OpenId string) {
+func SendWhereToWs(Queue string, OpenId string) {
 	Send := WsPack{
 		OpMessage: OpWhere,
 		Line: Line{
@@ -140,86 +134,130 @@ func SendWhereToWs(OpenId string) {
 	if err != nil {
 		return
 	}
-	QueueChatChan <- SendWsJson
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
 }
 
-// 新增函数：发送状态更新到WebSocket
-func sendStatusUpdate(openID string, isOnline bool) {
-	updateMsg := map[string]interface{}{
-		"OpMessage": 3,        // 状态更新操作码
-		"OpenID":    openID,   // 用户唯一标识
-		"is_online": isOnline, // 新的在线状态
+// SendRejectToWs 通知队列页面用户未能加入队列及原因
+func SendRejectToWs(Queue string, User Line, Reason string) {
+	Send := WsPack{
+		OpMessage: OpReject,
+		Line: Line{
+			OpenID:   User.OpenID,
+			UserName: User.UserName,
+		},
+		Reason: Reason,
 	}
-	SendWsJson, err := json.Marshal(updateMsg)
+	SendWsJson, err := json.Marshal(Send)
 	if err != nil {
-		slog.Error("序列化状态更新消息失败", err)
 		return
 	}
-	QueueChatChan <- SendWsJson
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
 }
 
-// 修改DeleteLine函数，增强错误检查和日志记录
-func DeleteLine(OpenId string) error {
-	// 添加防御性检查
-	if OpenId == "" {
-		slog.Error("尝试删除空OpenID")
-		return fmt.Errorf("empty OpenID provided")
+// SendCallToWs 通知队列页面叫号确认状态，Deadline 为0时表示用户已确认
+func SendCallToWs(Queue string, LineType int, User Line, Deadline int64) {
+	Send := WsPack{
+		OpMessage: OpCall,
+		LineType:  LineType,
+		Line:      User,
+		Deadline:  Deadline,
+	}
+	SendWsJson, err := json.Marshal(Send)
+	if err != nil {
+		return
 	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
+}
 
-	if idx, ok := line.GuardIndex[OpenId]; ok {
-		if idx > 0 && idx <= len(line.GuardLine) {
-			line.GuardLine = append(line.GuardLine[:idx-1], line.GuardLine[idx:]...)
-			SendDelToWs(GuardLineType, idx-1, OpenId)
-			delete(line.GuardIndex, OpenId)
-			line.UpdateIndex(GuardLineType)
-			SetLine(line)
-			return nil
-		}
-		slog.Error("GuardLine索引越界", slog.Int("index", idx), slog.String("OpenID", OpenId))
-		return fmt.Errorf("guard index out of bounds for OpenID: %s", OpenId)
+// SendWaitlistToWs 通知队列页面等候人数
+func SendWaitlistToWs(Queue string, Size int) {
+	SendWsJson, err := json.Marshal(WsPack{OpMessage: OpWaitlist, Index: Size})
+	if err != nil {
+		return
 	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
+}
 
-	if idx, ok := line.GiftIndex[OpenId]; ok {
-		if idx > 0 && idx <= len(line.GiftLine) {
-			line.GiftLine = append(line.GiftLine[:idx-1], line.GiftLine[idx:]...)
-			SendDelToWs(GiftLineType, idx-1, OpenId)
-			delete(line.GiftIndex, OpenId)
-			line.UpdateIndex(GiftLineType)
-			SetLine(line)
-			return nil
-		}
-		slog.Error("GiftLine索引越界", slog.Int("index", idx), slog.String("OpenID", OpenId))
-		return fmt.Errorf("gift index out of bounds for OpenID: %s", OpenId)
+func SendMoveToWs(Queue string, LineType, index int, OpenId string) {
+	Send := WsPack{
+		OpMessage: OpMove,
+		Index:     index,
+		LineType:  LineType,
+		Line: Line{
+			OpenID: OpenId,
+		},
+	}
+	SendWsJson, err := json.Marshal(Send)
+	if err != nil {
+		return
 	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
+}
 
-	if idx, ok := line.CommonIndex[OpenId]; ok {
-		if idx > 0 && idx <= len(line.CommonLine) {
-			line.CommonLine = append(line.CommonLine[:idx-1], line.CommonLine[idx:]...)
-			SendDelToWs(CommonLineType, idx-1, OpenId)
-			delete(line.CommonIndex, OpenId)
-			line.UpdateIndex(CommonLineType)
-			SetLine(line)
-			return nil
-		}
-		slog.Error("CommonLine索引越界", slog.Int("index", idx), slog.String("OpenID", OpenId))
-		return fmt.Errorf("common index out of bounds for OpenID: %s", OpenId)
+func SendReloadToWs(Queue string) {
+	SendWsJson, err := json.Marshal(WsPack{OpMessage: OpReload})
+	if err != nil {
+		return
 	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
+}
 
-	slog.Warn("未找到用户或无效索引", slog.String("OpenID", OpenId))
-	return fmt.Errorf("user not found or invalid index for OpenID: %s", OpenId)
+// SendConfigToWs 通知全部队列页面和弹幕页面配置已变化
+func SendConfigToWs() {
+	SendWsJson, err := json.Marshal(WsPack{OpMessage: OpConfig})
+	if err != nil {
+		return
+	}
+	for _, q := range queues.All() {
+		QueueChatChan <- QueueWsMessage{Queue: q.Name, Data: SendWsJson}
+	}
+	SendDmEventToWs(DmEventConfig, nil)
 }
 
-func DeleteFirst() error {
-	if len(line.GuardLine) > 0 {
-		return DeleteLine(line.GuardLine[0].OpenID)
+// SendStatusToWs 通知队列页面用户在场状态变化，操作员切换和用户暂离、回来使用同一消息
+func SendStatusToWs(Queue string, LineType, index int, User Line) {
+	Send := WsPack{
+		OpMessage: OpStatus,
+		Index:     index,
+		LineType:  LineType,
+		Line:      User,
 	}
-	if len(line.GiftLine) > 0 {
-		return DeleteLine(line.GiftLine[0].OpenID)
+	SendWsJson, err := json.Marshal(Send)
+	if err != nil {
+		slog.Error("序列化状态更新消息失败", err)
+		return
+	}
+	QueueChatChan <- QueueWsMessage{Queue: Queue, Data: SendWsJson}
+}
+
+// DeleteLine 用户取消排队，移出所在队列并开始重新排队冷却，空出的位置由等候名单补上
+// 用户在等候名单中时只移出等候名单
+func DeleteLine(OpenId string) error {
+	q, ok := queues.Find(OpenId)
+	if !ok {
+		if LeaveWaitlist(OpenId) {
+			return nil
+		}
+		return ErrUserNotInLine
 	}
-	if len(line.CommonLine) > 0 {
-		return DeleteLine(line.CommonLine[0].OpenID)
+	le, err := q.Engine.Take(OpenId)
+	if err != nil {
+		return err
 	}
-	return errors.New("no users to delete")
+	serveHistory.Record(q.Name, le.Line, ServeRemoved)
+	PromoteWaitlist(q)
+	return nil
+}
+
+// DeleteFirst 叫号，移除指定队列的队首用户并计入叫号记录，空出的位置由等候名单补上
+func DeleteFirst(q *Queue) error {
+	le, err := q.Engine.Next()
+	if err != nil {
+		return err
+	}
+	serveHistory.Record(q.Name, le.Line, ServeServed)
+	PromoteWaitlist(q)
+	return nil
 }
 
 func assistUI() *fyne.Container {
@@ -276,17 +314,6 @@ func randomInt(min, max int) int {
 	return rand.Intn(max-min+1) + min
 }
 
-func CleanOldVersion() {
-	_, err := os.Stat("./Version " + NowVersion)
-	if err != nil {
-		_ = os.Remove("./line.json")
-		_ = os.Remove("./lineConfig.json")
-
-		_, _ = os.Create("./Version " + NowVersion)
-		return
-	}
-}
-
 func AgreeOpenUrl(url string) error {
 	var (
 		cmd  string
@@ -315,8 +342,8 @@ func Restart() {
 		fmt.Println("无法获取可执行文件路径:", err)
 		return
 	}
-	// 启动新进程来替换当前进程
-	cmd := exec.Command(exePath)
+	// 启动新进程来替换当前进程，沿用当前的数据目录和配置档案
+	cmd := exec.Command(exePath, restartArgs()...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err = cmd.Start()
@@ -341,7 +368,7 @@ func NewHeartbeat(client *live.Client, GameId string, CloseChan chan bool) {
 				}
 			case <-CloseChan:
 				tk.Stop()
-				break
+				return
 			}
 		}
 	}()